@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// downloadLimit is the -adaptive-concurrency controller of the run, nil
+// when downloads keep to a fixed -concurrency.
+var downloadLimit *adaptiveLimiter
+
+// adaptiveLimiter bounds the downloads in flight like the slots of a fixed
+// -concurrency, but moves the bound with the responses it observes: every
+// 429 or 403 halves it, and every round of as many successes as the bound
+// raises it by one again, up to the configured concurrency.
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	max      int
+	limit    int
+	inFlight int
+	// successes counts the responses since the bound last moved up
+	successes int
+	// sinceCut counts the responses since the bound was last cut, so the
+	// throttled responses of requests sent before the cut only cut it once
+	sinceCut int
+}
+
+func newAdaptiveLimiter(max int) *adaptiveLimiter {
+	l := &adaptiveLimiter{max: max, limit: max, sinceCut: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits until fewer downloads than the bound are in flight.
+func (l *adaptiveLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+func (l *adaptiveLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.cond.Broadcast()
+}
+
+// observe adjusts the bound to a response with status. A nil limiter
+// observes nothing, so requests need not check for -adaptive-concurrency.
+func (l *adaptiveLimiter) observe(status int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinceCut++
+	if status == http.StatusTooManyRequests || status == http.StatusForbidden {
+		l.successes = 0
+		if l.sinceCut < l.limit || l.limit == 1 {
+			return
+		}
+		l.limit /= 2
+		l.sinceCut = 0
+		logf("throttled, downloading %d assets at once\n", l.limit)
+		return
+	}
+	l.successes++
+	if l.successes >= l.limit && l.limit < l.max {
+		l.limit++
+		l.successes = 0
+		l.cond.Broadcast()
+	}
+}
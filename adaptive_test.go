@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterConverges(t *testing.T) {
+	const (
+		workers   = 8
+		threshold = 3
+		requests  = 400
+	)
+	var (
+		mu        sync.Mutex
+		inFlight  int
+		throttled []bool
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		limited := inFlight > threshold
+		throttled = append(throttled, limited)
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if limited {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	var out strings.Builder
+	downloadLimit, logOut = newAdaptiveLimiter(workers), &out
+	t.Cleanup(func() { downloadLimit, logOut = nil, os.Stderr })
+
+	var wg sync.WaitGroup
+	next := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range next {
+				downloadLimit.acquire()
+				resp, err := requestOnce(context.Background(), http.MethodGet, "https://example.com/logo.png", nil)
+				downloadLimit.release()
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		next <- struct{}{}
+	}
+	close(next)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	early, late := 0, 0
+	for i, limited := range throttled {
+		switch {
+		case !limited:
+		case i < requests/2:
+			early++
+		default:
+			late++
+		}
+	}
+	// the bound keeps probing above what the server allows, so it ends
+	// somewhere under the configured concurrency rather than at threshold
+	if downloadLimit.limit >= workers {
+		t.Errorf("the bound is still %d", downloadLimit.limit)
+	}
+	if late*4 > requests/2 || late >= early {
+		t.Errorf("%d of the first %d requests were throttled and %d of the last, want few late ones", early, requests/2, late)
+	}
+}
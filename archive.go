@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// runArchiveVerify checks that a fetched output directory is self-contained,
+// so it outlives the upstream repository: the README is there, renders
+// without the network and loads nothing from outside the directory. It
+// prints a checklist like validate does. External resources only fail the
+// check with -offline and are warned about otherwise. It reports whether
+// every check passed.
+func runArchiveVerify(dir string) bool {
+	readme := readmeName()
+	if _, err := os.Stat(filepath.Join(dir, readme)); err != nil {
+		readme = "README.html"
+	}
+	var page []byte
+
+	return runChecklist([]checklistItem{
+		{"README is present", func() error {
+			_, err := os.Stat(filepath.Join(dir, readme))
+			return err
+		}, false},
+		{"README renders offline", func() error {
+			content, err := os.ReadFile(filepath.Join(dir, readme))
+			if err != nil {
+				return err
+			}
+			if readme == "README.html" {
+				page = content
+				return nil
+			}
+			page, err = renderPage(readme, content)
+			return err
+		}, false},
+		{"local references of the README exist", func() error {
+			dangling, err := verifyLocal(dir, readme)
+			if err != nil {
+				return err
+			}
+			if len(dangling) > 0 {
+				return fmt.Errorf("missing %v", dangling)
+			}
+			return nil
+		}, false},
+		{"no resources are loaded from the network", func() error {
+			if page == nil {
+				return errSkipped
+			}
+			external, err := externalResources(page)
+			if err != nil {
+				return err
+			}
+			if len(external) > 0 {
+				return fmt.Errorf("still depends on %s", strings.Join(external, ", "))
+			}
+			return nil
+		}, !offline},
+	})
+}
+
+// externalResources lists the URLs a rendered page loads from the network
+// when displayed: images, media, scripts, stylesheets, frames and CSS
+// url()s. Plain links are not resources and are left out.
+func externalResources(page []byte) ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	add := func(ref string) {
+		ref = strings.TrimSpace(ref)
+		lower := strings.ToLower(ref)
+		if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "//") {
+			found[ref] = true
+		}
+	}
+	for _, attr := range []string{"src", "poster", "data", "background"} {
+		doc.Find("[" + attr + "]").Not("a").Each(func(_ int, s *goquery.Selection) {
+			add(s.AttrOr(attr, ""))
+		})
+	}
+	doc.Find("[srcset]").Each(func(_ int, s *goquery.Selection) {
+		for _, candidate := range strings.Split(s.AttrOr("srcset", ""), ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 {
+				add(fields[0])
+			}
+		}
+	})
+	doc.Find("link[href]").Each(func(_ int, s *goquery.Selection) {
+		add(s.AttrOr("href", ""))
+	})
+	css := []string{}
+	doc.Find("[style]").Each(func(_ int, s *goquery.Selection) {
+		css = append(css, s.AttrOr("style", ""))
+	})
+	doc.Find("style").Each(func(_ int, s *goquery.Selection) {
+		css = append(css, s.Text())
+	})
+	for _, style := range css {
+		for _, match := range cssURLRegex.FindAllStringSubmatch(style, -1) {
+			add(match[1] + match[2] + match[3])
+		}
+	}
+
+	external := make([]string, 0, len(found))
+	for ref := range found {
+		external = append(external, ref)
+	}
+	sort.Strings(external)
+	return external, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveVerify(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"README.md":    "# R\n\n![logo](img/logo.png)\n\n[Site](https://example.com/)\n",
+		"img/logo.png": "logo",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offline = true
+	t.Cleanup(func() { offline = false })
+	var ok bool
+	out := captureStdout(t, func() { ok = runArchiveVerify(dir) })
+	if !ok {
+		t.Fatalf("a self-contained bundle failed the check:\n%s", out)
+	}
+
+	readme := files["README.md"] + "\n![build](https://ci.example.com/badge.svg)\n"
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(readme), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, func() { ok = runArchiveVerify(dir) })
+	if ok {
+		t.Error("a bundle loading an external image passed the check")
+	}
+	if !strings.Contains(out, "https://ci.example.com/badge.svg") {
+		t.Errorf("the external image was not reported:\n%s", out)
+	}
+}
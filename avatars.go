@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// avatarWorkers bounds how many avatars -fetch-avatars downloads at once.
+// Contributor tables can list hundreds of small images.
+const avatarWorkers = 8
+
+var avatarRegex = regexp.MustCompile(`https://(?:avatars\d*\.githubusercontent\.com/[^\s"'()<>]+|github\.com/[A-Za-z0-9-]+\.png(?:\?[^\s"'()<>]*)?)`)
+
+// fetchAvatars downloads the GitHub avatars the saved README of src shows,
+// as in all-contributors tables, into its avatars directory and points the
+// README at the local copies. It returns the local paths of the avatars.
+func fetchAvatars(ctx context.Context, src source) ([]string, error) {
+	file := filepath.Join(src.dir(), readmeName())
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []string{}
+	seen := map[string]bool{}
+	mapProse(string(content), func(line string) string {
+		for _, link := range avatarRegex.FindAllString(line, -1) {
+			if !seen[link] {
+				seen[link] = true
+				found = append(found, link)
+			}
+		}
+		return line
+	})
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		slots  = make(chan struct{}, avatarWorkers)
+		local  = map[string]string{}
+		failed error
+	)
+	for _, link := range found {
+		wg.Add(1)
+		go func(link string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			saved, err := downloadImage(ctx, src, "avatars", link)
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, errAssetSkipped) {
+				warnf("", "%v", err)
+				return
+			}
+			if err != nil {
+				if failFast && failed == nil {
+					failed = err
+				} else if !failFast {
+					fail(err)
+				}
+				return
+			}
+			local[link] = saved
+		}(link)
+	}
+	wg.Wait()
+	if failed != nil {
+		return nil, failed
+	}
+
+	saved := []string{}
+	for _, link := range found {
+		if p, ok := local[link]; ok {
+			saved = append(saved, p)
+		}
+	}
+	if keepUpstream {
+		return saved, nil
+	}
+	rewritten := mapProse(string(content), func(line string) string {
+		return avatarRegex.ReplaceAllStringFunc(line, func(link string) string {
+			if p, ok := local[link]; ok {
+				return filepath.ToSlash(p)
+			}
+			return link
+		})
+	})
+	return saved, os.WriteFile(file, []byte(rewritten), 0644)
+}
+
+// downloadImage saves the image at link, as written in a document, under
+// the sub directory of the directory of src and returns its path relative
+// to it. Images count towards -max-repo-bytes and -max-dir-depth like the
+// assets of the repository, and with -respect-robots, images the robots.txt
+// of their host disallows are skipped.
+func downloadImage(ctx context.Context, src source, sub, link string) (string, error) {
+	target := html.UnescapeString(link)
+	dir := src.dir()
+	// the depth does not depend on the extension the response settles
+	if err := checkLimits(src, target, filepath.Join(sub, imageName(target, ""))); err != nil {
+		return "", err
+	}
+	if respectRobots {
+		allowed, err := robotsAllowed(ctx, target)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return "", fmt.Errorf("%w %s: its robots.txt disallows it", errAssetSkipped, target)
+		}
+	}
+	resp, err := get(ctx, target)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, target)
+	}
+
+	local := filepath.Join(sub, imageName(target, responseType(resp)))
+	err = os.MkdirAll(filepath.Join(dir, sub), 0755)
+	if err != nil {
+		return "", err
+	}
+	f, err := createPart(filepath.Join(dir, local))
+	if err != nil {
+		return "", err
+	}
+	defer discardPart(f)
+	written, err := io.Copy(f, resp.Body)
+	if src.downloaded != nil {
+		atomic.AddInt64(src.downloaded, written)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", local, err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return "", fmt.Errorf("got %d of the %d bytes of %s", written, resp.ContentLength, target)
+	}
+	return local, keepPart(f, filepath.Join(dir, local))
+}
+
+var imageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// imageName builds a file name for the image at link. Sizes and versions
+// in the query of avatars make different files of the same user, so a
+// short hash of the whole link keeps them apart.
+func imageName(link, contentType string) string {
+	base := "image"
+	if u, err := url.Parse(link); err == nil {
+		base = strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))
+	}
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		ext = ".png"
+	}
+	sum := sha1.Sum([]byte(link))
+	return fmt.Sprintf("%s-%x%s", base, sum[:4], ext)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadImageLimits(t *testing.T) {
+	serveFiles(t, map[string]string{"avatars.githubusercontent.com/u/1": "0123456789"})
+	inTempDir(t)
+	link := "https://avatars.githubusercontent.com/u/1"
+
+	maxRepoBytes = 10
+	t.Cleanup(func() { maxRepoBytes = 0 })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", downloaded: new(int64)}
+	local, err := downloadImage(context.Background(), src, "avatars", link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(src.dir(), local)); err != nil {
+		t.Fatal(err)
+	}
+	if *src.downloaded != 10 {
+		t.Errorf("counted %d bytes towards -max-repo-bytes, want 10", *src.downloaded)
+	}
+	if _, err := downloadImage(context.Background(), src, "avatars", link); !errors.Is(err, errAssetSkipped) {
+		t.Errorf("got %v once the repository reached -max-repo-bytes, want it skipped", err)
+	}
+
+	maxDirDepth = 1
+	t.Cleanup(func() { maxDirDepth = 16 })
+	src.downloaded = new(int64)
+	if _, err := downloadImage(context.Background(), src, "release-notes/nested", link); !errors.Is(err, errAssetSkipped) {
+		t.Errorf("got %v for an image over -max-dir-depth, want it skipped", err)
+	}
+}
+
+func TestFetchAvatars(t *testing.T) {
+	readme := "## Contributors\n\n<table><tr>\n" +
+		"<td><img src=\"https://avatars.githubusercontent.com/u/1?v=4\" width=\"100px;\"/><br/>one</td>\n" +
+		"<td><img src=\"https://avatars.githubusercontent.com/u/2?v=4\" width=\"100px;\"/><br/>two</td>\n" +
+		"<td><img src=\"https://github.com/three.png?size=100\"/><br/>three</td>\n" +
+		"<td><img src=\"https://avatars.githubusercontent.com/u/1?v=4\" width=\"100px;\"/><br/>one again</td>\n" +
+		"</tr></table>\n"
+	image := pngImage(t, 1, 1)
+	serveFiles(t, map[string]string{
+		"avatars.githubusercontent.com/u/1": image,
+		"avatars.githubusercontent.com/u/2": image,
+		"github.com/three.png":              image,
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := os.MkdirAll(src.dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src.dir(), "README.md"), []byte(readme), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := fetchAvatars(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 3 {
+		t.Fatalf("saved %v, want the 3 avatars", saved)
+	}
+	content, err := os.ReadFile(filepath.Join(src.dir(), "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, local := range saved {
+		if !exists(path.Join(src.dir(), filepath.ToSlash(local))) {
+			t.Errorf("%s was not saved", local)
+		}
+		if !strings.Contains(string(content), "src=\""+filepath.ToSlash(local)+"\"") {
+			t.Errorf("the README does not point at %s:\n%s", local, content)
+		}
+	}
+	if strings.Contains(string(content), "https://") {
+		t.Errorf("the README still links avatars upstream:\n%s", content)
+	}
+}
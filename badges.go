@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// badge is one status badge found in a README.
+type badge struct {
+	Kind    string `json:"kind,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Message string `json:"message,omitempty"`
+	Alt     string `json:"alt,omitempty"`
+	URL     string `json:"url"`
+}
+
+// badgeReport is what -extract-badges saves to badges.json: the status of
+// the first badge of every known kind, and all the badges found.
+type badgeReport struct {
+	CI       string  `json:"ci,omitempty"`
+	Coverage string  `json:"coverage,omitempty"`
+	Version  string  `json:"version,omitempty"`
+	License  string  `json:"license,omitempty"`
+	Badges   []badge `json:"badges"`
+}
+
+// badgeKinds maps words of badge labels and the metrics of shields.io paths,
+// like the v of /npm/v/..., to the kind of status they report.
+var badgeKinds = map[string]string{
+	"build":     "ci",
+	"ci":        "ci",
+	"tests":     "ci",
+	"actions":   "ci",
+	"workflow":  "ci",
+	"travis":    "ci",
+	"circleci":  "ci",
+	"coverage":  "coverage",
+	"codecov":   "coverage",
+	"coveralls": "coverage",
+	"version":   "version",
+	"release":   "version",
+	"v":         "version",
+	"license":   "license",
+	"l":         "license",
+}
+
+// writeBadges collects the status badges the saved README of dir shows
+// and summarizes them into badges.json.
+func writeBadges(dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return err
+	}
+	page := content
+	if !githubRendered {
+		rendered := new(bytes.Buffer)
+		err = mdRenderer.Convert(content, rendered)
+		if err != nil {
+			return err
+		}
+		page = rendered.Bytes()
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return err
+	}
+
+	report := badgeReport{Badges: []badge{}}
+	doc.Find("img").Each(func(_ int, s *goquery.Selection) {
+		src := s.AttrOr("data-canonical-src", s.AttrOr("src", ""))
+		b, ok := parseBadge(src, strings.TrimSpace(s.AttrOr("alt", "")))
+		if !ok {
+			return
+		}
+		report.Badges = append(report.Badges, b)
+
+		switch b.Kind {
+		case "ci":
+			report.CI = firstNonEmpty(report.CI, b.Message)
+		case "coverage":
+			report.Coverage = firstNonEmpty(report.Coverage, b.Message)
+		case "version":
+			report.Version = firstNonEmpty(report.Version, b.Message)
+		case "license":
+			report.License = firstNonEmpty(report.License, b.Message)
+		}
+	})
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "badges.json"), append(data, '\n'), 0644)
+}
+
+// parseBadge reads the badge at link. Static shields.io badges spell out
+// their label and message in the path, dynamic ones and the badges of CI
+// and coverage services only tell their kind. It reports false for images
+// that are not badges.
+func parseBadge(link, alt string) (badge, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return badge{}, false
+	}
+	b := badge{Alt: alt, URL: link}
+	segments := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+
+	switch host := strings.TrimPrefix(u.Host, "www."); {
+	case host == "img.shields.io" || host == "badgen.net":
+		if len(segments) >= 2 && segments[0] == "badge" {
+			b.Label, b.Message = staticBadge(strings.TrimSuffix(segments[1], ".svg"))
+			b.Kind = badgeKind(b.Label)
+			break
+		}
+		// the service and the metric lead dynamic badge paths, like
+		// /github/actions/workflow/status/... or /npm/v/...
+		if len(segments) > 3 {
+			segments = segments[:3]
+		}
+		b.Kind = badgeKind(strings.Join(segments, "/"))
+	case host == "github.com" && strings.HasSuffix(u.Path, "/badge.svg"):
+		b.Kind = "ci"
+	case strings.HasSuffix(host, "travis-ci.com") || strings.HasSuffix(host, "travis-ci.org") || strings.HasSuffix(host, "circleci.com"):
+		b.Kind = "ci"
+	case strings.HasSuffix(host, "codecov.io") || strings.HasSuffix(host, "coveralls.io"):
+		b.Kind = "coverage"
+	case host == "badge.fury.io":
+		b.Kind = "version"
+	default:
+		return badge{}, false
+	}
+	// alt texts like "coverage: 93%" carry what dynamic badges only render
+	if label, message, ok := strings.Cut(alt, ":"); ok && b.Message == "" {
+		b.Label, b.Message = strings.TrimSpace(label), strings.TrimSpace(message)
+	}
+	if b.Kind == "" {
+		b.Kind = badgeKind(firstNonEmpty(b.Label, alt))
+	}
+	return b, true
+}
+
+// staticBadge splits the label-message-color of a static shields.io badge.
+// Dashes and underscores are escaped by doubling them, and a single
+// underscore stands for a space.
+func staticBadge(spec string) (label, message string) {
+	spec = strings.NewReplacer("--", "\x00", "__", "\x01", "_", " ").Replace(spec)
+	parts := strings.Split(spec, "-")
+	unescape := func(part string) string {
+		part = strings.NewReplacer("\x00", "-", "\x01", "_").Replace(part)
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			return unescaped
+		}
+		return part
+	}
+	if len(parts) < 3 {
+		// message-color
+		return "", unescape(parts[0])
+	}
+	return unescape(parts[0]), unescape(parts[1])
+}
+
+// badgeKind tells the kind of status text reports from its words.
+func badgeKind(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if kind, ok := badgeKinds[word]; ok {
+			return kind
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBadges(t *testing.T) {
+	dir := t.TempDir()
+	readme := "# R\n\n" +
+		"[![CI](https://github.com/o/r/actions/workflows/ci.yml/badge.svg)](https://github.com/o/r/actions)\n" +
+		"![coverage: 93%](https://img.shields.io/codecov/c/github/o/r)\n" +
+		"![npm](https://img.shields.io/npm/v/r.svg)\n" +
+		"![license](https://img.shields.io/badge/license-MIT-blue.svg)\n" +
+		"![build](https://img.shields.io/badge/build-passing-green)\n" +
+		"![logo](docs/logo.png)\n"
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(readme), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBadges(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "badges.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report badgeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.CI != "passing" || report.Coverage != "93%" || report.License != "MIT" {
+		t.Errorf("got ci %q, coverage %q and license %q, want passing, 93%% and MIT", report.CI, report.Coverage, report.License)
+	}
+	kinds := []string{}
+	for _, b := range report.Badges {
+		kinds = append(kinds, b.Kind)
+	}
+	want := []string{"ci", "coverage", "version", "license", "ci"}
+	if len(kinds) != len(want) {
+		t.Fatalf("found badges of kinds %q, want %q", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("badge %d is of kind %q, want %q", i, kinds[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accountRepo is the part of a repository listing entry used for batches.
+type accountRepo struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+	Fork     bool   `json:"fork"`
+}
+
+// listAccountRepos pages through the repositories of an org or user. With
+// a token the listing includes the private repositories it can see: those
+// of an org, or those of the user the token belongs to, which only
+// /user/repos lists.
+func listAccountRepos(ctx context.Context, kind, name string) ([]accountRepo, error) {
+	path := fmt.Sprintf("/users/%s/repos?type=owner", url.PathEscape(name))
+	if kind == "org" {
+		path = fmt.Sprintf("/orgs/%s/repos?type=all", url.PathEscape(name))
+	} else if token != "" {
+		var user struct {
+			Login string `json:"login"`
+		}
+		err := apiGet(ctx, "/user", &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the user of the token: %v", err)
+		}
+		if strings.EqualFold(user.Login, name) {
+			path = "/user/repos?type=owner"
+		}
+	}
+
+	const perPage = 100
+	repos := []accountRepo{}
+	for page := 1; ; page++ {
+		batch := []accountRepo{}
+		err := apiGet(ctx, fmt.Sprintf("%s&per_page=%d&page=%d", path, perPage, page), &batch)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, batch...)
+		if limit > 0 && len(repos) >= limit {
+			return repos[:limit], nil
+		}
+		if len(batch) < perPage {
+			return repos, nil
+		}
+	}
+}
+
+// batchRepo is one repository of a batch, read at ref.
+type batchRepo struct {
+	name string
+	link string
+	ref  string
+}
+
+// runAccount fetches the README of every repository of an org or user,
+// each into its own directory.
+func runAccount(ctx context.Context, kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("missing %s name", kind)
+	}
+
+	repos, err := listAccountRepos(ctx, kind, name)
+	if err != nil {
+		return fmt.Errorf("failed to list repositories of %s: %v", name, err)
+	}
+
+	batch := make([]batchRepo, 0, len(repos))
+	for _, repo := range repos {
+		if skipForks && repo.Fork {
+			logf("skipping %s, it is a fork\n", repo.FullName)
+			continue
+		}
+		batch = append(batch, batchRepo{name: repo.FullName, link: repo.HTMLURL, ref: branchName})
+	}
+	return runBatch(ctx, batch)
+}
+
+// dropForks leaves out the repositories of a -from-file batch that the API
+// reports as forks.
+func dropForks(ctx context.Context, repos []batchRepo) ([]batchRepo, error) {
+	kept := []batchRepo{}
+	for _, repo := range repos {
+		u, err := url.Parse(repo.link)
+		if err != nil {
+			return nil, err
+		}
+		owner, name, err := repoSlug(u)
+		if err != nil {
+			return nil, err
+		}
+		var info struct {
+			Fork bool `json:"fork"`
+		}
+		err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s", owner, name), &info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check whether %s is a fork: %v", repo.name, err)
+		}
+		if info.Fork {
+			logf("skipping %s, it is a fork\n", repo.name)
+			continue
+		}
+		kept = append(kept, repo)
+	}
+	return kept, nil
+}
+
+// runBatch fetches the README of every repository of repos, each into its
+// own directory, -repo-concurrency at a time. Failed repositories are
+// reported and the batch goes on, unless -fail-fast is set; either way
+// the batch then returns an error.
+func runBatch(ctx context.Context, repos []batchRepo) error {
+	state, err := loadBatchState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, repoConcurrency)
+		// mu guards state, failed and stopErr, the error that ends the
+		// batch
+		mu      sync.Mutex
+		failed  int
+		stopErr error
+	)
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopErr == nil {
+			stopErr = err
+		}
+		cancel()
+	}
+
+	fetched := 0
+	for _, repo := range repos {
+		mu.Lock()
+		done := state.done(repo.name)
+		mu.Unlock()
+		if done {
+			logf("skipping %s, already completed\n", repo.name)
+			continue
+		}
+		// space consecutive fetches out by -repo-delay
+		if fetched > 0 && repoDelay > 0 {
+			select {
+			case <-batchCtx.Done():
+			case <-time.After(repoDelay):
+			}
+		}
+		slots <- struct{}{}
+		if batchCtx.Err() != nil {
+			<-slots
+			break
+		}
+		fetched++
+		wg.Add(1)
+		go func(repo batchRepo) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := fetchBatchRepo(batchCtx, repo)
+			if err == nil {
+				mu.Lock()
+				err = state.complete(stateFile, repo.name)
+				mu.Unlock()
+				if err != nil {
+					stop(fmt.Errorf("failed to update %s: %v", stateFile, err))
+				}
+				return
+			}
+			if failFast {
+				stop(fmt.Errorf("%s: %v", repo.name, err))
+				return
+			}
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			fail(fmt.Errorf("%s: %v", repo.name, err))
+		}(repo)
+	}
+	wg.Wait()
+
+	if stopErr != nil {
+		return stopErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repositories failed", failed, fetched)
+	}
+	return nil
+}
+
+// fetchBatchRepo fetches one repository of a batch. Repositories without a
+// README, unless -missing-readme fail says otherwise, and boilerplate ones
+// are reported and left out without failing.
+func fetchBatchRepo(ctx context.Context, repo batchRepo) error {
+	logf("fetching %s\n", repo.name)
+	u, err := parseRepoLink(repo.link)
+	var src source
+	if err == nil {
+		src = source{repo: u, ref: repo.ref, readme: "README.md"}
+		err = fetchRepo(ctx, src)
+	}
+	// a repository without a README only fails the batch if asked to
+	if errors.Is(err, errNotFound) && missingReadme != "fail" {
+		os.Remove(src.dir())
+		if missingReadme == "warn" {
+			logf("%s has no README\n", repo.name)
+		}
+		return nil
+	}
+	if errors.Is(err, errBoilerplate) {
+		os.Remove(src.dir())
+		logf("skipping %s: %v\n", repo.name, err)
+		return nil
+	}
+	return err
+}
+
+// readBatchFile reads the repositories of a -from-file batch, one per line
+// as owner/repo, a shorthand or a repository link, optionally followed by
+// a ref as "owner/repo@v1.2.0" or "owner/repo main". Blank lines and lines
+// starting with # are ignored. A file of "-" is read from stdin. Every
+// malformed line is reported with its line number.
+func readBatchFile(file string) ([]batchRepo, error) {
+	in := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	repos := []batchRepo{}
+	problems := []string{}
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repo, err := parseBatchLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", n, err))
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("malformed %s:\n%s", file, strings.Join(problems, "\n"))
+	}
+	return repos, nil
+}
+
+func parseBatchLine(line string) (batchRepo, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 2 {
+		return batchRepo{}, fmt.Errorf("expected a repository and an optional ref, got %q", line)
+	}
+	name, ref := fields[0], branchName
+	if len(fields) == 2 {
+		ref = fields[1]
+	} else if at := strings.LastIndex(name, "@"); at > 0 {
+		name, ref = name[:at], name[at+1:]
+	}
+
+	link := name
+	if !strings.Contains(link, "://") {
+		link = expandShorthand("gh:" + strings.TrimPrefix(strings.TrimPrefix(link, "gh:"), "@"))
+	}
+	u, err := parseRepoLink(link)
+	if err != nil {
+		return batchRepo{}, fmt.Errorf("%s is not a GitHub repository", name)
+	}
+	owner, repo, err := repoSlug(u)
+	if err != nil {
+		return batchRepo{}, err
+	}
+	err = checkRef(ref)
+	if err != nil {
+		return batchRepo{}, err
+	}
+
+	full := owner + "/" + repo
+	if ref != branchName {
+		full += "@" + ref
+	}
+	return batchRepo{name: full, link: u.String(), ref: ref}, nil
+}
+
+// batchState records which repositories of a batch are done, so an
+// interrupted run given the same -state-file picks up where it stopped.
+type batchState struct {
+	Completed []string `json:"completed"`
+}
+
+// loadBatchState reads the state file at path. A missing file, or no path
+// at all, is an empty state.
+func loadBatchState(path string) (*batchState, error) {
+	state := &batchState{Completed: []string{}}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return state, nil
+}
+
+func (s *batchState) done(repo string) bool {
+	for _, completed := range s.Completed {
+		if completed == repo {
+			return true
+		}
+	}
+	return false
+}
+
+// complete marks repo as done and saves the state to path, replacing the
+// previous file in one rename so an interruption never leaves it half
+// written.
+func (s *batchState) complete(path, repo string) error {
+	s.Completed = append(s.Completed, repo)
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
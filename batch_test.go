@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunAccount(t *testing.T) {
+	files := map[string]string{
+		"api.github.com/users/u/repos": `[
+			{"full_name": "u/a", "html_url": "https://github.com/u/a"},
+			{"full_name": "u/b", "html_url": "https://github.com/u/b"}
+		]`,
+		"api.github.com/user": `{"login": "U"}`,
+		"api.github.com/user/repos": `[
+			{"full_name": "u/a", "html_url": "https://github.com/u/a"},
+			{"full_name": "u/b", "html_url": "https://github.com/u/b"},
+			{"full_name": "u/private", "html_url": "https://github.com/u/private"}
+		]`,
+	}
+	for _, repo := range []string{"a", "b", "private"} {
+		files["github.com/u/"+repo+"/raw/HEAD/README.md"] = "# " + repo + "\n"
+	}
+	serveFiles(t, files)
+	branchName, repoConcurrency = "HEAD", 1
+	t.Cleanup(func() { token = "" })
+
+	for _, tc := range []struct {
+		token string
+		want  []string
+	}{
+		{"", []string{"a", "b"}},
+		// the token's own account lists its private repositories too
+		{"secret", []string{"a", "b", "private"}},
+	} {
+		inTempDir(t)
+		token = tc.token
+		if err := runAccount(context.Background(), "user", "u"); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(tc.want) {
+			t.Errorf("with token %q, fetched %d repositories, want %v", tc.token, len(entries), tc.want)
+		}
+		for _, repo := range tc.want {
+			if _, err := os.Stat(filepath.Join(repo, "README.md")); err != nil {
+				t.Errorf("with token %q, %s was not fetched: %v", tc.token, repo, err)
+			}
+		}
+	}
+}
+
+func TestResumeBatch(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = map[string]int{}
+		broken   = true
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+		// the run is cut short at b the first time
+		if r.URL.Path == "/o/b/raw/HEAD/README.md" && broken {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("# README\n"))
+	}))
+	dir := inTempDir(t)
+	stateFile, repoConcurrency, missingReadme = filepath.Join(dir, "state.json"), 1, "warn"
+	t.Cleanup(func() { stateFile, runFailed = "", false })
+	repos := []batchRepo{
+		{name: "o/a", link: "https://github.com/o/a", ref: "HEAD"},
+		{name: "o/b", link: "https://github.com/o/b", ref: "HEAD"},
+	}
+
+	if err := runBatch(context.Background(), repos); err == nil || err.Error() != "1 of 2 repositories failed" {
+		t.Fatalf("got %v, want the failure of b to fail the batch", err)
+	}
+	if exists("b/README.md") {
+		t.Fatal("b was fetched despite failing")
+	}
+	mu.Lock()
+	broken = false
+	requests = map[string]int{}
+	mu.Unlock()
+
+	if err := runBatch(context.Background(), repos); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests["/o/a/raw/HEAD/README.md"]; n != 0 {
+		t.Errorf("the completed repository a was fetched %d more times", n)
+	}
+	if !exists("b/README.md") {
+		t.Error("the resumed batch did not fetch b")
+	}
+}
+
+func TestRepoDelay(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		starts []time.Time
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		w.Write([]byte("# README\n"))
+	}))
+	inTempDir(t)
+	repoDelay, repoConcurrency = 100*time.Millisecond, 1
+	t.Cleanup(func() { repoDelay = 0 })
+
+	repos := []batchRepo{}
+	for _, name := range []string{"a", "b", "c"} {
+		repos = append(repos, batchRepo{name: "o/" + name, link: "https://github.com/o/" + name, ref: "HEAD"})
+	}
+	if err := runBatch(context.Background(), repos); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(starts) != 3 {
+		t.Fatalf("fetched %d READMEs, want 3", len(starts))
+	}
+	// the delay runs from when the fetch before was started, a moment
+	// before its request reaches the server
+	const jitter = 5 * time.Millisecond
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < repoDelay-jitter {
+			t.Errorf("fetch %d started %v after the one before, want at least %v", i+1, gap, repoDelay)
+		}
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	files := map[string]string{}
+	for repo, ref := range map[string]string{"a": "HEAD", "b": "v1.2.0", "c": "release-2", "d": "HEAD"} {
+		files["github.com/o/"+repo+"/raw/"+ref+"/README.md"] = ref
+	}
+	serveFiles(t, files)
+	dir := inTempDir(t)
+	branchName, repoConcurrency = "HEAD", 1
+	list := filepath.Join(dir, "repos.txt")
+	content := "# the docs to mirror\no/a\no/b@v1.2.0\n\nhttps://github.com/o/c release-2\n@o/d\n"
+	if err := os.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := readBatchFile(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runBatch(context.Background(), repos); err != nil {
+		t.Fatal(err)
+	}
+	for repo, ref := range map[string]string{"a": "HEAD", "b": "v1.2.0", "c": "release-2", "d": "HEAD"} {
+		content, err := os.ReadFile(filepath.Join(repo, "README.md"))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(content) != ref {
+			t.Errorf("fetched %s at %s, want %s", repo, content, ref)
+		}
+	}
+
+	if err := os.WriteFile(list, []byte("o/a\no/b main extra\nnot a repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readBatchFile(list)
+	if err == nil || !strings.Contains(err.Error(), "line 2: ") || !strings.Contains(err.Error(), "line 3: ") {
+		t.Errorf("got %v, want the malformed lines 2 and 3 reported", err)
+	}
+}
+
+func TestMissingReadme(t *testing.T) {
+	for _, tt := range []struct {
+		mode   string
+		err    string
+		logged bool
+	}{
+		{"warn", "", true},
+		{"fail", "1 of 1 repositories failed", false},
+		{"skip", "", false},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			serveFiles(t, nil)
+			inTempDir(t)
+			var out strings.Builder
+			missingReadme, logOut, repoConcurrency = tt.mode, &out, 1
+			t.Cleanup(func() { missingReadme, logOut, runFailed = "warn", os.Stderr, false })
+
+			err := runBatch(context.Background(), []batchRepo{{name: "o/r", link: "https://github.com/o/r", ref: "HEAD"}})
+			if got := fmt.Sprint(err); tt.err == "" && err != nil || tt.err != "" && got != tt.err {
+				t.Errorf("the batch returned %v, want %q", err, tt.err)
+			}
+			if runFailed != (tt.err != "") {
+				t.Errorf("the run failed %v, want %v", runFailed, tt.err != "")
+			}
+			if logged := strings.Contains(out.String(), "o/r has no README"); logged != tt.logged {
+				t.Errorf("logged %q, want the missing README reported %v", out.String(), tt.logged)
+			}
+			if tt.err == "" && exists("r") {
+				t.Error("left the directory of the repository without a README")
+			}
+		})
+	}
+}
+
+func TestSkipForks(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/users/u/repos": `[
+			{"full_name": "u/a", "html_url": "https://github.com/u/a"},
+			{"full_name": "u/f", "html_url": "https://github.com/u/f", "fork": true}
+		]`,
+		"api.github.com/repos/u/a":          `{"fork": false}`,
+		"api.github.com/repos/u/f":          `{"fork": true}`,
+		"github.com/u/a/raw/HEAD/README.md": "# a\n",
+		"github.com/u/f/raw/HEAD/README.md": "# f\n",
+	})
+	branchName, repoConcurrency = "HEAD", 1
+	t.Cleanup(func() { skipForks = false })
+
+	for _, skip := range []bool{false, true} {
+		inTempDir(t)
+		skipForks = skip
+		if err := runAccount(context.Background(), "user", "u"); err != nil {
+			t.Fatal(err)
+		}
+		if !exists("a/README.md") {
+			t.Errorf("with -skip-forks %v, u/a was not fetched", skip)
+		}
+		if exists("f/README.md") == skip {
+			t.Errorf("with -skip-forks %v, fetched the fork %v", skip, !skip)
+		}
+	}
+
+	repos := []batchRepo{{name: "u/a", link: "https://github.com/u/a"}, {name: "u/f", link: "https://github.com/u/f"}}
+	kept, err := dropForks(context.Background(), repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept[0].name != "u/a" {
+		t.Errorf("kept %v of a -from-file batch, want only u/a", kept)
+	}
+}
+
+func TestSkipBoilerplate(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/app/raw/HEAD/README.md":    "# Getting Started\n\nThis project was bootstrapped with [Create React App](https://github.com/facebook/create-react-app).\n",
+		"github.com/o/custom/raw/HEAD/README.md": "# TODO: describe the project\n",
+		"github.com/o/real/raw/HEAD/README.md":   "# Real\n\nA project with its own README.\n",
+	})
+	inTempDir(t)
+	var out strings.Builder
+	skipBoilerplate, logOut = true, &out
+	t.Cleanup(func() { skipBoilerplate, logOut = false, os.Stderr })
+
+	for _, name := range []string{"app", "real"} {
+		if err := fetchBatchRepo(context.Background(), batchRepo{name: "o/" + name, link: "https://github.com/o/" + name, ref: "HEAD"}); err != nil {
+			t.Fatalf("o/%s: %v", name, err)
+		}
+	}
+	if exists("app") || !strings.Contains(out.String(), "skipping o/app") {
+		t.Errorf("the Create React App README was not skipped, logged %q", out.String())
+	}
+	if !exists("real/README.md") {
+		t.Error("a README of its own was skipped")
+	}
+
+	if err := boilerplateSigs.Set("TODO: describe"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boilerplateSigs = listFlag{} })
+	if err := fetchBatchRepo(context.Background(), batchRepo{name: "o/custom", link: "https://github.com/o/custom", ref: "HEAD"}); err != nil {
+		t.Fatal(err)
+	}
+	if exists("custom") {
+		t.Error("a README with a -boilerplate-signature was not skipped")
+	}
+}
+
+func TestRepoConcurrencyInFlight(t *testing.T) {
+	var readme strings.Builder
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&readme, "![%d](img/%d.png)\n", i, i)
+	}
+	var (
+		mu           sync.Mutex
+		active, peak int
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".png") {
+			w.Write([]byte(readme.String()))
+			return
+		}
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		w.Write([]byte("png"))
+	}))
+	inTempDir(t)
+	repos := []batchRepo{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		repos = append(repos, batchRepo{name: "o/" + name, link: "https://github.com/o/" + name, ref: "HEAD"})
+	}
+	repoConcurrency, concurrency, missingReadme = 2, 4, "warn"
+	t.Cleanup(func() { repoConcurrency, concurrency, inFlight = 1, 1, nil })
+
+	for _, tt := range []struct {
+		maxInFlight, want int
+	}{
+		// the product of -repo-concurrency and -concurrency
+		{0, 8},
+		{6, 6},
+	} {
+		inFlight = nil
+		if tt.maxInFlight > 0 {
+			inFlight = make(chan struct{}, tt.maxInFlight)
+		}
+		mu.Lock()
+		peak = 0
+		mu.Unlock()
+		if err := runBatch(context.Background(), repos); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		if peak > tt.want {
+			t.Errorf("with -max-in-flight %d, %d downloads were in flight at once, want at most %d", tt.maxInFlight, peak, tt.want)
+		}
+		// more than one repository downloaded at a time
+		if peak <= 4 {
+			t.Errorf("with -max-in-flight %d, at most %d downloads were in flight at once", tt.maxInFlight, peak)
+		}
+		mu.Unlock()
+	}
+}
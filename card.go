@@ -0,0 +1,162 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The size of the -card image, the one social sites expect for previews.
+const (
+	cardWidth   = 1200
+	cardHeight  = 630
+	cardPadding = 64
+	cardLogo    = 240
+)
+
+var (
+	cardBackground = color.RGBA{0xf6, 0xf8, 0xfa, 0xff}
+	cardForeground = color.RGBA{0x1f, 0x23, 0x28, 0xff}
+	cardMuted      = color.RGBA{0x59, 0x63, 0x6e, 0xff}
+)
+
+// writeCard renders a social card for the README saved in dir to file:
+// the repository name, the first heading of the README and, on the right,
+// the first decodable image among the saved assets. Without an image the
+// text takes the whole width.
+func writeCard(file, dir, name string, saved []string) error {
+	card := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(card, card.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+
+	textWidth := cardWidth - 2*cardPadding
+	for _, local := range saved {
+		img, err := decodeImage(filepath.Join(dir, local))
+		if err != nil || img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+			continue
+		}
+		logo := fit(img, cardLogo)
+		at := image.Pt(cardWidth-cardPadding-logo.Bounds().Dx(), (cardHeight-logo.Bounds().Dy())/2)
+		draw.Draw(card, logo.Bounds().Sub(logo.Bounds().Min).Add(at), logo, logo.Bounds().Min, draw.Over)
+		textWidth -= cardLogo + cardPadding
+		break
+	}
+
+	title, err := cardFace(gobold.TTF, 64)
+	if err != nil {
+		return err
+	}
+	body, err := cardFace(goregular.TTF, 36)
+	if err != nil {
+		return err
+	}
+	y := cardPadding + 64
+	for _, line := range wrapText(title, name, textWidth, 2) {
+		drawText(card, title, cardForeground, cardPadding, y, line)
+		y += 80
+	}
+	y += 24
+	for _, line := range wrapText(body, firstHeading(dir), textWidth, 5) {
+		drawText(card, body, cardMuted, cardPadding, y, line)
+		y += 48
+	}
+
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return savePNG(file, card)
+}
+
+func cardFace(ttf []byte, size float64) (font.Face, error) {
+	parsed, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+func drawText(dst draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// wrapText breaks text into lines no wider than width, at most max of
+// them; the last line ends in an ellipsis when text does not fit.
+func wrapText(face font.Face, text string, width, max int) []string {
+	fits := func(s string) bool {
+		return font.MeasureString(face, s).Ceil() <= width
+	}
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if fits(candidate) || line == "" {
+			line = candidate
+			continue
+		}
+		lines = append(lines, line)
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) <= max {
+		return lines
+	}
+	lines = lines[:max]
+	last := []rune(lines[max-1])
+	for len(last) > 0 && !fits(string(last)+"…") {
+		last = last[:len(last)-1]
+	}
+	lines[max-1] = string(last) + "…"
+	return lines
+}
+
+// firstHeading returns the text of the first heading of the README saved
+// in dir, or "" when it has none.
+func firstHeading(dir string) string {
+	content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return ""
+	}
+	if githubRendered {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(content)))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(doc.Find("h1, h2, h3, h4, h5, h6").First().Text())
+	}
+	fences := fenceTracker{}
+	for _, line := range splitLines(string(content)) {
+		if fences.next(line) {
+			continue
+		}
+		if match := headingRegex.FindStringSubmatch(strings.TrimRight(line, "\r\n")); match != nil {
+			// badges and logos inline in headings are not text
+			return strings.TrimSpace(inlineTagRegex.ReplaceAllString(match[2], ""))
+		}
+	}
+	return ""
+}
+
+// fit scales img down so neither side exceeds size.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	width := size
+	if b.Dy() > b.Dx() {
+		width = b.Dx() * size / b.Dy()
+	}
+	if width < 1 {
+		width = 1
+	}
+	return resize(img, width)
+}
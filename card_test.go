@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteCard(t *testing.T) {
+	// card renders the card of a README, with a logo when withLogo is set
+	card := func(withLogo bool) image.Image {
+		dir := t.TempDir()
+		files := map[string]string{"README.md": "# A tool for reading READMEs\n"}
+		saved := []string{}
+		if withLogo {
+			files["logo.png"] = pngImage(t, 400, 400)
+			saved = append(saved, "logo.png")
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writeCard("card.png", dir, "o/r", saved); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(filepath.Join(dir, "card.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		img, err := png.Decode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size != image.Pt(cardWidth, cardHeight) {
+			t.Errorf("the card is %v, want %dx%d", size, cardWidth, cardHeight)
+		}
+		return img
+	}
+
+	with, without := card(true), card(false)
+	logo := image.Rect(cardWidth-cardPadding-cardLogo, (cardHeight-cardLogo)/2, cardWidth-cardPadding, (cardHeight+cardLogo)/2)
+	for y := logo.Min.Y; y < logo.Max.Y; y++ {
+		for x := logo.Min.X; x < logo.Max.X; x++ {
+			if with.At(x, y) != without.At(x, y) {
+				return
+			}
+		}
+	}
+	t.Error("the logo was not drawn on the card")
+}
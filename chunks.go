@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// minChunkedSize is the smallest asset -parallel-chunks splits; below it the
+// extra requests cost more than they save.
+const minChunkedSize = 8 << 20
+
+// errRangesIgnored reports a server that answered a range request with
+// something other than the requested range.
+var errRangesIgnored = errors.New("server ignored the range request")
+
+// errChangedMidway reports ranges that belong to another version of the file
+// than the first response described, as when it was replaced mid-download.
+var errChangedMidway = errors.New("file changed while downloading")
+
+// chunkable reports whether the asset behind resp is worth fetching with
+// -parallel-chunks byte-range requests.
+func chunkable(resp *http.Response) bool {
+	return parallelChunks > 1 &&
+		resp.Header.Get("Accept-Ranges") == "bytes" &&
+		resp.ContentLength >= minChunkedSize
+}
+
+// etagOf returns the strong ETag of resp, or "" when it has none. Weak
+// ETags do not promise identical bytes and are not used.
+func etagOf(resp *http.Response) string {
+	etag := resp.Header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+	return etag
+}
+
+// downloadChunks fetches the size bytes of rawURL into file as -parallel-chunks
+// concurrent range requests. Every chunk must come back with the requested
+// range of a file of size bytes with the same ETag as etag, so the pieces
+// are known to belong to one version of the file.
+func downloadChunks(ctx context.Context, rawURL string, file *os.File, size int64, etag string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunk := (size + int64(parallelChunks) - 1) / int64(parallelChunks)
+	errs := make(chan error, parallelChunks)
+	var wg sync.WaitGroup
+	for start := int64(0); start < size; start += chunk {
+		end := start + chunk - 1
+		if end >= size {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := downloadRange(ctx, rawURL, file, start, end, size, etag); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		return fmt.Errorf("reassembled %d bytes, expected %d", info.Size(), size)
+	}
+	return nil
+}
+
+func downloadRange(ctx context.Context, rawURL string, file *os.File, start, end, size int64, etag string) error {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, end)}}
+	if etag != "" {
+		// a server whose file no longer has etag sends all of it instead
+		header.Set("If-Range", etag)
+	}
+	resp, err := getWith(ctx, rawURL, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%w: status %d for bytes %d-%d", errRangesIgnored, resp.StatusCode, start, end)
+	}
+	var first, last, total int64
+	_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total)
+	if err != nil || first != start || last != end {
+		return fmt.Errorf("%w: got %q for bytes %d-%d", errRangesIgnored, resp.Header.Get("Content-Range"), start, end)
+	}
+	if total != size || etagOf(resp) != etag {
+		return fmt.Errorf("%w: bytes %d-%d are of %d bytes with ETag %s, not %d with %s", errChangedMidway, start, end, total, etagOf(resp), size, etag)
+	}
+
+	n, err := io.Copy(&offsetWriter{file, start}, io.LimitReader(resp.Body, end-start+1))
+	if err != nil {
+		return err
+	}
+	if n != end-start+1 {
+		return fmt.Errorf("short read for bytes %d-%d: %d bytes", start, end, n)
+	}
+	return nil
+}
+
+// offsetWriter writes to file from off on, so every range lands in its
+// place however the ranges interleave.
+type offsetWriter struct {
+	file *os.File
+	off  int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
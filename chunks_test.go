@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloadChunksChangedMidway(t *testing.T) {
+	before := bytes.Repeat([]byte("a"), minChunkedSize)
+	after := bytes.Repeat([]byte("b"), minChunkedSize)
+	var (
+		mu     sync.Mutex
+		ranges int
+		full   int
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Header.Get("Range") == "" {
+			full++
+			body, etag := after, `"after"`
+			if full == 1 {
+				body, etag = before, `"before"`
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+			return
+		}
+		// the file was replaced after the first response, and the server
+		// does not honor If-Range
+		ranges++
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("ETag", `"after"`)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(after)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(after[start : end+1])
+	}))
+	parallelChunks = 4
+	t.Cleanup(func() { parallelChunks = 1 })
+
+	dir := t.TempDir()
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD"}
+	local, err := downloadAsset(context.Background(), dir, src, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, after) {
+		t.Error("kept ranges of a file that changed instead of the whole new file")
+	}
+	if ranges == 0 || full != 2 {
+		t.Errorf("sent %d range and %d full requests, want ranges then a second full download", ranges, full)
+	}
+}
+
+func TestDownloadChunks(t *testing.T) {
+	content := make([]byte, minChunkedSize+123)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" {
+			mu.Lock()
+			ranges = append(ranges, rng)
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	parallelChunks = 3
+	t.Cleanup(func() { parallelChunks = 1 })
+
+	dir := t.TempDir()
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD"}
+	local, err := downloadAsset(context.Background(), dir, src, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("the chunks were not reassembled into the file")
+	}
+	sort.Strings(ranges)
+	want := []string{"bytes=0-2796243", "bytes=2796244-5592487", "bytes=5592488-8388730"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("requested the ranges %v, want %v", ranges, want)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// repoConfigFile is the file upstream projects commit to their repository
+// root to guide how their README is mirrored.
+const repoConfigFile = ".readtheirs.yaml"
+
+// repoConfig is a parsed .readtheirs.yaml:
+//
+//	include:         # only download assets matching these globs
+//	  - docs/img/*
+//	exclude:         # never download assets matching these globs
+//	  - "*.gif"
+//	follow:          # more documents to fetch along with the README
+//	  - docs/INSTALL.md
+//	link-mode: local
+//	transform: toc,emojify
+//
+// Settings given on the command line win over the file's.
+type repoConfig struct {
+	include   []string
+	exclude   []string
+	follow    []string
+	linkMode  string
+	transform []string
+}
+
+// fetchRepoConfig reads the .readtheirs.yaml of src at its ref. A
+// repository without one has an empty config.
+func fetchRepoConfig(ctx context.Context, src source) (repoConfig, error) {
+	file := src
+	file.readme = repoConfigFile
+	body, err := readDocument(ctx, file)
+	if errors.Is(err, errNotFound) {
+		return repoConfig{}, nil
+	}
+	if err != nil {
+		return repoConfig{}, err
+	}
+	config, err := parseRepoConfig(string(body))
+	if err != nil {
+		// a broken file upstream does not stop the mirror
+		logf("ignoring %v\n", err)
+		return repoConfig{}, nil
+	}
+	return config, nil
+}
+
+// parseRepoConfig reads the YAML subset .readtheirs.yaml is written in:
+// "key: value" lines and "key:" followed by "- item" lines. Settings the
+// command line already gave are dropped, and unknown keys are reported
+// and ignored.
+func parseRepoConfig(content string) (repoConfig, error) {
+	values := map[string][]string{}
+	order := []string{}
+	key := ""
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "- ") && key != "" {
+			values[key] = append(values[key], unquoteYAML(strings.TrimPrefix(trimmed, "- ")))
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || line != strings.TrimLeft(line, " \t") {
+			return repoConfig{}, fmt.Errorf("%s line %d: expected key: value", repoConfigFile, n+1)
+		}
+		key = strings.TrimSpace(name)
+		if _, seen := values[key]; !seen {
+			order = append(order, key)
+		}
+		values[key] = []string{}
+		if value = strings.TrimSpace(value); value != "" {
+			values[key] = append(values[key], unquoteYAML(value))
+		}
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	config := repoConfig{}
+	for _, key := range order {
+		list := values[key]
+		switch key {
+		case "include":
+			config.include = list
+		case "exclude":
+			config.exclude = list
+		case "follow":
+			config.follow = list
+		case "link-mode":
+			if set[key] || len(list) != 1 {
+				continue
+			}
+			if err := checkLinkMode(list[0]); err != nil {
+				logf("ignoring link-mode of %s: %v\n", repoConfigFile, err)
+				continue
+			}
+			config.linkMode = list[0]
+		case "transform":
+			if set[key] {
+				continue
+			}
+			transforms, err := parseTransforms(strings.Join(list, ","))
+			if err != nil {
+				logf("ignoring transform of %s: %v\n", repoConfigFile, err)
+				continue
+			}
+			config.transform = transforms
+		default:
+			logf("ignoring unknown key %q of %s\n", key, repoConfigFile)
+		}
+	}
+	return config, nil
+}
+
+// stripYAMLComment cuts a # comment off line, leaving # inside quotes.
+func stripYAMLComment(line string) string {
+	quote := rune(0)
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// wantsAsset reports whether the config lets the asset at p, a path
+// relative to the repository root, be downloaded.
+func (c repoConfig) wantsAsset(p string) bool {
+	for _, pattern := range c.exclude {
+		if ok, _ := path.Match(pattern, p); ok {
+			return false
+		}
+	}
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, pattern := range c.include {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// links is the link mode for src: -link-mode, unless its .readtheirs.yaml
+// sets one the command line did not.
+func (s source) links() string {
+	if s.config.linkMode != "" {
+		return s.config.linkMode
+	}
+	return linkMode
+}
+
+// transforms is the -transform pipeline for src, or the one of its
+// .readtheirs.yaml when the command line did not give one.
+func (s source) transforms() []string {
+	if s.config.transform == nil {
+		return pipeline
+	}
+	if normalizeDiff {
+		return append(s.config.transform, "normalize-for-diff")
+	}
+	return s.config.transform
+}
+
+// fetchFollowed saves the documents the config of src asks to follow where
+// they are relative to the README, along with their assets, returning the
+// local paths of the assets. Documents above the README's directory go
+// under rootDir, like assets do.
+func fetchFollowed(ctx context.Context, src source) ([]string, error) {
+	saved := []string{}
+	readmeDir := path.Dir(src.readme)
+	for _, p := range src.config.follow {
+		doc := src
+		doc.readme = path.Clean(strings.TrimPrefix(p, "/"))
+		if climbs(doc.readme) {
+			logf("not following %s, it points outside the repository\n", p)
+			continue
+		}
+		doc.followed = doc.readme
+		if readmeDir != "." {
+			doc.followed = strings.TrimPrefix(doc.readme, readmeDir+"/")
+			if doc.followed == doc.readme {
+				doc.followed = path.Join(rootDir, doc.readme)
+			}
+		}
+		if doc.followed == readmeName() {
+			logf("not following %s, it would overwrite the README\n", p)
+			continue
+		}
+		err := os.MkdirAll(doc.dir(), 0755)
+		if err != nil {
+			return saved, err
+		}
+		parsed, _, err := getDocument(ctx, &doc, doc.documentName())
+		if errors.Is(err, errNotFound) {
+			// only goes if it was left empty
+			os.Remove(doc.dir())
+			logf("not following %s, it does not exist\n", p)
+			continue
+		}
+		if err != nil {
+			return saved, err
+		}
+		logf("fetched %s\n", doc.readme)
+		assets, err := downloadAssets(ctx, parsed, doc, nil)
+		for _, asset := range assets {
+			saved = append(saved, path.Join(path.Dir(doc.followed), asset))
+		}
+		if err != nil {
+			return saved, err
+		}
+	}
+	return saved, nil
+}
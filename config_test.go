@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoConfigFollow(t *testing.T) {
+	src := serveRepo(t, "# R\n", map[string]string{
+		".readtheirs.yaml": "# guides the mirror\nfollow:\n  - docs/INSTALL.md\n  - guide/INSTALL.md\n  - docs/README.md\n  - ../../etc/passwd\nexclude:\n  - \"*.gif\"\n",
+		"docs/INSTALL.md":  "# Install\n\n![step](step.png)\n",
+		"docs/step.png":    "step",
+		"docs/README.md":   "# Docs\n",
+		"guide/INSTALL.md": "# Guide\n",
+	})
+	ignoreRepoConfig = false
+	t.Cleanup(func() { ignoreRepoConfig = true })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	// followed documents keep their place in the repository, and their
+	// assets their place next to them
+	for file, want := range map[string]string{
+		"README.md":        "# R\n",
+		"docs/INSTALL.md":  "# Install\n\n![step](step.png)\n",
+		"docs/step.png":    "step",
+		"docs/README.md":   "# Docs\n",
+		"guide/INSTALL.md": "# Guide\n",
+	} {
+		content, err := os.ReadFile(filepath.Join("r", file))
+		if err != nil {
+			t.Errorf("%s was not saved: %v", file, err)
+		} else if string(content) != want {
+			t.Errorf("saved %s as %q, want %q", file, content, want)
+		}
+	}
+	if exists("r/INSTALL.md") || exists("r/step.png") {
+		t.Error("saved a followed document or its asset next to the README")
+	}
+	if exists("etc") || exists("r/etc") {
+		t.Error("followed a path outside the repository")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// assetIndex remembers, for the whole run, where assets were saved, so
+// -dedupe-across-repos can reuse the file another repository of the batch
+// already saved. Before downloading, assets are found by their URL and by
+// their strong ETag and size; after, by the sha256 of their content.
+type assetIndex struct {
+	mu     sync.Mutex
+	byURL  map[string]savedAsset
+	byETag map[string]savedAsset
+	bySum  map[string]savedAsset
+}
+
+// savedAsset is a file of the index, with what its -sidecar records.
+type savedAsset struct {
+	file, contentType, etag string
+}
+
+var downloaded = newAssetIndex()
+
+func newAssetIndex() *assetIndex {
+	return &assetIndex{
+		byURL:  map[string]savedAsset{},
+		byETag: map[string]savedAsset{},
+		bySum:  map[string]savedAsset{},
+	}
+}
+
+// etagKey identifies content by its strong ETag and size, or is "" when
+// either is unknown.
+func etagKey(etag string, size int64) string {
+	if etag == "" || size < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %d", etag, size)
+}
+
+// findSaved looks for an asset another repository saved from rawURL without
+// downloading it: by the URL itself, then by where a HEAD request for it
+// leads and the ETag and size it answers with.
+func findSaved(ctx context.Context, rawURL string) (savedAsset, bool) {
+	if saved, ok := downloaded.lookup(downloaded.byURL, rawURL); ok {
+		return saved, true
+	}
+	resp, err := head(ctx, rawURL)
+	if err != nil {
+		return savedAsset{}, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return savedAsset{}, false
+	}
+	if saved, ok := downloaded.lookup(downloaded.byURL, resp.Request.URL.String()); ok {
+		return saved, true
+	}
+	return downloaded.lookup(downloaded.byETag, etagKey(etagOf(resp), resp.ContentLength))
+}
+
+// reuseSaved puts the file of saved, downloaded from another repository's
+// assetURL, at filePath as well.
+func reuseSaved(saved savedAsset, filePath, assetURL string) error {
+	err := linkOrCopy(saved.file, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to reuse %s for %s: %v", saved.file, filePath, err)
+	}
+	if withSidecar {
+		return writeSidecar(filePath, assetURL, saved.contentType, saved.etag)
+	}
+	return nil
+}
+
+// fileSum returns the hex sha256 of the content of file.
+func fileSum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookup returns the asset of index at key. Files that are gone since, as
+// when -compact removed them, are forgotten rather than found.
+func (a *assetIndex) lookup(index map[string]savedAsset, key string) (savedAsset, bool) {
+	if key == "" {
+		return savedAsset{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	saved, ok := index[key]
+	if ok {
+		if _, err := os.Stat(saved.file); err != nil {
+			delete(index, key)
+			return savedAsset{}, false
+		}
+	}
+	return saved, ok
+}
+
+// add records saved under its content sum, its ETag key and the URLs it was
+// downloaded from, skipping keys that are "" or already taken.
+func (a *assetIndex) add(saved savedAsset, sum, etag string, urls ...string) {
+	if abs, err := filepath.Abs(saved.file); err == nil {
+		saved.file = abs
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keep := func(index map[string]savedAsset, key string) {
+		if _, ok := index[key]; key != "" && !ok {
+			index[key] = saved
+		}
+	}
+	keep(a.bySum, sum)
+	keep(a.byETag, etag)
+	for _, u := range urls {
+		keep(a.byURL, u)
+	}
+}
+
+// linkOrCopy makes dst a hard link to src, copying the file instead when
+// the file system does not allow the link.
+func linkOrCopy(src, dst string) error {
+	os.Remove(dst)
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDedupeAcrossRepos(t *testing.T) {
+	files := map[string]string{
+		"github.com/a/r/raw/HEAD/logo.png":  "the same logo",
+		"github.com/b/r/raw/HEAD/logo.png":  "the same logo",
+		"github.com/b/r/raw/HEAD/other.png": "another logo",
+		"github.com/c/r/raw/HEAD/logo.png":  "the same logo",
+	}
+	var (
+		mu        sync.Mutex
+		downloads = map[string]int{}
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// identical content has the same ETag in every repository
+		w.Header().Set("ETag", strconv.Quote(fmt.Sprintf("%x", sha256.Sum256([]byte(body)))))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			downloads[r.Host+r.URL.Path]++
+			mu.Unlock()
+			w.Write([]byte(body))
+		}
+	}))
+	dedupeAcrossRepos = true
+	downloaded = newAssetIndex()
+	t.Cleanup(func() { dedupeAcrossRepos = false })
+
+	fetch := func(dir, repo, asset string) {
+		t.Helper()
+		src := source{repo: mustRepo(t, repo), ref: "HEAD"}
+		if _, err := downloadAsset(context.Background(), dir, src, asset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, second, third := t.TempDir(), t.TempDir(), t.TempDir()
+	fetch(first, "https://github.com/a/r", "logo.png")
+	fetch(second, "https://github.com/b/r", "logo.png")
+	fetch(second, "https://github.com/b/r", "other.png")
+
+	stat := func(file string) os.FileInfo {
+		t.Helper()
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	if !os.SameFile(stat(filepath.Join(first, "logo.png")), stat(filepath.Join(second, "logo.png"))) {
+		t.Error("the identical logos of both repositories are separate files")
+	}
+	if os.SameFile(stat(filepath.Join(second, "logo.png")), stat(filepath.Join(second, "other.png"))) {
+		t.Error("different assets share a file")
+	}
+	if entries, _ := os.ReadDir(second); len(entries) != 2 {
+		t.Errorf("left %d files in the second repository, want 2", len(entries))
+	}
+	mu.Lock()
+	if n := downloads["github.com/a/r/raw/HEAD/logo.png"] + downloads["github.com/b/r/raw/HEAD/logo.png"]; n != 1 {
+		t.Errorf("downloaded the shared logo %d times, want 1", n)
+	}
+	mu.Unlock()
+
+	// a file that is gone since, as after -compact, is downloaded again
+	if err := os.Remove(filepath.Join(first, "logo.png")); err != nil {
+		t.Fatal(err)
+	}
+	fetch(third, "https://github.com/c/r", "logo.png")
+	if data, err := os.ReadFile(filepath.Join(third, "logo.png")); err != nil || string(data) != "the same logo" {
+		t.Errorf("the logo of c was not saved after the first was removed: %q, %v", data, err)
+	}
+	mu.Lock()
+	if n := downloads["github.com/c/r/raw/HEAD/logo.png"]; n != 1 {
+		t.Errorf("downloaded the logo of c %d times after the first was removed, want 1", n)
+	}
+	mu.Unlock()
+}
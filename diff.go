@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each change in the
+// output of the diff subcommand.
+const diffContext = 3
+
+// runDiff fetches the READMEs of the repositories at linkA and linkB, with
+// the cleanups and transforms of the run applied, and writes a unified diff
+// of them to out. It reports whether they differ.
+func runDiff(ctx context.Context, out io.Writer, linkA, linkB string) (bool, error) {
+	if linkA == "" || linkB == "" {
+		return false, fmt.Errorf("diff needs two repositories")
+	}
+	contents := make([]string, 2)
+	names := make([]string, 2)
+	for i, link := range []string{linkA, linkB} {
+		src, err := parseSource(ctx, link)
+		if err != nil {
+			return false, err
+		}
+		_, content, err := getDocument(ctx, &src, "")
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch the README of %s: %v", link, err)
+		}
+		contents[i] = content
+		names[i] = strings.Trim(src.repo.Path, "/") + "/" + src.readme
+	}
+
+	ops := diffLines(splitLines(contents[0]), splitLines(contents[1]))
+	hunks := diffHunks(ops, diffContext)
+	if len(hunks) == 0 {
+		return false, nil
+	}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", names[0], names[1])
+	for _, h := range hunks {
+		h.write(out, ops)
+	}
+	return true, nil
+}
+
+// diffOp is one line of a diff: kept (' '), removed ('-') or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines computes the shortest edit script turning a into b with Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	most := n + m
+	offset := most + 1
+	v := make([]int, 2*most+3)
+	// trace[d] is the window [-d-1, d+1] of v before round d
+	trace := [][]int{}
+
+search:
+	for d := 0; d <= most; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// diffHunk is a run of ops, ops[start:end], shown under one @@ header.
+type diffHunk struct {
+	start, end int
+	// the 0-based lines of the old and new content the hunk starts at
+	lineA, lineB int
+}
+
+// diffHunks groups the changes of ops into hunks with up to around kept
+// lines around them, merging hunks whose context would overlap.
+func diffHunks(ops []diffOp, around int) []diffHunk {
+	hunks := []diffHunk{}
+	lineA, lineB := 0, 0
+	starts := make([][2]int, len(ops))
+	for i, op := range ops {
+		starts[i] = [2]int{lineA, lineB}
+		if op.kind != '+' {
+			lineA++
+		}
+		if op.kind != '-' {
+			lineB++
+		}
+	}
+
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		start := i - around
+		if start < 0 {
+			start = 0
+		}
+		end := i + 1 + around
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if last := len(hunks) - 1; last >= 0 && start <= hunks[last].end {
+			hunks[last].end = end
+			continue
+		}
+		hunks = append(hunks, diffHunk{start: start, end: end, lineA: starts[start][0], lineB: starts[start][1]})
+	}
+	return hunks
+}
+
+func (h diffHunk) write(out io.Writer, ops []diffOp) {
+	countA, countB := 0, 0
+	for _, op := range ops[h.start:h.end] {
+		if op.kind != '+' {
+			countA++
+		}
+		if op.kind != '-' {
+			countB++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(h.lineA, countA), hunkRange(h.lineB, countB))
+	for _, op := range ops[h.start:h.end] {
+		fmt.Fprintf(out, "%c%s", op.kind, op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			fmt.Fprint(out, "\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the line range of a hunk header the way diff -u does:
+// 1-based, with the count left out when it is 1, and an empty range given
+// as the line before it.
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line)
+	case 1:
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
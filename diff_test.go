@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunDiff(t *testing.T) {
+	upstream := "# R\n\nA tool.\n\n## Install\n\n    go install example.com/r@latest\n\n## Usage\n\nRun it.\n"
+	fork := strings.Replace(upstream, "go install example.com/r@latest", "go install example.com/fork/r@latest", 1)
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":    upstream,
+		"github.com/fork/r/raw/HEAD/README.md": fork,
+	})
+	branchName = "HEAD"
+
+	var out strings.Builder
+	differ, err := runDiff(context.Background(), &out, "https://github.com/o/r", "https://github.com/fork/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- o/r/README.md\n+++ fork/r/README.md\n" +
+		"@@ -4,7 +4,7 @@\n" +
+		" \n" +
+		" ## Install\n" +
+		" \n" +
+		"-    go install example.com/r@latest\n" +
+		"+    go install example.com/fork/r@latest\n" +
+		" \n" +
+		" ## Usage\n" +
+		" \n"
+	if !differ || out.String() != want {
+		t.Errorf("got the diff (differ %v)\n%s\nwant\n%s", differ, out.String(), want)
+	}
+
+	out.Reset()
+	differ, err = runDiff(context.Background(), &out, "https://github.com/o/r", "https://github.com/o/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ || out.Len() > 0 {
+		t.Errorf("the same README differs from itself:\n%s", out.String())
+	}
+}
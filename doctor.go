@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+// checklistItem is one check of a doctor, validate or archive checklist.
+// run returns nil when the check passes and errSkipped when it does not
+// apply.
+type checklistItem struct {
+	name string
+	run  func() error
+	// advisory checks warn instead of failing
+	advisory bool
+}
+
+// runChecklist runs checks in order and prints a pass/fail line for each.
+// It reports whether none of them failed.
+func runChecklist(checks []checklistItem) bool {
+	ok := true
+	for _, check := range checks {
+		err := check.run()
+		if err == errSkipped {
+			fmt.Printf("[skip] %s\n", check.name)
+			continue
+		}
+		if err != nil && check.advisory {
+			fmt.Printf("[warn] %s: %v\n", check.name, err)
+			continue
+		}
+		if err != nil {
+			ok = false
+			fmt.Printf("[fail] %s: %v\n", check.name, err)
+			continue
+		}
+		fmt.Printf("[ok]   %s\n", check.name)
+	}
+	return ok
+}
+
+// runDoctor checks that the environment can do a real fetch and prints a
+// pass/fail checklist. It reports whether every check passed.
+func runDoctor(ctx context.Context) bool {
+	withContext := func(run func(ctx context.Context) error) func() error {
+		return func() error { return run(ctx) }
+	}
+	return runChecklist([]checklistItem{
+		{"HTTPS reachability of github.com", withContext(checkReachable("https://github.com")), false},
+		{"HTTPS reachability of the GitHub API", withContext(checkReachable(apiURL)), false},
+		{"git is installed", withContext(checkGit), false},
+		{"token is accepted by the GitHub API", withContext(checkToken), false},
+		{"output directory is writable", withContext(checkWritable), false},
+	})
+}
+
+// errSkipped marks a check that does not apply to the current setup.
+var errSkipped = errors.New("skipped")
+
+func checkReachable(target string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		resp, err := get(ctx, target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+func checkGit(ctx context.Context) error {
+	_, err := exec.LookPath("git")
+	return err
+}
+
+func checkToken(ctx context.Context) error {
+	if token == "" {
+		return errSkipped
+	}
+	var user struct {
+		Login string `json:"login"`
+	}
+	return apiGet(ctx, "/user", &user)
+}
+
+func checkWritable(ctx context.Context) error {
+	f, err := os.CreateTemp(".", ".readtheirs-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
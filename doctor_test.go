@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host+r.URL.Path == "api.github.com/user" {
+			http.Error(w, "Bad credentials", http.StatusUnauthorized)
+		}
+	}))
+	inTempDir(t)
+	token = "revoked"
+	t.Cleanup(func() { token = "" })
+
+	var ok bool
+	out := captureStdout(t, func() { ok = runDoctor(context.Background()) })
+	if ok {
+		t.Error("doctor passed with a rejected token")
+	}
+	for _, want := range []string{
+		"[ok]   HTTPS reachability of github.com\n",
+		"[ok]   HTTPS reachability of the GitHub API\n",
+		"[fail] token is accepted by the GitHub API: GitHub API returned status code 401 for /user\n",
+		"[ok]   output directory is writable\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("doctor printed\n%s\nwithout %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// event is one line of the -events stream.
+type event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Repo  string    `json:"repo,omitempty"`
+	Asset string    `json:"asset,omitempty"`
+	Path  string    `json:"path,omitempty"`
+	Host  string    `json:"host,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+var (
+	// eventOut receives the -events stream, one JSON object per line.
+	eventOut io.Writer = os.Stdout
+	eventMu  sync.Mutex
+)
+
+// emit writes e to the -events stream, stamped with the current time.
+func emit(e event) {
+	if !events {
+		return
+	}
+	e.Time = time.Now().UTC()
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	json.NewEncoder(eventOut).Encode(e)
+}
+
+// errorText is err's message, or "" for nil.
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
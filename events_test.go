@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	src := serveRepo(t, "![logo](logo.png)\n![gone](gone.png)\n", map[string]string{"logo.png": "logo"})
+	var out strings.Builder
+	events, eventOut = true, &out
+	t.Cleanup(func() { events, eventOut, runFailed = false, os.Stdout, false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("%q is not a JSON event: %v", scanner.Text(), err)
+		}
+		if e.Time.IsZero() || e.Repo != "o/r" {
+			t.Errorf("event %q lacks its time or repository", scanner.Text())
+		}
+		got = append(got, e.Type+" "+e.Asset)
+	}
+	want := []string{"repo_started ", "asset_downloaded logo.png", "asset_failed gone.png", "repo_done "}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// gitAttribute is one line of a .gitattributes file: a path pattern and the
+// attributes it sets (true) or unsets (false).
+type gitAttribute struct {
+	pattern string
+	attrs   map[string]bool
+}
+
+// gitAttributes is a parsed .gitattributes file. Later lines win, as in git.
+type gitAttributes []gitAttribute
+
+// parseGitAttributes reads the lines of a .gitattributes file. Macro
+// definitions and negative patterns are not supported and are ignored.
+func parseGitAttributes(content string) gitAttributes {
+	attributes := gitAttributes{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		entry := gitAttribute{pattern: fields[0], attrs: map[string]bool{}}
+		for _, attr := range fields[1:] {
+			switch {
+			case strings.HasPrefix(attr, "-"):
+				entry.attrs[attr[1:]] = false
+			case attr == "binary":
+				// binary is a macro for -diff -merge -text
+				entry.attrs["binary"] = true
+				entry.attrs["text"] = false
+			default:
+				name, _, _ := strings.Cut(attr, "=")
+				entry.attrs[name] = true
+			}
+		}
+		attributes = append(attributes, entry)
+	}
+	return attributes
+}
+
+// lookup returns the state of attr for the file at p, a path relative to
+// the repository root, and whether any line mentions it.
+func (g gitAttributes) lookup(p, attr string) (bool, bool) {
+	set, found := false, false
+	for _, entry := range g {
+		value, ok := entry.attrs[attr]
+		if ok && matchAttributePattern(entry.pattern, p) {
+			set, found = value, true
+		}
+	}
+	return set, found
+}
+
+// exportIgnored reports whether p is marked export-ignore.
+func (g gitAttributes) exportIgnored(p string) bool {
+	set, _ := g.lookup(p, "export-ignore")
+	return set
+}
+
+// binary reports whether p is marked binary or -text.
+func (g gitAttributes) binary(p string) bool {
+	text, found := g.lookup(p, "text")
+	return found && !text
+}
+
+// matchAttributePattern matches p against a .gitattributes pattern. Patterns
+// without a slash match the file name at any depth, others match from the
+// repository root, and a trailing "/**" or "/" covers a whole directory.
+func matchAttributePattern(pattern, p string) bool {
+	if dir := strings.TrimSuffix(strings.TrimSuffix(pattern, "**"), "/"); dir != pattern {
+		dir = strings.TrimPrefix(dir, "/")
+		return strings.HasPrefix(p, dir+"/")
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(p))
+		return ok
+	}
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), p)
+	return ok
+}
+
+// fetchGitAttributes reads the .gitattributes file at the root of src. A
+// repository without one has no attributes.
+func fetchGitAttributes(ctx context.Context, src source) (gitAttributes, error) {
+	resp, err := get(ctx, src.rawURL(".gitattributes"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return gitAttributes{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve .gitattributes, status code: %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitAttributes(string(content)), nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExportIgnore(t *testing.T) {
+	src := serveRepo(t, "![logo](img/logo.png) ![draft](drafts/sketch.png)\n", map[string]string{
+		".gitattributes":    "# archives leave drafts out\ndrafts/** export-ignore\n*.png binary\n",
+		"img/logo.png":      "image",
+		"drafts/sketch.png": "image",
+	})
+	honorAttributes = true
+	t.Cleanup(func() { honorAttributes = false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/img/logo.png") {
+		t.Error("the asset without attributes was not downloaded")
+	}
+	if exists("r/drafts/sketch.png") {
+		t.Error("the export-ignore asset was downloaded")
+	}
+}
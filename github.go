@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// repoMetadata is the subset of the GitHub repository resource written to
+// metadata.json.
+type repoMetadata struct {
+	FullName    string   `json:"full_name"`
+	Description string   `json:"description"`
+	Topics      []string `json:"topics"`
+	Stars       int      `json:"stargazers_count"`
+	Language    string   `json:"language"`
+	// License is the SPDX identifier GitHub detected, null when it
+	// detected no license
+	License *string `json:"license_spdx_id"`
+}
+
+// repoSlug returns the owner and repository name of a GitHub repository link.
+func repoSlug(u *url.URL) (string, string, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("cannot find owner and repository in %s", u.String())
+	}
+	return parts[0], strings.TrimSuffix(parts[1], ".git"), nil
+}
+
+// apiGet requests path from the GitHub API and decodes the JSON response
+// into v.
+func apiGet(ctx context.Context, path string, v interface{}) error {
+	resp, err := get(ctx, strings.TrimSuffix(apiURL, "/")+path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: GitHub API returned status code %d for %s", errNotFound, resp.StatusCode, path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API returned status code %d for %s", resp.StatusCode, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// writeMetadata fetches the repository's description, topics, stars,
+// language and license and saves them as metadata.json in dir.
+func writeMetadata(ctx context.Context, u *url.URL, dir string) error {
+	owner, repo, err := repoSlug(u)
+	if err != nil {
+		return err
+	}
+
+	meta := repoMetadata{}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s", owner, repo), &meta)
+	if err != nil {
+		return fmt.Errorf("failed to fetch repository metadata: %v", err)
+	}
+	if meta.Topics == nil {
+		meta.Topics = []string{}
+	}
+
+	// the license endpoint answers 404 for repositories without one
+	var license struct {
+		License struct {
+			SPDXID string `json:"spdx_id"`
+		} `json:"license"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/license", owner, repo), &license)
+	switch {
+	case errors.Is(err, errNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to fetch the repository license: %v", err)
+	case license.License.SPDXID != "":
+		meta.License = &license.License.SPDXID
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "metadata.json"), append(data, '\n'), 0644)
+}
+
+// writeSocialPreview downloads the Open Graph image advertised by the
+// repository's GitHub page and saves it as social-preview.png in dir.
+func writeSocialPreview(ctx context.Context, u *url.URL, dir string) error {
+	resp, err := get(ctx, u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to retrieve %s, status code: %d", u.String(), resp.StatusCode)
+	}
+
+	page, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", u.String(), err)
+	}
+	image, exists := page.Find(`meta[property="og:image"]`).Attr("content")
+	if !exists || image == "" {
+		return fmt.Errorf("no og:image found on %s", u.String())
+	}
+	imageURL, err := u.Parse(image)
+	if err != nil {
+		return err
+	}
+
+	img, err := get(ctx, imageURL.String())
+	if err != nil {
+		return err
+	}
+	defer img.Body.Close()
+	if img.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d for %s", img.StatusCode, imageURL.String())
+	}
+
+	f, err := createPart(filepath.Join(dir, "social-preview.png"))
+	if err != nil {
+		return err
+	}
+	defer discardPart(f)
+	_, err = io.Copy(f, img.Body)
+	if err != nil {
+		return err
+	}
+	return keepPart(f, filepath.Join(dir, "social-preview.png"))
+}
+
+// pullRequestSource looks up the head of pull request number of owner/repo
+// and returns the README at that commit, which may live in a fork.
+func pullRequestSource(ctx context.Context, owner, repo, number string) (source, error) {
+	var pull struct {
+		Head struct {
+			Ref  string `json:"ref"`
+			SHA  string `json:"sha"`
+			Repo *struct {
+				HTMLURL string `json:"html_url"`
+			} `json:"repo"`
+		} `json:"head"`
+	}
+	err := apiGet(ctx, fmt.Sprintf("/repos/%s/%s/pulls/%s", owner, repo, url.PathEscape(number)), &pull)
+	if err != nil {
+		return source{}, fmt.Errorf("failed to look up pull request %s: %v", number, err)
+	}
+	if pull.Head.Repo == nil {
+		return source{}, fmt.Errorf("the head repository of pull request %s is gone", number)
+	}
+
+	u, err := url.Parse(pull.Head.Repo.HTMLURL)
+	if err != nil {
+		return source{}, err
+	}
+	logf("reading pull request %s at %s (%s)\n", number, pull.Head.Ref, pull.Head.SHA)
+	return source{repo: u, ref: pull.Head.SHA, readme: "README.md"}, nil
+}
+
+// changedSince asks the compare API which files differ between base and the
+// ref of src, returning their paths.
+func changedSince(ctx context.Context, src source, base string) (map[string]bool, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var comparison struct {
+		Files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		} `json:"files"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/compare/%s...%s", owner, repo, url.PathEscape(base), url.PathEscape(src.ref)), &comparison)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare %s with %s: %v", base, src.ref, err)
+	}
+
+	changed := map[string]bool{}
+	for _, file := range comparison.Files {
+		changed[file.Filename] = true
+		if file.PreviousFilename != "" {
+			changed[file.PreviousFilename] = true
+		}
+	}
+	return changed, nil
+}
+
+// commitAt returns the SHA of the last commit on the ref of src made at or
+// before the given time.
+func commitAt(ctx context.Context, src source, at time.Time) (string, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{"until": {at.UTC().Format(time.RFC3339)}, "per_page": {"1"}}
+	if src.ref != "HEAD" {
+		query.Set("sha", src.ref)
+	}
+	var commits []struct {
+		SHA string `json:"sha"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/commits?%s", owner, repo, query.Encode()), &commits)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the commit at %s: %v", at.Format(time.RFC3339), err)
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("no commit of %s/%s at or before %s", owner, repo, at.Format(time.RFC3339))
+	}
+	return commits[0].SHA, nil
+}
+
+// resolveCommit returns the SHA of the commit src.ref points at.
+func resolveCommit(ctx context.Context, src source) (string, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return "", err
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/commits/%s", owner, repo, escapePath(src.ref)), &commit)
+	if err != nil {
+		return "", err
+	}
+	return commit.SHA, nil
+}
+
+// latestRelease returns the tag of the newest published release of src's
+// repository.
+func latestRelease(ctx context.Context, src source) (string, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return "", err
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/releases/latest", owner, repo), &release)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the latest release of %s/%s: %v", owner, repo, err)
+	}
+	return release.TagName, nil
+}
+
+// matchingTag returns the highest version among the tags of src's
+// repository that match the glob pattern, as in "1.*" or "v2.3.*".
+func matchingTag(ctx context.Context, src source, pattern string) (string, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return "", err
+	}
+
+	const perPage = 100
+	best := ""
+	for page := 1; ; page++ {
+		var tags []struct {
+			Name string `json:"name"`
+		}
+		err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/tags?per_page=%d&page=%d", owner, repo, perPage, page), &tags)
+		if err != nil {
+			return "", fmt.Errorf("failed to list the tags of %s/%s: %v", owner, repo, err)
+		}
+		for _, tag := range tags {
+			if ok, _ := path.Match(pattern, strings.TrimPrefix(tag.Name, "v")); !ok {
+				if ok, _ = path.Match(pattern, tag.Name); !ok {
+					continue
+				}
+			}
+			if best == "" || compareVersions(tag.Name, best) > 0 {
+				best = tag.Name
+			}
+		}
+		if len(tags) < perPage {
+			break
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no tag of %s/%s matches %s", owner, repo, pattern)
+	}
+	return best, nil
+}
+
+// compareVersions orders tags like "v1.10.0" and "1.9.2" by their dotted
+// numeric parts, so 1.10 comes after 1.9. Parts that are not numbers are
+// compared as text, and a pre-release suffix sorts before the release.
+func compareVersions(a, b string) int {
+	splitVersion := func(v string) ([]string, string) {
+		v = strings.TrimPrefix(v, "v")
+		v, pre, _ := strings.Cut(v, "-")
+		return strings.Split(v, "."), pre
+	}
+	ap, apre := splitVersion(a)
+	bp, bpre := splitVersion(b)
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		x, y := "0", "0"
+		if i < len(ap) {
+			x = ap[i]
+		}
+		if i < len(bp) {
+			y = bp[i]
+		}
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xerr != nil || yerr != nil) && x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	}
+	return strings.Compare(apre, bpre)
+}
+
+// repoTree lists the files of src's repository at its ref, as expanding
+// the output directory would check them out.
+func repoTree(ctx context.Context, src source) (map[string]bool, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return nil, err
+	}
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+		} `json:"tree"`
+		Truncated bool `json:"truncated"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/git/trees/%s?recursive=1", owner, repo, escapePath(src.ref)), &tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files of %s/%s at %s: %v", owner, repo, src.ref, err)
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("%s/%s has too many files at %s to list", owner, repo, src.ref)
+	}
+	files := map[string]bool{}
+	for _, entry := range tree.Tree {
+		if entry.Type == "blob" {
+			files[entry.Path] = true
+		}
+	}
+	return files, nil
+}
+
+var (
+	scopesOnce sync.Once
+	// lacksRepoScope is set when the token is a classic one without the
+	// repo scope
+	lacksRepoScope bool
+)
+
+// checkTokenScopes warns when the repository of src looks private and the
+// token, a classic one that lists its scopes in X-OAuth-Scopes, lacks the
+// repo scope private repositories need. Without it the fetch only sees a
+// 404, as if the repository did not exist. Anonymous runs and fine-grained
+// tokens, which have no scopes header, are not checked. The check is only
+// advisory, so its own failures are just reported.
+func checkTokenScopes(ctx context.Context, src source) {
+	if token == "" {
+		return
+	}
+	// the scopes are the same for every repository of the run, and
+	// /rate_limit does not count against the limit
+	scopesOnce.Do(func() {
+		resp, err := get(ctx, strings.TrimSuffix(apiURL, "/")+"/rate_limit")
+		if err != nil {
+			logf("failed to check the scopes of the token: %v\n", err)
+			return
+		}
+		resp.Body.Close()
+		header, ok := resp.Header["X-Oauth-Scopes"]
+		if !ok {
+			return
+		}
+		lacksRepoScope = true
+		for _, scope := range strings.Split(strings.Join(header, ","), ",") {
+			if strings.TrimSpace(scope) == "repo" {
+				lacksRepoScope = false
+			}
+		}
+	})
+	if !lacksRepoScope {
+		return
+	}
+
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return
+	}
+	var info struct {
+		Private bool `json:"private"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s", owner, repo), &info)
+	if errors.Is(err, errNotFound) || err == nil && info.Private {
+		warnf("", "the token lacks the repo scope, so %s/%s cannot be read if it is private", owner, repo)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteMetadata(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r": `{"full_name": "o/r", "description": "A tool", "topics": ["cli", "go"],
+			"stargazers_count": 42, "language": "Go", "forks_count": 7}`,
+	})
+	dir := t.TempDir()
+	if err := writeMetadata(context.Background(), mustRepo(t, "https://github.com/o/r"), dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"full_name":        "o/r",
+		"description":      "A tool",
+		"topics":           []interface{}{"cli", "go"},
+		"stargazers_count": 42.0,
+		"language":         "Go",
+		"license_spdx_id":  nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+}
+
+func TestWriteMetadataLicense(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r":         `{"full_name": "o/r"}`,
+		"api.github.com/repos/o/r/license": `{"name": "LICENSE", "license": {"key": "mit", "spdx_id": "MIT"}}`,
+	})
+	dir := t.TempDir()
+	if err := writeMetadata(context.Background(), mustRepo(t, "https://github.com/o/r"), dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		License *string `json:"license_spdx_id"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.License == nil || *got.License != "MIT" {
+		t.Errorf("saved %s, want the license MIT", data)
+	}
+}
+
+func TestWriteSocialPreview(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r":                   `<html><head><meta property="og:image" content="https://opengraph.githubassets.com/1/o/r"></head></html>`,
+		"opengraph.githubassets.com/1/o/r": "preview",
+	})
+	dir := t.TempDir()
+	if err := writeSocialPreview(context.Background(), mustRepo(t, "https://github.com/o/r"), dir); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "social-preview.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "preview" {
+		t.Errorf("saved %q, not the og:image", content)
+	}
+}
+
+func TestFetchPullRequest(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r/pulls/7": `{"head": {"ref": "fix-docs", "sha": "abc123",
+			"repo": {"html_url": "https://github.com/contributor/r"}}}`,
+		"github.com/contributor/r/raw/abc123/README.md": "# Fixed docs\n",
+	})
+	inTempDir(t)
+	src, err := parseSource(context.Background(), "https://github.com/o/r/pull/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# Fixed docs\n" {
+		t.Errorf("saved %q, not the README at the head of the pull request", content)
+	}
+}
+
+func TestSinceCommit(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		requested []string
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host + r.URL.Path {
+		case "api.github.com/repos/o/r/compare/v1...HEAD":
+			w.Write([]byte(`{"files": [{"filename": "img/b.png"}, {"filename": "src/main.go"}]}`))
+		case "github.com/o/r/raw/HEAD/README.md":
+			w.Write([]byte("![a](img/a.png) ![b](img/b.png)\n"))
+		default:
+			mu.Lock()
+			requested = append(requested, r.URL.Path)
+			mu.Unlock()
+			w.Write([]byte("new"))
+		}
+	}))
+	inTempDir(t)
+	if err := os.MkdirAll(filepath.Join("r", "img"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "b.png"} {
+		if err := os.WriteFile(filepath.Join("r", "img", name), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sinceCommit = "v1"
+	t.Cleanup(func() { sinceCommit = "" })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(requested, []string{"/o/r/raw/HEAD/img/b.png"}) {
+		t.Errorf("downloaded %v, want only the changed img/b.png", requested)
+	}
+	for name, want := range map[string]string{"a.png": "old", "b.png": "new"} {
+		content, err := os.ReadFile(filepath.Join("r", "img", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("img/%s holds %q, want %q", name, content, want)
+		}
+	}
+}
+
+func TestMatchingTag(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r/tags": `[{"name": "1.0.0"}, {"name": "2.0.0"}, {"name": "1.3.2"}]`,
+	})
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD"}
+	tag, err := matchingTag(context.Background(), src, "1.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "1.3.2" {
+		t.Errorf("1.* picked %s, want 1.3.2", tag)
+	}
+	if _, err := matchingTag(context.Background(), src, "3.*"); err == nil {
+		t.Error("3.* matched a tag")
+	}
+}
+
+func TestListUnreferenced(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":    "![logo](img/logo.png)\n![old](img/old.png)\n",
+		"github.com/o/r/raw/HEAD/img/logo.png": "logo",
+		// served from a cache, but gone from the tree
+		"github.com/o/r/raw/HEAD/img/old.png": "old",
+		"api.github.com/repos/o/r/git/trees/HEAD": `{"tree": [
+			{"path": "README.md", "type": "blob"},
+			{"path": "img", "type": "tree"},
+			{"path": "img/logo.png", "type": "blob"}
+		]}`,
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	var out strings.Builder
+	listUnreferenced, logOut = true, &out
+	t.Cleanup(func() { listUnreferenced, logOut = false, os.Stderr })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "img/old.png is not in o/r at HEAD") {
+		t.Errorf("img/old.png was not listed, logged %q", out.String())
+	}
+	if strings.Contains(out.String(), "img/logo.png is not in") {
+		t.Errorf("img/logo.png was listed, logged %q", out.String())
+	}
+}
+
+func TestCheckTokenScopes(t *testing.T) {
+	tests := []struct {
+		scopes string
+		warned bool
+	}{
+		{"repo, gist", false},
+		{"public_repo, gist", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scopes, func(t *testing.T) {
+			serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/rate_limit":
+					w.Header().Set("X-OAuth-Scopes", tt.scopes)
+					w.Write([]byte("{}"))
+				default:
+					// private repositories are not found without the scope
+					http.NotFound(w, r)
+				}
+			}))
+			var out strings.Builder
+			token, logOut = "secret", &out
+			scopesOnce, lacksRepoScope = sync.Once{}, false
+			t.Cleanup(func() {
+				token, logOut = "", os.Stderr
+				scopesOnce, lacksRepoScope = sync.Once{}, false
+			})
+
+			checkTokenScopes(context.Background(), source{repo: mustRepo(t, "https://github.com/o/r")})
+			warned := strings.Contains(out.String(), "the token lacks the repo scope, so o/r cannot be read if it is private")
+			if warned != tt.warned {
+				t.Errorf("warned %t, want %t; logged %q", warned, tt.warned, out.String())
+			}
+		})
+	}
+}
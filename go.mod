@@ -4,6 +4,10 @@ go 1.20
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
-	github.com/andybalholm/cascadia v1.3.1 // indirect
-	golang.org/x/net v0.7.0 // indirect
+	github.com/andybalholm/cascadia v1.3.1
+	github.com/yuin/goldmark v1.5.6
+	golang.org/x/image v0.5.0
+	golang.org/x/net v0.7.0
 )
+
+require golang.org/x/text v0.7.0 // indirect
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// harRecorder keeps every request the run makes, and its response, for
+// -har. Bodies are only counted, not kept.
+type harRecorder struct {
+	mu      sync.Mutex
+	entries []*harEntry
+	next    http.RoundTripper
+}
+
+var recorder *harRecorder
+
+// The parts of the HTTP Archive 1.2 format -har fills in.
+type (
+	harEntry struct {
+		StartedDateTime time.Time   `json:"startedDateTime"`
+		Time            float64     `json:"time"`
+		Request         harRequest  `json:"request"`
+		Response        harResponse `json:"response"`
+		Cache           struct{}    `json:"cache"`
+		Timings         harTimings  `json:"timings"`
+	}
+	harRequest struct {
+		Method      string      `json:"method"`
+		URL         string      `json:"url"`
+		HTTPVersion string      `json:"httpVersion"`
+		Cookies     []struct{}  `json:"cookies"`
+		Headers     []harHeader `json:"headers"`
+		QueryString []harHeader `json:"queryString"`
+		HeadersSize int         `json:"headersSize"`
+		BodySize    int         `json:"bodySize"`
+	}
+	harResponse struct {
+		Status      int         `json:"status"`
+		StatusText  string      `json:"statusText"`
+		HTTPVersion string      `json:"httpVersion"`
+		Cookies     []struct{}  `json:"cookies"`
+		Headers     []harHeader `json:"headers"`
+		Content     harContent  `json:"content"`
+		RedirectURL string      `json:"redirectURL"`
+		HeadersSize int         `json:"headersSize"`
+		BodySize    int64       `json:"bodySize"`
+		Comment     string      `json:"comment,omitempty"`
+	}
+	harHeader struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	harContent struct {
+		Size     int64  `json:"size"`
+		MimeType string `json:"mimeType"`
+	}
+	harTimings struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+)
+
+// record makes client log its traffic to a new recorder.
+func record(client *http.Client) {
+	recorder = &harRecorder{entries: []*harEntry{}, next: client.Transport}
+	client.Transport = recorder
+}
+
+func (h *harRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := &harEntry{StartedDateTime: time.Now()}
+	entry.Request = harRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     []struct{}{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []harHeader{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+	query := req.URL.Query()
+	for _, param := range harHeaders(http.Header(query)) {
+		entry.Request.QueryString = append(entry.Request.QueryString, param)
+	}
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+
+	resp, err := h.next.RoundTrip(req)
+	wait := time.Since(entry.StartedDateTime)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry.Timings.Wait = milliseconds(wait)
+	entry.Time = entry.Timings.Wait
+	if err != nil {
+		entry.Response = harResponse{Cookies: []struct{}{}, Headers: []harHeader{}, HeadersSize: -1, BodySize: -1, Comment: err.Error()}
+		return nil, err
+	}
+
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     []struct{}{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{Size: -1, MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	resp.Body = &harBody{ReadCloser: resp.Body, recorder: h, entry: entry, started: time.Now()}
+	return resp, nil
+}
+
+// harBody counts the bytes read from a response body, and the time spent
+// reading them, into its entry once the body is done.
+type harBody struct {
+	io.ReadCloser
+	recorder *harRecorder
+	entry    *harEntry
+	started  time.Time
+	read     int64
+	done     bool
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *harBody) finish() {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.recorder.mu.Lock()
+	defer b.recorder.mu.Unlock()
+	b.entry.Response.BodySize = b.read
+	b.entry.Response.Content.Size = b.read
+	b.entry.Timings.Receive = milliseconds(time.Since(b.started))
+	b.entry.Time = b.entry.Timings.Wait + b.entry.Timings.Receive
+}
+
+// harRedacted are the headers whose values -har leaves out: credentials,
+// including the session token and signature headers of -output s3:// uploads.
+var harRedacted = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Amz-Security-Token": true,
+	"X-Amz-Date":           true,
+	"X-Amz-Content-Sha256": true,
+}
+
+func harHeaders(header http.Header) []harHeader {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []harHeader{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			// tokens and signatures are not for bug reports
+			if harRedacted[http.CanonicalHeaderKey(name)] {
+				value = "[redacted]"
+			}
+			headers = append(headers, harHeader{name, value})
+		}
+	}
+	return headers
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// saveHAR writes the traffic recorded so far to the -har file.
+func saveHAR() {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	archive := map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"creator": map[string]string{"name": "ReadTheirs", "version": "dev"},
+			"entries": recorder.entries,
+		},
+	}
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err == nil {
+		err = os.WriteFile(harFile, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save %s: %v\n", harFile, err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	src := serveRepo(t, "![logo](img/logo.png)\n", map[string]string{"img/logo.png": "logo"})
+	harFile = filepath.Join(t.TempDir(), "run.har")
+	record(client)
+	t.Cleanup(func() { harFile, recorder = "", nil })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	saveHAR()
+
+	data, err := os.ReadFile(harFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive struct {
+		Log struct {
+			Version string     `json:"version"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &archive); err != nil {
+		t.Fatalf("%s is not valid JSON: %v", harFile, err)
+	}
+	if archive.Log.Version != "1.2" {
+		t.Errorf("got HAR version %q, want 1.2", archive.Log.Version)
+	}
+	sizes := map[string]int64{}
+	for _, entry := range archive.Log.Entries {
+		if entry.Request.Method != "GET" || entry.Response.Status != 200 {
+			continue
+		}
+		sizes[entry.Request.URL] = entry.Response.Content.Size
+	}
+	for link, want := range map[string]int64{
+		"https://github.com/o/r/raw/HEAD/README.md":    int64(len("![logo](img/logo.png)\n")),
+		"https://github.com/o/r/raw/HEAD/img/logo.png": int64(len("logo")),
+	} {
+		size, ok := sizes[link]
+		if !ok {
+			t.Errorf("the HAR has no entry for %s", link)
+		} else if size != want {
+			t.Errorf("the HAR records %d bytes for %s, want %d", size, link, want)
+		}
+	}
+}
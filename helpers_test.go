@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rewriteTransport sends every request to target, keeping the host it was
+// meant for in the Host header, so handlers can tell github.com from
+// api.github.com.
+type rewriteTransport struct{ target *url.URL }
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Scheme = t.target.Scheme
+	r2.URL.Host = t.target.Host
+	r2.Host = r.URL.Host
+	return http.DefaultTransport.RoundTrip(r2)
+}
+
+// serve routes every request of the client to h for the rest of the test,
+// and sets the flags downloads depend on to their defaults.
+func serve(t *testing.T, h http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := client
+	client = newClient()
+	client.Transport = rewriteTransport{target}
+	t.Cleanup(func() { client = previous })
+
+	apiURL = "https://api.github.com"
+	assetSelector = selectorPresets["default"]
+	maxRedirects = 10
+	provider = "github"
+	linkMode = "keep"
+	ignoreRepoConfig = true
+}
+
+// serveFiles serves the bodies of files, keyed by host and path, and a 404
+// for anything else.
+func serveFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+// inTempDir runs the rest of the test in a fresh directory, which it
+// returns.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+// mustRepo parses the repository URL link.
+func mustRepo(t *testing.T, link string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// serveRepo serves the repository o/r, whose README is readme and whose
+// other files are files, keyed by their path in the repository, and runs the
+// rest of the test in a fresh directory. It returns the source to fetch,
+// which lands in r/.
+func serveRepo(t *testing.T, readme string, files map[string]string) source {
+	t.Helper()
+	served := map[string]string{"github.com/o/r/raw/HEAD/README.md": readme}
+	for name, body := range files {
+		served["github.com/o/r/raw/HEAD/"+name] = body
+	}
+	serveFiles(t, served)
+	inTempDir(t)
+	return source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+}
+
+// exists reports whether the file at the slash separated path name exists.
+func exists(name string) bool {
+	_, err := os.Stat(filepath.FromSlash(name))
+	return err == nil
+}
+
+// captureStdout returns what run writes to stdout.
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	previous := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = previous }()
+	run()
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// pngImage returns the bytes of a PNG of width by height pixels.
+func pngImage(t *testing.T, width, height int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
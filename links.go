@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdLinkRegex     = regexp.MustCompile(`(^|[^!])(\[[^\]]*\]\([ \t]*<?)([^)\s>]+)`)
+	anchorHrefRegex = regexp.MustCompile(`(<a\s[^>]*href=["'])([^"']+)`)
+)
+
+// checkLinkMode validates the value of -link-mode.
+func checkLinkMode(mode string) error {
+	switch mode {
+	case "keep", "github", "local":
+		return nil
+	}
+	return fmt.Errorf("unknown link mode %q, expected keep, github or local", mode)
+}
+
+// mapRepoLinks rewrites the targets of links (not images) to files of the
+// repository through fn, leaving code blocks alone.
+func mapRepoLinks(content string, fn func(ref string) string) string {
+	return mapProse(content, func(line string) string {
+		line = mdLinkRegex.ReplaceAllStringFunc(line, func(match string) string {
+			parts := mdLinkRegex.FindStringSubmatch(match)
+			if !isLocalRef(parts[3]) {
+				return match
+			}
+			return parts[1] + parts[2] + fn(parts[3])
+		})
+		return anchorHrefRegex.ReplaceAllStringFunc(line, func(match string) string {
+			parts := anchorHrefRegex.FindStringSubmatch(match)
+			if !isLocalRef(parts[2]) {
+				return match
+			}
+			return parts[1] + fn(parts[2])
+		})
+	})
+}
+
+// githubLinks points links to repository files at their pages on GitHub,
+// so they keep working without the rest of the repository.
+func githubLinks(content string, src source) string {
+	return mapRepoLinks(content, func(ref string) string {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		link := fmt.Sprintf("%s/blob/%s/%s", src.repo.String(), escapePath(src.ref), escapePath(src.repoPath(u.Path)))
+		if u.Fragment != "" {
+			link += "#" + u.Fragment
+		}
+		return link
+	})
+}
+
+// downloadLinkedFiles fetches the repository files the saved README links
+// to, so the links work in the local copy, and returns their local paths.
+// Links to directories are left as they are.
+func downloadLinkedFiles(ctx context.Context, src source, saved []string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(src.dir(), readmeName()))
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, local := range saved {
+		have[filepath.ToSlash(local)] = true
+	}
+
+	targets := []string{}
+	mapRepoLinks(string(content), func(ref string) string {
+		u, err := url.Parse(ref)
+		if err == nil && u.Path != "" && !strings.HasSuffix(u.Path, "/") && !have[u.Path] {
+			have[u.Path] = true
+			targets = append(targets, u.Path)
+		}
+		return ref
+	})
+
+	fetched := []string{}
+	lifted := map[string]string{}
+	for _, target := range targets {
+		if climbs(src.repoPath(target)) {
+			logf("skipped %s, it points outside the repository\n", target)
+			continue
+		}
+		local, err := downloadAsset(ctx, src.dir(), src, target)
+		if err == nil {
+			fetched = append(fetched, local)
+			if ref, moved := movedRef(target); moved {
+				lifted[ref] = filepath.ToSlash(local)
+			}
+			continue
+		}
+		if failFast {
+			return fetched, fmt.Errorf("stopping at first failure: %v", err)
+		}
+		fail(err)
+	}
+	return fetched, liftRefs(filepath.Join(src.dir(), src.documentName()), lifted)
+}
+
+// sitemapEntry describes one fetched document in sitemap.json.
+type sitemapEntry struct {
+	Path   string   `json:"path"`
+	Source string   `json:"source"`
+	Links  []string `json:"links"`
+}
+
+// writeSitemap saves sitemap.json, listing the README and the markdown
+// documents fetched next to it, each with the other fetched documents it
+// links to.
+func writeSitemap(src source, fetched []string) error {
+	docs := []string{readmeName()}
+	for _, local := range fetched {
+		if ext := strings.ToLower(path.Ext(local)); ext == ".md" || ext == ".markdown" {
+			docs = append(docs, filepath.ToSlash(local))
+		}
+	}
+	isDoc := map[string]bool{}
+	for _, doc := range docs {
+		isDoc[doc] = true
+	}
+
+	entries := []sitemapEntry{}
+	for _, doc := range docs {
+		content, err := os.ReadFile(filepath.Join(src.dir(), filepath.FromSlash(doc)))
+		if err != nil {
+			return err
+		}
+		entry := sitemapEntry{Path: doc, Source: src.rawURL(src.repoPath(doc)), Links: []string{}}
+		if doc == readmeName() {
+			entry.Source = src.rawURL(src.readme)
+		}
+		mapRepoLinks(string(content), func(ref string) string {
+			target := path.Join(path.Dir(doc), assetFile(ref))
+			if isDoc[target] && target != doc {
+				entry.Links = append(entry.Links, target)
+			}
+			return ref
+		})
+		entries = append(entries, entry)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(src.dir(), "sitemap.json"), append(data, '\n'), 0644)
+}
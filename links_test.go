@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLinkMode(t *testing.T) {
+	t.Cleanup(func() { linkMode = "keep" })
+	for _, tc := range []struct {
+		mode, want string
+		fetched    bool
+	}{
+		{"keep", "See [the entry point](src/main.go).\n", false},
+		{"github", "See [the entry point](https://github.com/o/r/blob/HEAD/src/main.go).\n", false},
+		{"local", "See [the entry point](src/main.go).\n", true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			src := serveRepo(t, "See [the entry point](src/main.go).\n", map[string]string{"src/main.go": "package main\n"})
+			linkMode = tc.mode
+			if err := fetchRepo(context.Background(), src); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join("r", "README.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.want {
+				t.Errorf("saved %q, want %q", content, tc.want)
+			}
+			if exists("r/src/main.go") != tc.fetched {
+				t.Errorf("downloaded the linked file: %v, want %v", !tc.fetched, tc.fetched)
+			}
+		})
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	src := serveRepo(t, "Read [the guide](docs/guide.md).\n", map[string]string{
+		"docs/guide.md": "Back to [the README](../README.md).\n",
+	})
+	linkMode, sitemap = "local", true
+	t.Cleanup(func() { linkMode, sitemap = "keep", false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("r", "sitemap.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []sitemapEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	want := []sitemapEntry{
+		{Path: "README.md", Source: "https://github.com/o/r/raw/HEAD/README.md", Links: []string{"docs/guide.md"}},
+		{Path: "docs/guide.md", Source: "https://github.com/o/r/raw/HEAD/docs/guide.md", Links: []string{"README.md"}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("sitemap.json holds %+v, want %+v", entries, want)
+	}
+}
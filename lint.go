@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// codeLanguages are the fence languages -lint-code-langs knows: the common
+// names and aliases GitHub highlights, in lower case.
+var codeLanguages = map[string]bool{}
+
+func init() {
+	for _, lang := range strings.Fields(`
+		text txt plaintext plain none console terminal output diff patch
+		sh bash shell zsh fish ksh shellsession powershell ps1 pwsh bat batch cmd
+		c h cpp c++ cc hpp cxx objc objective-c objectivec csharp cs c# fsharp fs f#
+		go golang rust rs java kotlin kt scala groovy gradle clojure clj
+		swift dart zig nim crystal d ada fortran pascal delphi cobol
+		python py python3 py3 ipython pycon pytb ruby rb erb perl pl raku lua php
+		javascript js jsx mjs cjs node typescript ts tsx coffeescript coffee
+		elm purescript reason rescript ocaml ml haskell hs elixir ex exs erlang erl
+		r julia jl matlab octave sas stata mathematica wolfram
+		html xhtml htm xml svg css scss sass less stylus styl
+		vue svelte astro handlebars hbs mustache jinja jinja2 liquid twig
+		django ejs pug jade haml slim
+		json jsonc json5 jsonl ndjson yaml yml toml ini cfg conf properties env
+		dotenv editorconfig csv tsv
+		sql mysql postgresql postgres psql plsql tsql sqlite graphql gql
+		proto protobuf thrift avro capnp
+		markdown md mdx rst asciidoc adoc tex latex bibtex org
+		dockerfile docker makefile make cmake meson ninja bazel starlark bzl
+		nix hcl terraform tf nginx apache http caddyfile
+		vim viml lisp elisp emacs-lisp scheme racket commonlisp
+		asm nasm gas armasm wasm wat llvm
+		solidity sol vyper move cairo
+		glsl hlsl wgsl cuda opencl verilog vhdl systemverilog
+		mermaid plantuml dot graphviz regex abnf ebnf
+		gitignore gitattributes git-commit gitconfig
+		applescript autohotkey ahk awk sed tcl vb vbnet vba prolog
+		apex gdscript hack haxe idris agda lean coq
+	`) {
+		codeLanguages[lang] = true
+	}
+}
+
+// lintWarning is a problem lintCodeLangs found at a line of the README.
+type lintWarning struct {
+	line    int
+	message string
+}
+
+// lintCodeLangs returns a warning for every fenced code block of content
+// whose language is not in codeLanguages, usually a typo that leaves the
+// block without highlighting. Blocks without a language are fine.
+func lintCodeLangs(content string) []lintWarning {
+	source := []byte(content)
+	doc := mdRenderer.Parser().Parse(text.NewReader(source))
+	warnings := []lintWarning{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		fence, ok := n.(*ast.FencedCodeBlock)
+		if !entering || !ok || fence.Info == nil {
+			return ast.WalkContinue, nil
+		}
+		lang := strings.ToLower(string(fence.Language(source)))
+		if lang == "" || codeLanguages[lang] {
+			return ast.WalkContinue, nil
+		}
+		line := 1 + bytes.Count(source[:fence.Info.Segment.Start], []byte("\n"))
+		warning := lintWarning{line, fmt.Sprintf("unknown code block language %q", lang)}
+		if guess := closestLanguage(lang); guess != "" {
+			warning.message += fmt.Sprintf(", did you mean %q?", guess)
+		}
+		warnings = append(warnings, warning)
+		return ast.WalkContinue, nil
+	})
+	return warnings
+}
+
+// closestLanguage suggests the known language lang is most likely a typo
+// of, or "" when none is within two edits of it.
+func closestLanguage(lang string) string {
+	best, bestDistance := "", 3
+	for known := range codeLanguages {
+		d := editDistance(lang, known)
+		if d < bestDistance || d == bestDistance && best != "" && known < best {
+			best, bestDistance = known, d
+		}
+	}
+	if best == "" || bestDistance >= len(lang) {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// lintReadme reports the unknown code block languages of the README saved
+// in dir. The check is informational and never fails the fetch.
+func lintReadme(dir string, report *fetchReport) error {
+	content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return err
+	}
+	for _, warning := range lintCodeLangs(string(content)) {
+		warnAt(filepath.Join(dir, readmeName()), warning.line, "%s line %d: %s", readmeName(), warning.line, warning.message)
+		report.warn("%s line %d: %s", readmeName(), warning.line, warning.message)
+	}
+	return nil
+}
+
+// imageRefMismatches returns a warning for every markdown image of the
+// README saved in dir whose local file is not an image, like an HTML page
+// or a PDF, which usually means the reference is broken. Links and HTML
+// <img> tags are left alone, as are files that were not downloaded.
+func imageRefMismatches(dir string) ([]string, error) {
+	source, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return nil, err
+	}
+	doc := mdRenderer.Parser().Parse(text.NewReader(source))
+	warnings := []string{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		image, ok := n.(*ast.Image)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		ref := string(image.Destination)
+		if u, err := url.Parse(ref); err != nil || u.IsAbs() || strings.HasPrefix(ref, "/") {
+			return ast.WalkContinue, nil
+		}
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(assetFile(ref))))
+		if err != nil {
+			return ast.WalkContinue, nil
+		}
+		head := make([]byte, 512)
+		size, _ := io.ReadFull(f, head)
+		f.Close()
+		head = head[:size]
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+		// SVG is text to the sniffer
+		if strings.HasPrefix(contentType, "image/") || bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return ast.WalkContinue, nil
+		}
+		warnings = append(warnings, fmt.Sprintf("image %s is not an image but %s", ref, contentType))
+		return ast.WalkContinue, nil
+	})
+	return warnings, nil
+}
+
+// insecureAssets returns a warning for every asset of the README saved in
+// dir that is fetched over plain http://, which browsers block or flag as
+// mixed content when the README is viewed over https. Markdown images and
+// the tags of -asset-selector count; links to other pages do not.
+func insecureAssets(dir string) ([]string, error) {
+	source, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	warnings := []string{}
+	check := func(ref string) {
+		if !strings.HasPrefix(strings.ToLower(ref), "http://") || seen[ref] {
+			return
+		}
+		seen[ref] = true
+		warnings = append(warnings, fmt.Sprintf("asset %s is not served over https", ref))
+	}
+
+	doc := mdRenderer.Parser().Parse(text.NewReader(source))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); entering && ok {
+			check(string(image.Destination))
+		}
+		return ast.WalkContinue, nil
+	})
+	scanDocument(maskCode(string(source))).Find(assetSelector).Each(func(_ int, s *goquery.Selection) {
+		for _, attr := range []string{"src", "href"} {
+			if value, ok := s.Attr(attr); ok {
+				check(strings.TrimSpace(value))
+			}
+		}
+	})
+	return warnings, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLintCodeLangs(t *testing.T) {
+	content := "# R\n\n```go\nfmt.Println()\n```\n\n```javascrpt\nconsole.log(1)\n```\n\n```\nplain\n```\n"
+	warnings := lintCodeLangs(content)
+	if len(warnings) != 1 {
+		t.Fatalf("got %v, want one warning", warnings)
+	}
+	want := lintWarning{7, `unknown code block language "javascrpt", did you mean "javascript"?`}
+	if warnings[0] != want {
+		t.Errorf("got %+v, want %+v", warnings[0], want)
+	}
+}
+
+func TestImageRefMismatches(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"README.md": "# R\n\n![logo](logo.png)\n![shot](shot.png)\n![icon](icon.svg)\n[page](page.html)\n![gone](gone.png)\n",
+		"logo.png":  pngImage(t, 2, 2),
+		"shot.png":  "<!DOCTYPE html><html><body>Sign in</body></html>",
+		"icon.svg":  `<svg xmlns="http://www.w3.org/2000/svg"></svg>`,
+		"page.html": "<html></html>",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	warnings, err := imageRefMismatches(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"image shot.png is not an image but text/html"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("got %q, want %q", warnings, want)
+	}
+}
+
+func TestInsecureAssets(t *testing.T) {
+	dir := t.TempDir()
+	content := "# R\n\n![badge](http://example.com/badge.svg)\n![safe](https://example.com/safe.svg)\n" +
+		"<img src=\"HTTP://example.com/logo.png\">\n[site](http://example.com/)\n![again](http://example.com/badge.svg)\n"
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := assetSelector
+	assetSelector = selectorPresets["default"]
+	t.Cleanup(func() { assetSelector = previous })
+
+	warnings, err := insecureAssets(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"asset http://example.com/badge.svg is not served over https",
+		"asset HTTP://example.com/logo.png is not served over https",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("got %q, want %q", warnings, want)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// logOut receives everything the tool reports while it runs, on
+	// stderr so stdout stays free for -print-readme. With -quiet-on-success
+	// it points at logBuf until the outcome is known.
+	logOut    io.Writer = os.Stderr
+	logBuf    bytes.Buffer
+	runFailed bool
+
+	// logMu keeps the reports of concurrent downloads whole
+	logMu sync.Mutex
+)
+
+// logf reports progress of the run.
+func logf(format string, args ...interface{}) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	fmt.Fprintf(logOut, format, args...)
+}
+
+// fail reports err and marks the run as failed without stopping it.
+func fail(err error) {
+	logMu.Lock()
+	runFailed = true
+	logMu.Unlock()
+	if githubActions {
+		logf("::error::%s\n", annotationData(err.Error()))
+		return
+	}
+	logf("%v\n", err)
+}
+
+// warnf reports a problem with file, the path of a saved file or "" for
+// none, that does not fail the run. With -github-actions it becomes a
+// warning annotation on file.
+func warnf(file, format string, args ...interface{}) {
+	warnAt(file, 0, format, args...)
+}
+
+// warnAt is warnf for a problem at line of file, or at no line in
+// particular when it is 0.
+func warnAt(file string, line int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if !githubActions {
+		logf("%s\n", message)
+		return
+	}
+	location := ""
+	if file = workspacePath(file); file != "" {
+		location = " file=" + annotationProperty(file)
+		if line > 0 {
+			location += fmt.Sprintf(",line=%d", line)
+		}
+	}
+	logf("::warning%s::%s\n", location, annotationData(message))
+}
+
+// workDir is the directory the run started in, before -output or
+// -mirror-into-git changed into another one.
+var workDir, _ = os.Getwd()
+
+// workspacePath turns file, relative to the current directory, into the
+// path GitHub Actions annotates: relative to $GITHUB_WORKSPACE, or to the
+// directory the run started in. Files outside of it, like those of the
+// staging directory of -output, give "".
+func workspacePath(file string) string {
+	if file == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	root := os.Getenv("GITHUB_WORKSPACE")
+	if root == "" {
+		root = workDir
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || climbs(filepath.ToSlash(rel)) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// annotationData escapes the message of a GitHub Actions workflow command,
+// which ends at the first line break.
+func annotationData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// annotationProperty escapes a property value of a workflow command, where
+// commas and colons also have a meaning.
+func annotationProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// flushLog writes out the buffered output of a failed run. Output of a run
+// that fully succeeded is dropped.
+func flushLog() {
+	if logOut == &logBuf && runFailed {
+		os.Stderr.Write(logBuf.Bytes())
+	}
+	logBuf.Reset()
+}
+
+// fatal reports err and ends the run.
+func fatal(err error) {
+	fail(err)
+	saveHAR()
+	reportTraces()
+	flushLog()
+	os.Exit(1)
+}
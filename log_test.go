@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarnAnnotations(t *testing.T) {
+	dir := inTempDir(t)
+	if err := os.Mkdir("r", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	previous := workDir
+	logOut, workDir, githubActions = &out, dir, true
+	t.Cleanup(func() { logOut, workDir, githubActions = os.Stderr, previous, false })
+	t.Setenv("GITHUB_WORKSPACE", "")
+
+	warn := func(file string, line int) string {
+		out.Reset()
+		warnAt(file, line, "problem: %d%%", 100)
+		return out.String()
+	}
+	for _, tc := range []struct {
+		file string
+		line int
+		want string
+	}{
+		{"", 0, "::warning::problem: 100%25\n"},
+		{filepath.Join("r", "README.md"), 0, "::warning file=r/README.md::problem: 100%25\n"},
+		{filepath.Join("r", "README.md"), 3, "::warning file=r/README.md,line=3::problem: 100%25\n"},
+		// outside the workspace, as the staging directory of -output is
+		{filepath.Join(os.TempDir(), "elsewhere.md"), 3, "::warning::problem: 100%25\n"},
+	} {
+		if got := warn(tc.file, tc.line); got != tc.want {
+			t.Errorf("warnAt(%q, %d) printed %q, want %q", tc.file, tc.line, got, tc.want)
+		}
+	}
+
+	// paths are relative to the workspace, not to where the run is now
+	if err := os.Chdir("r"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := warn("README.md", 0), "::warning file=r/README.md::problem: 100%25\n"; got != want {
+		t.Errorf("from a subdirectory, printed %q, want %q", got, want)
+	}
+	t.Setenv("GITHUB_WORKSPACE", filepath.Dir(dir))
+	if got, want := warn("README.md", 0), "::warning file="+filepath.Base(dir)+"/r/README.md::problem: 100%25\n"; got != want {
+		t.Errorf("with $GITHUB_WORKSPACE, printed %q, want %q", got, want)
+	}
+
+	githubActions = false
+	if got, want := warn("README.md", 3), "problem: 100%\n"; got != want {
+		t.Errorf("without -github-actions, printed %q, want %q", got, want)
+	}
+}
+
+func TestQuietOnSuccess(t *testing.T) {
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	previous := os.Stderr
+	// the warning stands for the output of a run that does not fail it
+	os.Stderr, logOut, warnLargeReadme = stderr, &logBuf, 1
+	t.Cleanup(func() { os.Stderr, logOut, runFailed, warnLargeReadme = previous, previous, false, 0 })
+
+	// run fetches the README of o/r, whose logo is served when found, and
+	// returns what reached stderr
+	run := func(found bool) string {
+		files := map[string]string{}
+		if found {
+			files["logo.png"] = "image"
+		}
+		src := serveRepo(t, "![logo](logo.png)\n", files)
+		runFailed = false
+		if err := stderr.Truncate(0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stderr.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := fetchRepo(context.Background(), src); err != nil {
+			fail(err)
+		}
+		flushLog()
+		out, err := os.ReadFile(stderr.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	if out := run(true); out != "" {
+		t.Errorf("a successful run printed %q", out)
+	}
+	out := run(false)
+	if !strings.Contains(out, "over -warn-large-readme") || !strings.Contains(out, "status code 404") {
+		t.Errorf("a failed run printed %q, want all of its output", out)
+	}
+}
+
+func TestWarnLargeReadme(t *testing.T) {
+	for _, tt := range []struct {
+		readme string
+		warned bool
+	}{
+		{strings.Repeat("x", 2<<10), true},
+		{"# R\n", false},
+	} {
+		src := serveRepo(t, tt.readme, nil)
+		var out strings.Builder
+		logOut, warnLargeReadme = &out, 1<<10
+		t.Cleanup(func() { logOut, warnLargeReadme = os.Stderr, 0 })
+		if err := fetchRepo(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		if warned := strings.Contains(out.String(), "over -warn-large-readme 1KB"); warned != tt.warned {
+			t.Errorf("a README of %d bytes logged %q, want the warning %v", len(tt.readme), out.String(), tt.warned)
+		}
+		if runFailed {
+			t.Error("the warning failed the run")
+		}
+	}
+}
@@ -1,27 +1,170 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"flag"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/net/html"
 )
 
 var (
 		opener			string
 		branchName		string
+		assetSelector	string
+		failFast		bool
+		maxRedirects	int
+		quietOnSuccess	bool
+		renames			= renameFlag{}
+		token			string
+		preview			bool
+		lineEndings		string
+		preserveCodeEOL	bool
+		allowedTypes	= listFlag{}
+		limit			int
+		retries			int
+		maxRetriesTotal	int
+		maxRetryWait	= 2 * time.Minute
+		githubRendered	bool
+		split			bool
+		splitLevel		int
+		limitAssets		int
+		fetchOG			bool
+		transformList	string
+		pipeline		[]string
+		stateFile		string
+		acceptLanguage	string
+		compact			bool
+		extraHeaders	= headerFlag{}
+		pdf				bool
+		pdfEngine		string
+		includeCodeRefs	bool
+		linkMode		string
+		sinceCommit		string
+		sitemap			bool
+		atFlag			string
+		atTime			time.Time
+		searchTop		bool
+		honorAttributes	bool
+		readmeMaxSize	= byteSize(10 << 20)
+		warnLargeReadme	byteSize
+		htmlParseLimit	= byteSize(2 << 20)
+		maxRepoBytes	byteSize
+		outputSpec	string
+		traceTimings	bool
+		offline		bool
+		readmeOut		string
+		titleFrontMatter	bool
+		skipBoilerplate		bool
+		boilerplateSigs		= listFlag{}
+		changelog		bool
+		dedupeAcrossRepos	bool
+		scanScripts		bool
+		verifyMode		string
+		listUnreferenced	bool
+		scanCodeLangs		string
+		reportFile		string
+		lintLangs		bool
+		strictImages		bool
+		warnInsecure		bool
+		githubActions		bool
+		respectRobots		bool
+		probeWidth		int
+		dedupManifest		bool
+		releaseNotes		bool
+		preferMain		bool
+		preferMaster		bool
+		snapshot		bool
+		parallelChunks		int
+		concurrency	= concurrencyFlag(1)
+		repoConcurrency	int
+		maxInFlight		int
+		adaptive		bool
+		singleFile		bool
+		singleFileMax	= byteSize(1 << 20)
+		rewriteBase		string
+		repoDelay		time.Duration
+		maxBytesPerPixel	float64
+		mirrorDir		string
+		useLatestRelease	bool
+		docsBranch		bool
+		repoRootPath		string
+		batchFile		string
+		printReadme		bool
+		renderInTerminal	bool
+		fetchAvatarImages	bool
+		missingReadme		string
+		maxIdleConns		int
+		maxIdleConnsPerHost	int
+		events			bool
+		skipForks		bool
+		refFile			string
+		keepUpstream		bool
+		tagMatch		string
+		withSidecar		bool
+		tokenFile		string
+		maxDirDepth		int
+		normalizeDiff		bool
+		serveTTL		time.Duration
+		stripFrontMatter	bool
+		extractFrontMatter	bool
+		cdn			string
+		refInPath		bool
+		provider		string
+		rawBase			string
+		mirrorHosts		listFlag
+		client			*http.Client
+		apiURL			string
+		metadata		bool
+		extractBadges		bool
+		outline			bool
+		validateExpand		bool
+		assetRef		string
+		harFile			string
+		ignoreRepoConfig	bool
+		htmlStdout		bool
+		cardFile		string
+		stripLiquidRefs		bool
 	)
 
+// selectorPresets maps short names accepted by -asset-selector to the
+// goquery selectors they stand for.
+var selectorPresets = map[string]string{
+	"default":     "img[src], link[href], script[src]",
+	"images-only": "img[src]",
+	"no-scripts":  "img[src], link[href]",
+}
+
 func usage() {
-	fmt.Println("Usage: go run main.go [options] <github-repo-link>")
+	fmt.Println("Usage: go run main.go [options] <github-repo-link | gh:owner/repo | @owner/repo>")
+	fmt.Println("       go run main.go [options] org|user <name>")
+	fmt.Println("       go run main.go [options] search <query>")
+	fmt.Println("       go run main.go [options] -from-file <file>")
+	fmt.Println("       go run main.go [options] doctor")
+	fmt.Println("       go run main.go validate <dir>")
+	fmt.Println("       go run main.go [options] serve [addr]")
+	fmt.Println("       go run main.go [options] diff <repo-a> <repo-b>")
+	fmt.Println("       go run main.go archive verify [-offline] <dir>")
 	flag.PrintDefaults()
 }
 
@@ -29,210 +172,2296 @@ func main() {
 
 	flag.StringVar(&branchName, "b", "HEAD", "branch of the repository")
 	flag.StringVar(&opener, "o", "", "command to open README")
+	flag.StringVar(&assetSelector, "asset-selector", "default", "CSS selector for asset tags, or a preset: default, images-only, no-scripts")
+	flag.BoolVar(&failFast, "fail-fast", false, "abort the run at the first failed download")
+	flag.IntVar(&maxRedirects, "max-redirects", 10, "maximum number of redirects to follow per request")
+	flag.StringVar(&apiURL, "api-url", "https://api.github.com", "base URL of the GitHub API")
+	flag.BoolVar(&metadata, "metadata", false, "save the repository description, topics, stars and language to metadata.json")
+	flag.BoolVar(&validateExpand, "validate-expand", false, "check the syntax of the generated expand.sh with bash -n")
+	flag.BoolVar(&outline, "outline", false, "save the heading hierarchy of the README, with anchors and offsets, to outline.json")
+	flag.BoolVar(&extractBadges, "extract-badges", false, "summarize the CI, coverage, version and license badges of the README in badges.json")
+	flag.BoolVar(&quietOnSuccess, "quiet-on-success", false, "print nothing unless something fails")
+	flag.Var(renames, "rename", "store an asset under another local path, as 'old=>new' (repeatable)")
+	flag.StringVar(&token, "token", os.Getenv("GITHUB_TOKEN"), "GitHub token for API requests (defaults to $GITHUB_TOKEN)")
+	flag.BoolVar(&preview, "preview", false, "write an index.html with a thumbnail of the README's first image")
+	flag.StringVar(&lineEndings, "normalize-line-endings", "", "rewrite the README's line endings to lf or crlf")
+	flag.BoolVar(&preserveCodeEOL, "preserve-code-eol", false, "keep the line endings inside code fences when normalizing")
+	flag.Var(&allowedTypes, "allow-content-type", "only keep assets of this content type, globs like image/* allowed (repeatable)")
+	flag.IntVar(&limit, "limit", 0, "fetch at most this many repositories of an org or user (0 for all)")
+	flag.IntVar(&retries, "retries", 2, "retry a failed request this many times")
+	flag.IntVar(&maxRetriesTotal, "max-retries-total", 0, "cap on retries across the whole run (0 for no cap)")
+	flag.DurationVar(&maxRetryWait, "max-retry-wait", maxRetryWait, "longest Retry-After a rate limited request waits before it fails instead")
+	flag.BoolVar(&githubRendered, "github-rendered", false, "save the README as rendered by GitHub to README.html")
+	flag.BoolVar(&split, "split-sections", false, "also write each top-level section of the README to its own file in sections/")
+	flag.IntVar(&splitLevel, "split-level", 2, "deepest heading level that starts a new section with -split-sections")
+	flag.Var(&maxRepoBytes, "max-repo-bytes", "stop downloading assets of a repository once this many bytes, like 50MB, were downloaded for it (0 for no limit)")
+	flag.IntVar(&limitAssets, "limit-assets", 0, "download only the first N assets of the README (0 for all)")
+	flag.BoolVar(&fetchOG, "fetch-og", false, "save the repository's social preview image as social-preview.png")
+	flag.StringVar(&transformList, "transform", "", "comma separated markdown processors to run in order: emojify, normalize-headings, strip-comments, toc")
+	flag.StringVar(&stateFile, "state-file", "", "record completed repositories of an org or user batch here and skip them when resuming")
+	flag.StringVar(&acceptLanguage, "accept-language", "", "Accept-Language header sent with every request")
+	flag.BoolVar(&compact, "compact", false, "remove downloaded assets that the saved README does not reference")
+	flag.Var(extraHeaders, "header", "extra 'Name: value' header sent with every request (repeatable)")
+	flag.BoolVar(&pdf, "pdf", false, "also render the README to README.pdf")
+	flag.StringVar(&pdfEngine, "pdf-engine", "wkhtmltopdf", "HTML to PDF converter for -pdf, called as '<engine> <input.html> <output.pdf>'")
+	flag.BoolVar(&includeCodeRefs, "include-code-refs", false, "also download asset paths quoted in inline code")
+	flag.StringVar(&linkMode, "link-mode", "keep", "what to do with links to other repository files: keep, github (link to GitHub) or local (download them)")
+	flag.StringVar(&sinceCommit, "since-commit", "", "only download again the assets that changed between this commit and the ref")
+	flag.BoolVar(&sitemap, "sitemap", false, "with -link-mode local, describe the fetched documents and their links in sitemap.json")
+	flag.StringVar(&atFlag, "at", "", "read the README as of this RFC 3339 date")
+	flag.BoolVar(&searchTop, "top", false, "with search, fetch the top result without asking")
+	flag.BoolVar(&honorAttributes, "gitattributes", false, "honor the repository's .gitattributes: skip export-ignore assets and leave binary files' line endings alone")
+	flag.Var(&htmlParseLimit, "html-parse-limit", "find the assets of READMEs larger than this by scanning for tags instead of parsing their HTML (0 for no limit)")
+	flag.Var(&readmeMaxSize, "readme-max-size", "refuse READMEs larger than this, like 10MB (0 for no limit)")
+	flag.Var(&warnLargeReadme, "warn-large-readme", "warn about READMEs larger than this, like 500KB (0 for no warning)")
+	flag.BoolVar(&changelog, "changelog", false, "also fetch the changelog (CHANGELOG.md, HISTORY.md or CHANGES.md) and its assets")
+	flag.BoolVar(&dedupeAcrossRepos, "dedupe-across-repos", false, "in a batch, hard link assets whose content another repository already saved instead of keeping another copy")
+	flag.BoolVar(&scanScripts, "scan-scripts", false, "also download asset paths found in string literals of inline scripts")
+	flag.StringVar(&verifyMode, "verify-local", "", "check that every local reference of the saved README exists: warn or fail")
+	flag.StringVar(&reportFile, "report", "", "also save a Markdown report of the fetch, with its source, assets and warnings, under this name")
+	flag.BoolVar(&lintLangs, "lint-code-langs", false, "warn about fenced code blocks of the README whose language is unknown, often a typo")
+	flag.BoolVar(&preferMain, "prefer-main", true, "when the default branch has to be guessed, try main before master")
+	flag.BoolVar(&preferMaster, "prefer-master", false, "when the default branch has to be guessed, try master before main, as legacy repositories need")
+	flag.BoolVar(&releaseNotes, "release-notes", false, "also save the notes of the latest release as "+releaseNotesFile+", with their images")
+	flag.BoolVar(&dedupManifest, "dedup-manifest", false, "save references.json, mapping every asset reference of the README to its URL and local file")
+	flag.IntVar(&probeWidth, "probe-candidates", 1, "how many candidate file names, like the changelog's, to look for at once")
+	flag.BoolVar(&respectRobots, "respect-robots", false, "skip images on other hosts, like those of -release-notes and -fetch-avatars, that the robots.txt of their host disallows")
+	flag.BoolVar(&githubActions, "github-actions", false, "report errors and warnings as GitHub Actions annotations, shown inline in the workflow run")
+	flag.BoolVar(&warnInsecure, "warn-insecure-assets", false, "warn about assets of the README fetched over plain http://, which are mixed content on https pages")
+	flag.BoolVar(&strictImages, "strict-image-refs", false, "warn about markdown images of the README whose downloaded file is not an image")
+	flag.BoolVar(&snapshot, "snapshot", false, "write each commit of the repository to its own directory, with a latest link to the newest one")
+	flag.StringVar(&scanCodeLangs, "scan-code-assets", "", "comma separated languages, like yaml,json, whose fenced code blocks are scanned for asset paths to download")
+	flag.BoolVar(&listUnreferenced, "list-unreferenced", false, "list the downloaded assets that expand.sh would not find in the repository at the fetched ref")
+	flag.Var(&concurrency, "concurrency", "how many assets of a repository to download at once, or auto to scale with the CPUs")
+	flag.IntVar(&repoConcurrency, "repo-concurrency", 1, "how many repositories of a batch to fetch at once, each downloading -concurrency assets at a time")
+	flag.IntVar(&maxInFlight, "max-in-flight", 0, "how many assets to download at once across all repositories of the run (0 for no limit beyond -repo-concurrency times -concurrency)")
+	flag.BoolVar(&singleFile, "single-file-md", false, "also save the README with its local images embedded as data URIs, as one portable markdown file")
+	flag.Var(&singleFileMax, "single-file-max-image", "leave images larger than this, like 512KB, as references in the -single-file-md file (0 for no limit)")
+	flag.BoolVar(&adaptive, "adaptive-concurrency", false, "download fewer assets at once while hosts answer 429 or 403, and more again as they recover, up to -concurrency")
+	flag.IntVar(&parallelChunks, "parallel-chunks", 1, "download large assets as this many concurrent byte ranges")
+	flag.StringVar(&rewriteBase, "rewrite-relative-to", "", "rewrite local references of the README relative to this directory of the output")
+	flag.DurationVar(&repoDelay, "repo-delay", 0, "wait this long between the repositories of an org or user")
+	flag.Float64Var(&maxBytesPerPixel, "max-bytes-per-pixel", 0, "skip images whose file size exceeds this many bytes per pixel (0 for no limit)")
+	flag.StringVar(&readmeOut, "readme-out", "", "save the README under this name, like index.md for docs sites, instead of README.md")
+	flag.BoolVar(&titleFrontMatter, "title-front-matter", false, "add a front matter title taken from the first level 1 heading of the README")
+	flag.BoolVar(&offline, "offline", false, "with archive verify, fail when the README still loads resources from the network")
+	flag.BoolVar(&traceTimings, "trace", false, "time the DNS, connect, TLS and first byte phases of every request and report them at the end")
+	flag.StringVar(&outputSpec, "output", "", "upload the fetch to s3://bucket/prefix, with credentials from the AWS environment")
+	flag.StringVar(&mirrorDir, "mirror-into-git", "", "fetch into this git working tree and commit the changes")
+	flag.BoolVar(&useLatestRelease, "latest-release", false, "read the README at the tag of the latest published release")
+	flag.StringVar(&repoRootPath, "repo-root-path", "", "directory of the repository the README sits in, like docs, which relative references resolve against; for raw and blob links whose ref has slashes")
+	flag.BoolVar(&docsBranch, "docs-branch", false, "read the README, or index.md, of the first of the gh-pages, docs and documentation branches that has one, before the default branch")
+	flag.StringVar(&batchFile, "from-file", "", "fetch the repositories listed in this file, one per line with an optional ref (- for stdin)")
+	flag.BoolVar(&printReadme, "print-readme", false, "write the processed README to stdout instead of saving anything")
+	flag.BoolVar(&renderInTerminal, "render-terminal", false, "like -print-readme, with the markdown laid out and styled for the terminal")
+	flag.BoolVar(&fetchAvatarImages, "fetch-avatars", false, "download the GitHub avatars the README shows and point it at the local copies")
+	flag.StringVar(&missingReadme, "missing-readme", "warn", "how a batch treats a repository without a README: warn, fail or skip")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", 100, "idle connections kept open for reuse across all hosts")
+	flag.IntVar(&maxIdleConnsPerHost, "max-idle-conns-per-host", 16, "idle connections kept open for reuse per host")
+	flag.BoolVar(&events, "events", false, "write JSON lines events for repositories and assets to stdout as they happen")
+	flag.BoolVar(&skipForks, "skip-forks", false, "leave forks out of org, user and -from-file batches")
+	flag.StringVar(&cardFile, "card", "", "render a social card with the repository name, first heading and logo to this PNG in the output directory")
+	flag.BoolVar(&htmlStdout, "html-stdout", false, "write the README rendered as a standalone HTML page, with absolute asset URLs, to stdout")
+	flag.BoolVar(&ignoreRepoConfig, "ignore-repo-config", false, "do not read the repository's .readtheirs.yaml")
+	flag.StringVar(&harFile, "har", "", "record every request and response of the run to this HTTP Archive file")
+	flag.StringVar(&assetRef, "asset-ref", "", "read assets at this ref first, falling back to the README's ref for those it lacks")
+	flag.StringVar(&refFile, "ref-file", "", "read the ref from the first line of this file instead of -b")
+	flag.BoolVar(&keepUpstream, "keep-upstream-links", false, "download assets but save the README exactly as upstream has it")
+	flag.StringVar(&tagMatch, "tag-match", "", "read the README at the highest tag matching this glob, like '1.*'")
+	flag.BoolVar(&withSidecar, "sidecar", false, "write <asset>.meta.json with the source, type, size and ETag of each asset")
+	flag.StringVar(&tokenFile, "token-file", "", "read the GitHub token from this file, as mounted secrets are")
+	flag.IntVar(&maxDirDepth, "max-dir-depth", 16, "skip assets nested deeper than this many directories (0 for no limit)")
+	flag.BoolVar(&normalizeDiff, "normalize-for-diff", false, "settle cosmetic differences so re-fetches of an unchanged README diff cleanly")
+	flag.DurationVar(&serveTTL, "serve-ttl", 10*time.Minute, "how long serve keeps a fetched README before fetching it again")
+	flag.BoolVar(&stripLiquidRefs, "strip-liquid", false, "turn Jekyll paths like {{ site.baseurl }}/assets/x.png into relative ones so their assets are downloaded")
+	flag.BoolVar(&stripFrontMatter, "strip-front-matter", false, "remove a leading YAML or TOML front matter block from the README")
+	flag.BoolVar(&extractFrontMatter, "extract-front-matter", false, "like -strip-front-matter, saving the block as frontmatter.yaml or frontmatter.toml")
+	flag.StringVar(&cdn, "cdn", "", "download assets through a GitHub CDN mirror first: jsdelivr or statically")
+	flag.BoolVar(&refInPath, "ref-in-path", false, "keep assets under an @<ref>/ directory so several refs can share the output")
+	flag.StringVar(&provider, "provider", "github", "where repositories live: github, or generic for any host with a raw endpoint")
+	flag.Var(&mirrorHosts, "mirror-host", "raw file URL template of a backup host, with {repo}, {ref} and {path}, to read from when the repository's host fails (repeatable)")
+	flag.StringVar(&rawBase, "raw-base", "", "raw file URL template of -provider generic, with {repo}, {ref} and {path}")
+	flag.BoolVar(&skipBoilerplate, "skip-boilerplate", false, "skip repositories whose README is generated boilerplate")
+	flag.Var(&boilerplateSigs, "boilerplate-signature", "text marking a README as boilerplate for -skip-boilerplate, instead of the built-in ones (repeatable)")
 	flag.Usage = usage
 	flag.Parse()
 
-	repoLink := flag.Arg(0)
+	// flags may also follow a subcommand name
+	command, operand := flag.Arg(0), flag.Arg(1)
+	archiveDir := ""
+	switch command {
+	case "doctor":
+		flag.CommandLine.Parse(flag.Args()[1:])
+	case "org", "user", "search", "validate", "serve":
+		if flag.NArg() > 1 {
+			flag.CommandLine.Parse(flag.Args()[2:])
+		}
+	case "diff":
+		if flag.NArg() > 2 {
+			flag.CommandLine.Parse(flag.Args()[3:])
+		}
+	case "archive":
+		// archive verify [flags] <dir> [flags]
+		if flag.NArg() > 2 {
+			flag.CommandLine.Parse(flag.Args()[2:])
+			archiveDir = flag.Arg(0)
+			if flag.NArg() > 1 {
+				flag.CommandLine.Parse(flag.Args()[1:])
+			}
+		}
+	}
 
-	u, err := url.Parse(repoLink)
+	selector, err := resolveSelector(assetSelector)
 	if err != nil {
-		panic(err)
+		fatal(err)
 	}
+	assetSelector = selector
 
-	if !strings.HasPrefix(u.Host, "github.com") {
-		fmt.Println("The provided link is not a GitHub repository link")
-		os.Exit(1)
+	err = checkLineEndingMode(lineEndings)
+	if err != nil {
+		fatal(err)
 	}
 
-	os.MkdirAll(filepath.Join(".", filepath.Base(u.Path)), 0755)
+	// an explicit -token wins over -token-file, which wins over $GITHUB_TOKEN
+	if tokenFile != "" {
+		explicit := false
+		flag.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == "token"
+		})
+		if !explicit {
+			token, err = readTokenFile(tokenFile)
+			if err != nil {
+				fatal(err)
+			}
+		}
+	}
 
-	// retrieve the README.md file from the repository
-	readme, err := getReadme(u, branchName)
+	if refFile != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "b" {
+				fatal(fmt.Errorf("-b and -ref-file both set the ref"))
+			}
+		})
+		branchName, err = readRefFile(refFile)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if repoRootPath != "" {
+		repoRootPath = path.Clean(strings.Trim(repoRootPath, "/"))
+		if repoRootPath == ".." || strings.HasPrefix(repoRootPath, "../") {
+			fatal(fmt.Errorf("-repo-root-path must be inside the repository, got %q", repoRootPath))
+		}
+		if repoRootPath == "." {
+			repoRootPath = ""
+		}
+	}
+
+	if docsBranch {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "b" || f.Name == "ref-file" {
+				fatal(fmt.Errorf("-docs-branch picks the branch and cannot be combined with -%s", f.Name))
+			}
+		})
+		if useLatestRelease || tagMatch != "" {
+			fatal(fmt.Errorf("-docs-branch reads a branch, not a release tag"))
+		}
+	}
+
+	if preferMaster {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "prefer-main" && preferMain {
+				fatal(fmt.Errorf("-prefer-main and -prefer-master ask for opposite orders"))
+			}
+		})
+	}
+
+	err = checkRef(branchName)
 	if err != nil {
-		panic(err)
+		fatal(err)
 	}
 
-	// download all assets linked in the README.md file
-	err = downloadAssets(readme, u, branchName)
+	if assetRef != "" {
+		err = checkRef(assetRef)
+		if err != nil {
+			fatal(fmt.Errorf("invalid -asset-ref: %v", err))
+		}
+	}
+
+	err = checkProvider(command)
 	if err != nil {
-		panic(err)
+		fatal(err)
+	}
+	for _, host := range mirrorHosts {
+		if !strings.Contains(host, "{path}") {
+			fatal(fmt.Errorf("-mirror-host %q has no {path} placeholder", host))
+		}
 	}
 
-	// generate a bash script to rebase the upstream branch onto the local branch
-	os.Chdir(filepath.Join(".", filepath.Base(u.Path)))
-	f, err := os.Create("expand.sh")
+	if atFlag != "" {
+		atTime, err = time.Parse(time.RFC3339, atFlag)
+		if err != nil {
+			fatal(fmt.Errorf("invalid -at date: %v", err))
+		}
+	}
+
+	if renderInTerminal && htmlStdout {
+		fatal(fmt.Errorf("-render-terminal and -html-stdout both pick how the README is printed"))
+	}
+	if renderInTerminal || htmlStdout {
+		printReadme = true
+	}
+
+	if extractFrontMatter {
+		stripFrontMatter = true
+	}
+
+	if reportFile != "" && (filepath.Base(reportFile) != reportFile || reportFile == "." || reportFile == "..") {
+		fatal(fmt.Errorf("-report %q is not a plain file name", reportFile))
+	}
+	if reportFile == readmeName() || reportFile == "expand.sh" {
+		fatal(fmt.Errorf("-report %q would overwrite the file of that name", reportFile))
+	}
+	if readmeOut != "" && (filepath.Base(readmeOut) != readmeOut || readmeOut == "." || readmeOut == ".." || readmeOut == "expand.sh") {
+		fatal(fmt.Errorf("-readme-out %q is not a plain file name", readmeOut))
+	}
+	if githubRendered && (readmeOut != "" || titleFrontMatter) {
+		fatal(fmt.Errorf("-readme-out and -title-front-matter need a markdown README, not -github-rendered"))
+	}
+	if githubRendered && (lintLangs || strictImages) {
+		fatal(fmt.Errorf("-lint-code-langs and -strict-image-refs need a markdown README, not -github-rendered"))
+	}
+
+	if probeWidth < 1 {
+		fatal(fmt.Errorf("-probe-candidates must be at least 1"))
+	}
+
+	if cdn != "" && cdn != "jsdelivr" && cdn != "statically" {
+		fatal(fmt.Errorf("unknown -cdn %q, expected jsdelivr or statically", cdn))
+	}
+
+	err = checkKeepUpstream()
 	if err != nil {
-		panic(err)
+		fatal(err)
 	}
-	defer f.Close()
-	_, err = f.WriteString(fmt.Sprintf(`#!/bin/bash
-git clone %s .repo
-mv -f .repo/* .repo/.* ./
-rm -rf .repo
-rm expand.sh
-git reset --hard
-`, repoLink))
+
+	if tagMatch != "" && useLatestRelease {
+		fatal(fmt.Errorf("-tag-match and -latest-release both pick the tag"))
+	}
+	if tagMatch != "" {
+		if _, err := path.Match(tagMatch, ""); err != nil {
+			fatal(fmt.Errorf("invalid -tag-match pattern %q: %v", tagMatch, err))
+		}
+	}
+
+	if singleFile && githubRendered {
+		fatal(fmt.Errorf("-single-file-md embeds images in the markdown README and cannot be combined with -github-rendered"))
+	}
+
+	if outline && githubRendered {
+		fatal(fmt.Errorf("-outline reads the markdown README and cannot be combined with -github-rendered"))
+	}
+
+	if events && printReadme {
+		fatal(fmt.Errorf("-events and -print-readme both write to stdout"))
+	}
+
+	if parallelChunks < 1 {
+		fatal(fmt.Errorf("-parallel-chunks must be at least 1"))
+	}
+
+	if repoConcurrency < 1 {
+		fatal(fmt.Errorf("-repo-concurrency must be at least 1"))
+	}
+	if maxInFlight < 0 {
+		fatal(fmt.Errorf("-max-in-flight cannot be negative"))
+	}
+	if maxInFlight > 0 {
+		inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	if adaptive {
+		downloadLimit = newAdaptiveLimiter(concurrency.workers())
+	}
+
+	if missingReadme != "warn" && missingReadme != "fail" && missingReadme != "skip" {
+		fatal(fmt.Errorf("unknown -missing-readme mode %q, expected warn, fail or skip", missingReadme))
+	}
+
+	if verifyMode != "" && verifyMode != "warn" && verifyMode != "fail" {
+		fatal(fmt.Errorf("unknown -verify-local mode %q, expected warn or fail", verifyMode))
+	}
+
+	err = checkLinkMode(linkMode)
+	if err != nil {
+		fatal(err)
+	}
+
+	pipeline, err = parseTransforms(transformList)
+	if err != nil {
+		fatal(err)
+	}
+	if normalizeDiff {
+		pipeline = append(pipeline, "normalize-for-diff")
+	}
+
+	if quietOnSuccess {
+		logOut = &logBuf
+	}
+	client = newClient()
+	if harFile != "" {
+		// the file stays where it was asked for, even after -mirror changes
+		// the working directory
+		harFile, err = filepath.Abs(harFile)
+		if err != nil {
+			fatal(err)
+		}
+		record(client)
+		defer saveHAR()
+	}
+	defer reportTraces()
+	// so are the list of repositories and the batch state, which are only
+	// read once -output or -mirror-into-git have changed directory
+	if batchFile != "" && batchFile != "-" {
+		batchFile, err = filepath.Abs(batchFile)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if stateFile != "" {
+		stateFile, err = filepath.Abs(stateFile)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if outputSpec != "" {
+		if mirrorDir != "" {
+			fatal(fmt.Errorf("-output and -mirror-into-git both pick where the fetch goes"))
+		}
+		output, err = parseOutput(outputSpec)
+		if err != nil {
+			fatal(err)
+		}
+		staging, err := enterStaging()
+		if err != nil {
+			fatal(fmt.Errorf("failed to set up the staging directory: %v", err))
+		}
+		defer os.RemoveAll(staging)
+	}
+
+	if mirrorDir != "" && command != "doctor" && command != "validate" && command != "serve" && command != "diff" && command != "archive" {
+		err = enterMirror(mirrorDir)
+		if err != nil {
+			fatal(fmt.Errorf("failed to set up the mirror in %s: %v", mirrorDir, err))
+		}
+	}
+
+	switch command {
+	case "doctor":
+		if !runDoctor(ctx) {
+			os.Exit(1)
+		}
+		return
+	case "archive":
+		if operand != "verify" {
+			fatal(fmt.Errorf("unknown archive command %q, expected verify", operand))
+		}
+		if archiveDir == "" {
+			fatal(fmt.Errorf("missing directory to verify"))
+		}
+		if !runArchiveVerify(archiveDir) {
+			os.Exit(1)
+		}
+		return
+	case "validate":
+		if operand == "" {
+			fatal(fmt.Errorf("missing directory to validate"))
+		}
+		if !runValidate(operand) {
+			os.Exit(1)
+		}
+		return
+	case "org", "user":
+		err = runAccount(ctx, command, operand)
+		if err != nil {
+			cancel()
+			fatal(err)
+		}
+		flushLog()
+		return
+	case "serve":
+		err = runServe(ctx, operand)
+		if err != nil {
+			cancel()
+			fatal(err)
+		}
+		flushLog()
+		return
+	case "search":
+		err = runSearch(ctx, operand)
+		if err != nil {
+			cancel()
+			fatal(err)
+		}
+		flushLog()
+		return
+	case "diff":
+		differ, err := runDiff(ctx, os.Stdout, operand, flag.Arg(2))
+		if err != nil {
+			cancel()
+			fatal(err)
+		}
+		flushLog()
+		// like diff(1), differences are reported in the exit status
+		if differ {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if batchFile != "" {
+		repos, err := readBatchFile(batchFile)
+		if err == nil && skipForks {
+			repos, err = dropForks(ctx, repos)
+		}
+		if err == nil {
+			err = runBatch(ctx, repos)
+		}
+		if err != nil {
+			cancel()
+			fatal(err)
+		}
+		flushLog()
+		return
+	}
+
+	src, err := parseSource(ctx, flag.Arg(0))
 	if err != nil {
-		panic(err)
+		fatal(err)
 	}
 
-	// give it executable permissions
-	cmd := exec.Command("chmod", "+x", "expand.sh")
-	err = cmd.Run()
+	err = fetchRepo(ctx, src)
+	if errors.Is(err, errBoilerplate) {
+		os.Remove(src.dir())
+		logf("skipped %s: %v\n", src.repo.String(), err)
+		err = nil
+	}
 	if err != nil {
-		panic(err)
+		cancel()
+		fatal(err)
 	}
 
-	if len(opener) > 0 {
+	os.Chdir(src.dir())
+	if snapshot {
+		os.Chdir("latest")
+	}
+	if len(opener) > 0 && !printReadme {
 		if strings.HasSuffix(opener, ".sh") {
 			err = exec.Command("/bin/sh", opener, filepath.Join(".")).Run()
 		} else {
 			err = exec.Command(opener, filepath.Join(".")).Run()
 		}
 		if err != nil {
-			panic(err)
+			fatal(err)
 		}
 	}
+
+	flushLog()
 }
 
-func getReadme(u *url.URL, b string) (*goquery.Document, error) {
-	readmeURL := fmt.Sprintf("%s/raw/%s/README.md", u.String(), b)
-	resp, err := http.Get(readmeURL)
+// parseRepoLink checks that repoLink points at a GitHub repository. The
+// gh:owner/repo and @owner/repo shorthands are accepted as well.
+func parseRepoLink(repoLink string) (*url.URL, error) {
+	u, err := url.Parse(expandShorthand(repoLink))
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("failed to retrieve the README.md file, status code: %d", resp.StatusCode)
-	}
 
-	// create a new buffer and copy the response body into it
-	buf := new(bytes.Buffer)
-	contentBuffer := new(bytes.Buffer)
-	_, err = io.Copy(buf, resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	if !strings.HasPrefix(u.Host, "github.com") {
+		return nil, fmt.Errorf("The provided link is not a GitHub repository link")
 	}
+	takeUserinfo(u)
+	return u, nil
+}
 
-	contentBuffer.Write(buf.Bytes())
+// parseSource works out where to read the README from. Besides repository
+// links, it accepts raw.githubusercontent.com links to a README, whose ref
+// and path take the place of -b and the root README.md, blob links to a
+// file, which work the same way with or without ?plain=1, and pull request
+// links, which read the README at the head of the pull request. With
+// -provider generic, any repository link is taken as is.
+func parseSource(ctx context.Context, link string) (source, error) {
+	if provider == "generic" {
+		return genericSource(ctx, link)
+	}
+	u, err := url.Parse(link)
+	if err == nil && u.Host == "raw.githubusercontent.com" {
+		parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 4)
+		if len(parts) < 4 || parts[3] == "" {
+			return source{}, fmt.Errorf("cannot find owner, repository, ref and path in %s", redactLink(link))
+		}
+		repo := &url.URL{Scheme: "https", Host: "github.com", Path: "/" + parts[0] + "/" + parts[1]}
+		ref, readme, err := splitRefPath(parts[2], parts[3])
+		if err != nil {
+			return source{}, err
+		}
+		return source{repo: repo, ref: ref, readme: readme}, nil
+	}
 
-	doc, err := goquery.NewDocumentFromReader(buf)
+	u, err = parseRepoLink(link)
 	if err != nil {
-		fmt.Printf("failed to parse the README.md file.\n")
-		panic(err)
+		return source{}, err
 	}
-	doc.Find("img[src], link[href], script[src]").Each(func(_ int, s *goquery.Selection) {
-		if src, exists := s.Attr("src"); exists {
-			if strings.Contains(src, "?raw=true") {
-				s.SetAttr("src", strings.Replace(src, "?raw=true", "", -1))
-			}
+	// blob links, including the ?plain=1 "view raw markdown" ones, read that
+	// file at that ref
+	if parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 5); len(parts) == 5 && parts[2] == "blob" && parts[4] != "" {
+		ref, readme, err := splitRefPath(parts[3], parts[4])
+		if err != nil {
+			return source{}, err
 		}
-		if href, exists := s.Attr("href"); exists {
-			if strings.Contains(href, "?raw=true") {
-				s.SetAttr("href", strings.Replace(href, "?raw=true", "", -1))
-			}
+		repo := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + parts[0] + "/" + parts[1]}
+		return source{repo: repo, ref: ref, readme: readme}, nil
+	}
+	if parts := strings.Split(strings.Trim(u.Path, "/"), "/"); len(parts) == 4 && parts[2] == "pull" {
+		return pullRequestSource(ctx, parts[0], parts[1], parts[3])
+	}
+	return source{repo: u, ref: branchName, readme: path.Join(repoRootPath, "README.md")}, nil
+}
+
+// splitRefPath splits the ref and file path of a raw or blob link, given
+// as its first element and the rest. Refs with slashes cannot be told from
+// the path that way, so with -repo-root-path, the directory the file sits
+// in, the path is that directory and the file's name, and the ref all that
+// comes before.
+func splitRefPath(first, rest string) (ref, file string, err error) {
+	ref, file = first, rest
+	if repoRootPath != "" {
+		whole := first + "/" + rest
+		file = path.Join(repoRootPath, path.Base(whole))
+		ref = strings.TrimSuffix(whole, "/"+file)
+		if ref == whole || ref == "" {
+			return "", "", fmt.Errorf("%s does not end in %s, as -repo-root-path says it does", whole, file)
 		}
-	})
+	}
+	return ref, file, checkRef(ref)
+}
 
-	content := contentBuffer.String()
+// expandShorthand turns gh:owner/repo and @owner/repo into the repository's
+// GitHub URL. Anything else is returned unchanged.
+func expandShorthand(repoLink string) string {
+	for _, prefix := range []string{"gh:", "@"} {
+		slug := strings.TrimPrefix(repoLink, prefix)
+		if slug == repoLink {
+			continue
+		}
+		if parts := strings.Split(slug, "/"); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			return "https://github.com/" + slug
+		}
+	}
+	return repoLink
+}
 
-	// remove wrapper from the content string
-	content = strings.Replace(content, "?raw=true)", ")", -1)
-	aRemoverRegex := regexp.MustCompile(`## <a .*></a>`)
-	content = aRemoverRegex.ReplaceAllString(content, "## ")
+// source is a README to mirror: the repository it lives in, the ref to read
+// it at and its path within the repository.
+type source struct {
+	repo   *url.URL
+	ref    string
+	readme string
 
-	// write readme to file
-	f, err := os.Create(filepath.Join(".", filepath.Base(u.Path), "README.md"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create README.md file: %v", err)
+	// attributes holds the repository's .gitattributes with
+	// -gitattributes, and is empty otherwise
+	attributes gitAttributes
+
+	// config holds the repository's .readtheirs.yaml, unless
+	// -ignore-repo-config is set
+	config repoConfig
+
+	// host is the -mirror-host template files are read from, when the
+	// repository's own host failed to serve the README
+	host string
+
+	// downloaded counts the asset bytes fetched for the repository so far,
+	// across its documents, with -max-repo-bytes
+	downloaded *int64
+
+	// report collects the outcome of every asset with -report
+	report *fetchReport
+
+	// references maps asset references to their files with
+	// -dedup-manifest
+	references *referenceManifest
+
+	// codeAssets are the asset paths -scan-code-assets found in the code
+	// blocks of the document
+	codeAssets []string
+
+	// refImages are the local targets of the reference-style images of the
+	// document
+	refImages []string
+
+	// snapshot is the directory of the repository's directory the fetch is
+	// written to with -snapshot
+	snapshot string
+
+	// followed is where a document the repository's config follows is
+	// saved, relative to the output directory, which its assets are placed
+	// relative to as well. It is empty for the README.
+	followed string
+}
+
+// dir returns the output directory, named after the repository, or the
+// snapshot inside it with -snapshot. For a followed document it is the
+// directory inside that the document is saved to.
+func (s source) dir() string {
+	dir := s.repoDir()
+	if s.snapshot != "" {
+		dir = filepath.Join(dir, s.snapshot)
 	}
-	defer f.Close()
-	_, err = io.Copy(f, bytes.NewBufferString(content))
-	if err != nil {
-		return nil, fmt.Errorf("failed to write README.md file: %v", err)
+	if s.followed != "" {
+		dir = filepath.Join(dir, filepath.FromSlash(path.Dir(s.followed)))
 	}
+	return dir
+}
 
-	return doc, nil
+// documentName returns the name the document of s is saved as in dir.
+func (s source) documentName() string {
+	if s.followed != "" {
+		return path.Base(s.followed)
+	}
+	return readmeName()
 }
 
-func downloadAssets(readme *goquery.Document, u *url.URL, b string) error {
-	// find all image, link and script tags that are not local
-	assets := []string{}
-	// replace all ?raw=true with empty string in readme
-	readme.Find("img[src], link[href], script[src]").Each(func(_ int, s *goquery.Selection) {
-		if src, exists := s.Attr("src"); exists {
-			if !strings.HasPrefix(src, "http") {
-				assets = append(assets, src)
-			}
-		}
-		if href, exists := s.Attr("href"); exists {
-			if !strings.HasPrefix(href, "http") {
-				assets = append(assets, href)
-			}
-		}
-	})
+// repoDir returns the directory named after the repository.
+func (s source) repoDir() string {
+	return filepath.Join(".", filepath.Base(s.repo.Path))
+}
 
-	// find markdown image links in ()
-	regex := regexp.MustCompile(`(\[.*\]\()([^https?://].*\.(png|jpg|gif|svg))\)`)
+// rawURL returns the URL serving the file at p, a path relative to the
+// repository root, at the source's ref.
+func (s source) rawURL(p string) string {
+	if s.host != "" {
+		return fillRawTemplate(s.host, s.repo, s.ref, p)
+	}
+	if provider == "generic" {
+		return fillRawTemplate(rawBase, s.repo, s.ref, p)
+	}
+	return fmt.Sprintf("%s/raw/%s/%s", s.repo.String(), escapePath(s.ref), escapePath(p))
+}
 
-	content, err := readme.Html()
-	if err != nil {
-		panic(err)
+// cdnURL returns the URL serving the file at p from the -cdn mirror of
+// GitHub, at the source's ref.
+func (s source) cdnURL(p string) string {
+	owner, repo, _ := repoSlug(s.repo)
+	if cdn == "statically" {
+		return fmt.Sprintf("https://cdn.statically.io/gh/%s/%s/%s/%s", owner, repo, escapePath(s.ref), escapePath(p))
 	}
-	content = strings.Replace(content, "?raw=true)", ")", -1)
-	matches := regex.FindAllStringSubmatch(content, -1)
-	for _, match := range matches {
-		if len(match) < 3 || match[2] == "" {
-			continue
+	return fmt.Sprintf("https://cdn.jsdelivr.net/gh/%s/%s@%s/%s", owner, repo, escapePath(s.ref), escapePath(p))
+}
+
+// assetURLs returns the URLs to try for asset, in order: the -asset-ref
+// before the ref of src and, at each ref, the -cdn before GitHub.
+func assetURLs(src source, asset string) []string {
+	p := src.repoPath(assetFile(asset))
+	refs := []string{src.ref}
+	if assetRef != "" && assetRef != src.ref {
+		refs = []string{assetRef, src.ref}
+	}
+	urls := []string{}
+	for _, ref := range refs {
+		at := src
+		at.ref = ref
+		if cdn != "" {
+			urls = append(urls, at.cdnURL(p))
 		}
-		assets = append(assets, match[2])
+		urls = append(urls, at.rawURL(p))
+	}
+	return urls
+}
+
+// escapePath escapes every segment of a slash separated path for use in a
+// URL, keeping the slashes, so refs like feature/foo and file names with
+// spaces survive.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
+	return strings.Join(segments, "/")
+}
 
-	if len(assets) == 0 {
-		return nil
+// checkRef rejects refs git itself would not accept as branch or tag names,
+// which would otherwise produce malformed URLs.
+func checkRef(ref string) error {
+	switch {
+	case ref == "":
+		return fmt.Errorf("empty ref")
+	case strings.HasPrefix(ref, "-"), strings.HasPrefix(ref, "/"), strings.HasSuffix(ref, "/"),
+		strings.HasSuffix(ref, "."), strings.HasSuffix(ref, ".lock"),
+		strings.Contains(ref, ".."), strings.Contains(ref, "//"), strings.Contains(ref, "@{"),
+		strings.ContainsAny(ref, " ~^:?*[\\"),
+		strings.IndexFunc(ref, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0:
+		return fmt.Errorf("%q is not a valid ref", ref)
 	}
+	return nil
+}
 
-	// create a directory to store the downloaded files
-	dir := filepath.Join(".", filepath.Base(u.Path))
-	err = os.MkdirAll(dir, 0755)
+// checkKeepUpstream rejects -keep-upstream-links alongside the options that
+// rewrite the README it promises to leave untouched.
+func checkKeepUpstream() error {
+	if keepUpstream && (len(renames) > 0 || linkMode == "github" || transformList != "" || lineEndings != "" || rewriteBase != "" || stripLiquidRefs || titleFrontMatter || normalizeDiff) {
+		return fmt.Errorf("-keep-upstream-links leaves the README untouched and cannot be combined with options that rewrite it")
+	}
+	return nil
+}
+
+// readRefFile returns the ref written on the first line of file, as CI
+// steps leave a resolved commit behind.
+func readRefFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to read -ref-file: %v", err)
 	}
+	ref, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(ref), nil
+}
 
-	// download each asset
-	for _, asset := range assets {
-		assetURL := fmt.Sprintf("%s/raw/%s/%s", u.String(), b, asset)
+// readTokenFile returns the token saved in file, without the whitespace
+// around it that secret files usually end with.
+func readTokenFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read -token-file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-		resp, err := http.Get(assetURL)
-		if err != nil {
-			fmt.Printf("failed to download %s: %v\n", assetURL, err)
-			continue
-		}
-		defer resp.Body.Close()
+// repoPath resolves ref, a reference made from the README, to a path
+// relative to the repository root.
+func (s source) repoPath(ref string) string {
+	return path.Join(path.Dir(s.readme), ref)
+}
 
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("unexpected status code %d for %s\n", resp.StatusCode, assetURL)
-			continue
-		}
+// fetchRepo mirrors the README of src, along with its assets, into a
+// directory named after the repository.
+func fetchRepo(ctx context.Context, src source) (err error) {
+	u := src.repo
+	dir := src.dir()
+
+	repoName := strings.Trim(u.Path, "/")
+	emit(event{Type: "repo_started", Repo: repoName})
+	stamp := fetchStamp{Repo: u.String()}
+	if maxRepoBytes > 0 {
+		src.downloaded = new(int64)
+	}
+	if reportFile != "" {
+		src.report = &fetchReport{}
+	}
+	if dedupManifest {
+		src.references = newReferenceManifest()
+	}
+	defer func() {
+		emit(event{Type: "repo_done", Repo: repoName, Host: src.host, Error: errorText(err)})
+	}()
 
-		// construct the file path to save the downloaded file
-		os.MkdirAll(filepath.Join(dir, filepath.Dir(asset)), 0755)
-		filePath := filepath.Join(dir, filepath.Dir(asset), filepath.Base(asset))
+	if provider == "github" {
+		checkTokenScopes(ctx, src)
+	}
 
-		// create the file and write the downloaded content to it
-		file, err := os.Create(filePath)
+	if docsBranch {
+		branch, readme, ok, err := findDocsBranch(ctx, src)
 		if err != nil {
-			fmt.Printf("failed to create file %s: %v\n", filePath, err)
-			continue
+			return fmt.Errorf("failed to look for a docs branch: %v", err)
 		}
-		defer file.Close()
-		_, err = io.Copy(file, resp.Body)
+		if ok {
+			logf("reading %s from its %s branch, at %s\n", u.String(), branch, readme)
+			src.ref, src.readme = branch, readme
+		} else {
+			logf("%s has no docs branch with a README, reading %s\n", u.String(), src.ref)
+		}
+	}
+
+	if useLatestRelease {
+		tag, err := latestRelease(ctx, src)
+		if err != nil {
+			return err
+		}
+		logf("reading %s at its latest release, %s\n", u.String(), tag)
+		src.ref = tag
+		stamp.ReleaseTag = tag
+	}
+
+	if tagMatch != "" {
+		tag, err := matchingTag(ctx, src, tagMatch)
+		if err != nil {
+			return err
+		}
+		logf("reading %s at %s, the highest tag matching %s\n", u.String(), tag, tagMatch)
+		src.ref = tag
+	}
+
+	// CDNs read a missing version as the latest release, not the default
+	// branch, so HEAD is pinned to its commit
+	if cdn != "" && src.ref == "HEAD" && atTime.IsZero() {
+		sha, err := resolveCommit(ctx, src)
+		if err != nil {
+			return fmt.Errorf("failed to resolve HEAD for -cdn: %v", err)
+		}
+		src.ref = sha
+	}
+
+	if !atTime.IsZero() {
+		sha, err := commitAt(ctx, src, atTime)
+		if err != nil {
+			return err
+		}
+		logf("reading %s at %s, the last commit before %s\n", u.String(), sha, atTime.Format(time.RFC3339))
+		src.ref = sha
+		stamp.Commit, stamp.At = sha, &atTime
+	}
+
+	if honorAttributes {
+		attributes, err := fetchGitAttributes(ctx, src)
+		if err != nil {
+			return err
+		}
+		src.attributes = attributes
+	}
+
+	if !ignoreRepoConfig {
+		src.config, err = fetchRepoConfig(ctx, src)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", repoConfigFile, err)
+		}
+	}
+
+	// -print-readme only shows the processed README, nothing is saved
+	if printReadme {
+		_, content, err := getDocument(ctx, &src, "")
+		if err != nil {
+			return err
+		}
+		if renderInTerminal {
+			content = renderTerminal(content, isTerminal(os.Stdout))
+		}
+		if htmlStdout {
+			page, err := renderPage(filepath.Base(u.Path), []byte(absoluteRefs(content, src)))
+			if err != nil {
+				return fmt.Errorf("failed to render the README: %v", err)
+			}
+			content = string(page)
+		}
+		_, err = io.WriteString(os.Stdout, content)
+		return err
+	}
+
+	if snapshot {
+		name, commit, err := snapshotDir(ctx, src)
+		if err != nil {
+			return err
+		}
+		logf("saving %s at %s (%s) to %s\n", u.String(), src.ref, commit, filepath.Join(src.repoDir(), name))
+		src.ref, src.snapshot = commit, name
+		dir = src.dir()
+	}
+
+	os.MkdirAll(dir, 0755)
+
+	// retrieve the README.md file from the repository
+	fetch := getReadme
+	if githubRendered {
+		fetch = getRenderedReadme
+	}
+	readme, err := fetch(ctx, &src)
+	if err != nil {
+		return err
+	}
+
+	// with -since-commit, only changed assets are downloaded again
+	var changed map[string]bool
+	if sinceCommit != "" {
+		changed, err = changedSince(ctx, src, sinceCommit)
+		if err != nil {
+			return err
+		}
+	}
+
+	// download all assets linked in the README.md file
+	saved, err := downloadAssets(ctx, readme, src, changed)
+	if err != nil {
+		return err
+	}
+	// only these come from the repository, the files added below do not
+	assets := append([]string(nil), saved...)
+
+	if fetchAvatarImages {
+		avatars, err := fetchAvatars(ctx, src)
+		if err != nil {
+			return err
+		}
+		saved = append(saved, avatars...)
+	}
+
+	if src.links() == "local" && !githubRendered {
+		linked, err := downloadLinkedFiles(ctx, src, saved)
+		if err != nil {
+			return err
+		}
+		if sitemap {
+			err = writeSitemap(src, linked)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if compact {
+		saved, err = compactAssets(dir, saved)
+		if err != nil {
+			return err
+		}
+	}
+
+	if changelog {
+		found, err := fetchChangelog(ctx, src)
+		if err != nil {
+			return err
+		}
+		// kept out of -compact, which only looks at the README
+		saved = append(saved, found...)
+	}
+
+	if releaseNotes {
+		found, err := fetchReleaseNotes(ctx, src)
+		if err != nil {
+			return err
+		}
+		saved = append(saved, found...)
+	}
+
+	if len(src.config.follow) > 0 {
+		followed, err := fetchFollowed(ctx, src)
+		if err != nil {
+			return err
+		}
+		saved = append(saved, followed...)
+	}
+
+	if pdf {
+		err = writePDF(dir, filepath.Base(u.Path))
+		if err != nil {
+			return err
+		}
+	}
+
+	if preview {
+		err = writePreview(dir, filepath.Base(u.Path), saved)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cardFile != "" {
+		err = writeCard(cardFile, dir, strings.Trim(u.Path, "/"), saved)
+		if err != nil {
+			return fmt.Errorf("failed to render the card: %v", err)
+		}
+	}
+
+	if metadata {
+		err = writeMetadata(ctx, u, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if outline {
+		err = writeOutline(dir)
+		if err != nil {
+			return fmt.Errorf("failed to save the outline: %v", err)
+		}
+	}
+
+	if extractBadges {
+		err = writeBadges(dir)
+		if err != nil {
+			return fmt.Errorf("failed to extract the badges: %v", err)
+		}
+	}
+
+	if fetchOG {
+		err = writeSocialPreview(ctx, u, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if verifyMode != "" {
+		dangling, err := verifyLocal(dir, readmeName())
+		if err != nil {
+			return err
+		}
+		for _, ref := range dangling {
+			warnf(filepath.Join(dir, readmeName()), "%s references %s, which does not exist locally", readmeName(), ref)
+			src.report.warn("%s references %s, which does not exist locally", readmeName(), ref)
+		}
+		if len(dangling) > 0 && verifyMode == "fail" {
+			return fmt.Errorf("%d dangling local references in %s", len(dangling), readmeName())
+		}
+	}
+
+	if lintLangs {
+		err = lintReadme(dir, src.report)
+		if err != nil {
+			return err
+		}
+	}
+
+	if strictImages {
+		mismatches, err := imageRefMismatches(dir)
+		if err != nil {
+			return err
+		}
+		for _, warning := range mismatches {
+			warnf(filepath.Join(dir, readmeName()), "%s: %s", readmeName(), warning)
+			src.report.warn("%s: %s", readmeName(), warning)
+		}
+	}
+
+	if warnInsecure {
+		insecure, err := insecureAssets(dir)
+		if err != nil {
+			return err
+		}
+		for _, warning := range insecure {
+			warnf(filepath.Join(dir, readmeName()), "%s: %s", readmeName(), warning)
+			src.report.warn("%s: %s", readmeName(), warning)
+		}
+	}
+
+	if singleFile {
+		err = writeSingleFile(dir, singleFileMax)
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %v", singleFileName(), err)
+		}
+	}
+
+	if rewriteBase != "" {
+		err = rebaseReadme(dir, rewriteBase)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite references relative to %s: %v", rewriteBase, err)
+		}
+	}
+
+	// generate a bash script to rebase the upstream branch onto the local branch
+	err = os.WriteFile(filepath.Join(dir, "expand.sh"), []byte(fmt.Sprintf(expandScript, u.String())), 0755)
+	if err != nil {
+		return err
+	}
+	if validateExpand {
+		err = checkScript(filepath.Join(dir, "expand.sh"))
+		if err != nil {
+			return err
+		}
+	}
+	stamp.Ref = src.ref
+	err = writeStamp(dir, stamp, append([]string{readmeName(), "README.html"}, saved...))
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %v", stampFile, err)
+	}
+	if listUnreferenced {
+		tree, err := repoTree(ctx, src)
+		if err != nil {
+			return err
+		}
+		for _, local := range unreferencedAssets(src, dir, assets, tree) {
+			warnf(filepath.Join(dir, local), "%s is not in %s at %s, expanding leaves it behind", local, strings.Trim(u.Path, "/"), src.ref)
+			src.report.warn("%s is not in %s at %s, expanding leaves it behind", local, strings.Trim(u.Path, "/"), src.ref)
+		}
+	}
+	if dedupManifest {
+		err = src.references.write(dir)
+		if err != nil {
+			return fmt.Errorf("failed to save references.json: %v", err)
+		}
+	}
+	if reportFile != "" {
+		err = writeReport(ctx, src, reportFile)
+		if err != nil {
+			return fmt.Errorf("failed to save the report: %v", err)
+		}
+	}
+	if snapshot {
+		err = pointLatest(src)
+		if err != nil {
+			return err
+		}
+	}
+	if output != nil {
+		err = uploadOutput(ctx, dir)
+		if err != nil || !snapshot {
+			return err
+		}
+		// object stores have no symlinks, so latest holds the name
+		return output.put(ctx, filepath.ToSlash(filepath.Join(src.repoDir(), "latest")), []byte(src.snapshot+"\n"))
+	}
+	if mirrorDir == "" {
+		return nil
+	}
+	return commitMirror(ctx, src)
+}
+
+// expandScript is the template of expand.sh, filled in with the repository
+// link.
+const expandScript = `#!/bin/bash
+git clone %s .repo
+mv -f .repo/* .repo/.* ./
+rm -rf .repo
+rm expand.sh
+git reset --hard
+`
+
+// checkScript has bash check the syntax of the script at file without
+// running it. Without bash, the check is skipped with a note.
+func checkScript(file string) error {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		logf("skipping the check of %s, bash is not available\n", file)
+		return nil
+	}
+	out, err := exec.Command(bash, "-n", file).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s has syntax errors: %s", file, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// resolveSelector expands a preset name into its selector and checks that
+// anything else is a selector goquery can actually use.
+func resolveSelector(s string) (string, error) {
+	if preset, ok := selectorPresets[s]; ok {
+		return preset, nil
+	}
+	if _, err := cascadia.ParseGroup(s); err != nil {
+		return "", fmt.Errorf("invalid asset selector %q: %v", s, err)
+	}
+	return s, nil
+}
+
+func getReadme(ctx context.Context, src *source) (*goquery.Document, error) {
+	doc, content, err := getDocument(ctx, src, readmeName())
+	if err != nil {
+		return nil, err
+	}
+
+	if titleFrontMatter {
+		title := firstTitle(content)
+		if title == "" {
+			logf("no level 1 heading in %s to take a front matter title from\n", src.readme)
+		} else {
+			content = addTitleFrontMatter(content, title)
+			err = os.WriteFile(filepath.Join(src.dir(), readmeName()), []byte(content), 0644)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add the front matter title: %v", err)
+			}
+		}
+	}
+
+	if split {
+		err = writeSections(src.dir(), content, splitLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split the README into sections: %v", err)
+		}
+	}
+
+	return doc, nil
+}
+
+// processReadme applies the cleanups and rewrites of the run to the
+// content of a fetched markdown document of src.
+func processReadme(content string, src source) string {
+	// remove wrapper from the content string
+	content = strings.Replace(content, "?raw=true)", ")", -1)
+	content = refRawRegex.ReplaceAllString(content, "$1")
+	aRemoverRegex := regexp.MustCompile(`## <a .*></a>`)
+	content = aRemoverRegex.ReplaceAllString(content, "## ")
+
+	// point renamed assets at their new location
+	for old, renamed := range renames {
+		content = rewriteRef(content, old, renamed)
+	}
+
+	if src.links() == "github" {
+		content = githubLinks(content, src)
+	}
+
+	content = applyTransforms(content, src.transforms())
+
+	if lineEndings != "" && !src.attributes.binary(src.readme) {
+		content = normalizeLineEndings(content, lineEndings, preserveCodeEOL)
+	}
+
+	return content
+}
+
+// getDocument fetches the markdown document at src.readme, cleans it up and
+// saves it as name in the output directory, unless name is empty. It returns
+// the document parsed for asset detection along with the cleaned up content.
+// A missing document is reported as errNotFound. A document that is a
+// symlink is read from its target, which src.readme is updated to.
+func getDocument(ctx context.Context, src *source, name string) (*goquery.Document, string, error) {
+	body, err := readFollowingLinks(ctx, src)
+	if err != nil {
+		return nil, "", err
+	}
+	// only a hint for curation, large READMEs are often generated
+	if warnLargeReadme > 0 && len(body) > int(warnLargeReadme) {
+		warnf("", "%s of %s is %d bytes, over -warn-large-readme %s", src.readme, strings.TrimPrefix(src.repo.Path, "/"), len(body), warnLargeReadme.String())
+	}
+	content := string(body)
+
+	if skipBoilerplate {
+		if signature := boilerplateSignature(content); signature != "" {
+			return nil, "", fmt.Errorf("%w: %s contains %q", errBoilerplate, src.readme, signature)
+		}
+	}
+
+	if stripFrontMatter && !keepUpstream {
+		var matter, format string
+		content, matter, format = splitFrontMatter(content)
+		if matter != "" && extractFrontMatter && name == readmeName() {
+			err = os.WriteFile(filepath.Join(src.dir(), "frontmatter."+format), []byte(matter), 0644)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to save the front matter: %v", err)
+			}
+		}
+	}
+
+	if stripLiquidRefs {
+		content = stripLiquid(content, src.readme)
+	}
+	src.codeAssets = codeBlockAssets(content)
+	src.refImages = referenceImages(content)
+
+	// parsing huge or deeply nested HTML is slow enough to stall the run,
+	// so past the limit tags are only scanned for
+	var doc *goquery.Document
+	masked := maskCode(content)
+	switch {
+	case !hasAssetHints(masked) && len(src.codeAssets) == 0:
+		// nothing to find, so an empty document does
+		doc = goquery.NewDocumentFromNode(&html.Node{Type: html.DocumentNode})
+	case htmlParseLimit > 0 && len(content) > int(htmlParseLimit):
+		logf("%s is over -html-parse-limit %s, finding its assets without parsing its HTML\n", src.readme, htmlParseLimit.String())
+		doc = scanDocument(masked)
+	default:
+		doc, err = goquery.NewDocumentFromReader(strings.NewReader(masked))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse the %s file: %v", src.readme, err)
+		}
+	}
+	doc.Find(assetSelector).Each(func(_ int, s *goquery.Selection) {
+		if src, exists := s.Attr("src"); exists {
+			if strings.Contains(src, "?raw=true") {
+				s.SetAttr("src", strings.Replace(src, "?raw=true", "", -1))
+			}
+		}
+		if href, exists := s.Attr("href"); exists {
+			if strings.Contains(href, "?raw=true") {
+				s.SetAttr("href", strings.Replace(href, "?raw=true", "", -1))
+			}
+		}
+	})
+
+	if !keepUpstream {
+		content = processReadme(content, *src)
+	}
+
+	if name == "" {
+		return doc, content, nil
+	}
+
+	// write readme to file
+	f, err := os.Create(filepath.Join(src.dir(), name))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create %s file: %v", name, err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to write %s file: %v", name, err)
+	}
+
+	return doc, content, nil
+}
+
+// readDocument fetches the raw document of src. A missing document is
+// reported as errNotFound.
+func readDocument(ctx context.Context, src source) ([]byte, error) {
+	resp, err := get(ctx, src.rawURL(src.readme))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errNotFound, src.readme)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve the %s file, status code: %d", src.readme, resp.StatusCode)
+	}
+
+	body, err := readLimited(resp.Body, resp.ContentLength, readmeMaxSize, src.readme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	return body, nil
+}
+
+// downloadAssets downloads the local assets referenced by the README and
+// returns the paths they were saved under, relative to the output directory.
+// When changed is not nil, assets already on disk are only downloaded again
+// if their repository path is in it.
+func downloadAssets(ctx context.Context, readme *goquery.Document, src source, changed map[string]bool) ([]string, error) {
+	assets := []string{}
+
+	// paths quoted in inline code only count when asked for, and nothing
+	// else inside code is looked at
+	code := readme.Find("pre, code")
+	if includeCodeRefs {
+		code.Each(func(_ int, s *goquery.Selection) {
+			if isCodeAsset(s.Text()) {
+				assets = append(assets, strings.TrimSpace(s.Text()))
+			}
+		})
+	}
+	code.Remove()
+
+	// a <base href> changes what relative URLs of the HTML point at
+	base, _ := readme.Find("base[href]").First().Attr("href")
+
+	// find all image, link and script tags that are not local
+	// replace all ?raw=true with empty string in readme
+	readme.Find(assetSelector).Each(func(_ int, s *goquery.Selection) {
+		if src, exists := s.Attr("src"); exists {
+			if src = resolveBase(base, src); !strings.HasPrefix(src, "http") {
+				assets = append(assets, src)
+			}
+		}
+		if href, exists := s.Attr("href"); exists {
+			if href = resolveBase(base, href); !strings.HasPrefix(href, "http") {
+				assets = append(assets, href)
+			}
+		}
+	})
+
+	// values of config examples in code blocks, which maskCode blanked out
+	for _, asset := range src.codeAssets {
+		logf("found %s in a code block\n", asset)
+		assets = append(assets, asset)
+	}
+
+	// string literals in inline scripts that look like asset paths
+	if scanScripts {
+		readme.Find("script:not([src])").Each(func(_ int, s *goquery.Selection) {
+			assets = append(assets, scriptAssets(s.Text())...)
+		})
+	}
+
+	// pick up url(...) references from inline styles and <style> blocks
+	readme.Find("[style]").Each(func(_ int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		assets = append(assets, cssURLs(style)...)
+	})
+	readme.Find("style").Each(func(_ int, s *goquery.Selection) {
+		assets = append(assets, cssURLs(s.Text())...)
+	})
+
+	// find markdown image links in (), several of which can share a line as
+	// in the cells of a table row
+	regex := regexp.MustCompile(`(\[[^\]]*\]\()([^()\s]+\.(png|jpg|gif|svg))\)`)
+
+	content, err := readme.Html()
+	if err != nil {
+		return nil, err
+	}
+	content = strings.Replace(content, "?raw=true)", ")", -1)
+	matches := regex.FindAllStringSubmatch(content, -1)
+	for _, match := range matches {
+		if len(match) < 3 || match[2] == "" || strings.HasPrefix(match[2], "http") {
+			continue
+		}
+		assets = append(assets, match[2])
+	}
+	assets = append(assets, src.refImages...)
+
+	if len(assets) == 0 {
+		return nil, nil
+	}
+
+	// create a directory to store the downloaded files
+	dir := src.dir()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// download each asset
+	saved := []string{}
+	seen := map[string]bool{}
+	lifted := map[string]string{}
+	type claim struct{ asset, file string }
+	claimed := map[string]claim{}
+	// references to a file another reference already downloads
+	aliases := map[string]string{}
+	queue := []string{}
+	skip := func(asset string, err error) {
+		warnf("", "%v", err)
+		src.report.missed(asset, "skipped", err)
+	}
+	for _, asset := range assets {
+		if seen[asset] {
+			continue
+		}
+		seen[asset] = true
+		if climbs(src.repoPath(assetFile(asset))) {
+			skip(asset, fmt.Errorf("skipped %s, it points outside the repository", asset))
+			continue
+		}
+		if src.attributes.exportIgnored(src.repoPath(assetFile(asset))) {
+			skip(asset, fmt.Errorf("skipped %s, it is marked export-ignore", asset))
+			continue
+		}
+		if !src.config.wantsAsset(src.repoPath(assetFile(asset))) {
+			skip(asset, fmt.Errorf("skipped %s, %s leaves it out", asset, repoConfigFile))
+			continue
+		}
+		if changed != nil && !changed[src.repoPath(assetFile(asset))] {
+			if _, err := os.Stat(filepath.Join(dir, src.localPath(asset))); err == nil {
+				logf("skipped %s, unchanged since %s\n", asset, sinceCommit)
+				saved = append(saved, src.localPath(asset))
+				src.report.saved(dir, src.localPath(asset), "unchanged")
+				src.references.add(asset, assetURLs(src, asset)[0], src.localPath(asset))
+				continue
+			}
+		}
+		// several references can end up at one local path; only the first
+		// is written, so no two downloads ever share a file
+		local := path.Clean(filepath.ToSlash(src.localPath(asset)))
+		if owner, ok := claimed[local]; ok {
+			if owner.file != src.repoPath(assetFile(asset)) {
+				skip(asset, fmt.Errorf("skipped %s, it would overwrite %s from %s", asset, local, owner.asset))
+			} else {
+				aliases[asset] = owner.asset
+			}
+			continue
+		}
+		claimed[local] = claim{asset, src.repoPath(assetFile(asset))}
+
+		if limitAssets > 0 && len(queue) >= limitAssets {
+			skip(asset, fmt.Errorf("skipped %s due to -limit-assets", asset))
+			continue
+		}
+		queue = append(queue, asset)
+	}
+
+	// download up to -concurrency assets at once, and -max-in-flight
+	// across the run; with -fail-fast, the first failure cancels the rest
+	var (
+		wg      sync.WaitGroup
+		stop    sync.Once
+		stopped = -1
+		slots   = make(chan struct{}, concurrency.workers())
+		locals  = make([]string, len(queue))
+		results = make([]error, len(queue))
+	)
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i, asset := range queue {
+		wg.Add(1)
+		go func(i int, asset string) {
+			defer wg.Done()
+			if downloadLimit != nil {
+				downloadLimit.acquire()
+				defer downloadLimit.release()
+			} else {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			if inFlight != nil {
+				inFlight <- struct{}{}
+				defer func() { <-inFlight }()
+			}
+
+			locals[i], results[i] = downloadAsset(downloadCtx, dir, src, asset)
+			if failFast && results[i] != nil && !errors.Is(results[i], errAssetSkipped) {
+				stop.Do(func() {
+					stopped = i
+					cancel()
+				})
+			}
+		}(i, asset)
+	}
+	wg.Wait()
+
+	for i, asset := range queue {
+		local, err := locals[i], results[i]
+		if err == nil {
+			emit(event{Type: "asset_downloaded", Repo: strings.Trim(src.repo.Path, "/"), Asset: asset, Path: filepath.ToSlash(local)})
+			saved = append(saved, local)
+			src.report.saved(dir, local, "downloaded")
+			if ref, moved := movedRef(asset); moved {
+				lifted[ref] = filepath.ToSlash(local)
+			}
+			continue
+		}
+		if errors.Is(err, errAssetSkipped) {
+			skip(asset, err)
+			continue
+		}
+		// the other failures are downloads the first one cancelled
+		if stopped >= 0 && i != stopped {
+			continue
+		}
+		emit(event{Type: "asset_failed", Repo: strings.Trim(src.repo.Path, "/"), Asset: asset, Error: err.Error()})
+		src.report.missed(asset, "failed", err)
+		if failFast {
+			return saved, fmt.Errorf("stopping at first failure: %v", err)
+		}
+		fail(err)
+	}
+
+	for asset, owner := range aliases {
+		src.references.alias(asset, owner)
+	}
+	return saved, liftRefs(filepath.Join(dir, src.documentName()), lifted)
+}
+
+// liftRefs points the saved document file at the files moved under rootDir
+// or a -ref-in-path directory, given as the references the document holds
+// mapped to their local paths.
+func liftRefs(file string, lifted map[string]string) error {
+	if len(lifted) == 0 || keepUpstream {
+		return nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	rewritten := string(content)
+	for old, local := range lifted {
+		// the README is read as a URL, which would unescape the ref of
+		// refDir
+		rewritten = rewriteRef(rewritten, old, strings.ReplaceAll(local, "%", "%25"))
+	}
+	return os.WriteFile(file, []byte(rewritten), 0644)
+}
+
+// errNotFound marks documents the repository does not have.
+var errNotFound = errors.New("not found")
+
+// errBoilerplate marks READMEs -skip-boilerplate recognizes as generated.
+var errBoilerplate = errors.New("boilerplate README")
+
+// defaultBoilerplate holds the openings of READMEs left as generated by
+// common project templates.
+var defaultBoilerplate = []string{
+	"This project was bootstrapped with [Create React App]",
+	"This project was generated with [Angular CLI]",
+	"bootstrapped with [`create-next-app`]",
+	"This template should help get you started developing with Vue 3 in Vite",
+	"This template provides a minimal setup to get React working in Vite",
+}
+
+// boilerplateSignature returns the -boilerplate-signature, or built-in
+// signature, content contains, or "" for none. Case is ignored.
+func boilerplateSignature(content string) string {
+	signatures := []string(boilerplateSigs)
+	if len(signatures) == 0 {
+		signatures = defaultBoilerplate
+	}
+	lower := strings.ToLower(content)
+	for _, signature := range signatures {
+		if strings.Contains(lower, strings.ToLower(signature)) {
+			return signature
+		}
+	}
+	return ""
+}
+
+// errAssetSkipped marks assets that were deliberately not downloaded. They
+// are reported but do not count as failures.
+var errAssetSkipped = errors.New("skipped")
+
+// listFlag collects the values of a repeatable flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// matches reports whether value matches one of the glob patterns in the
+// list. An empty list matches everything.
+func (l listFlag) matches(value string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, pattern := range l {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// genericTypes are content types servers give when they do not know better,
+// as raw.githubusercontent.com does for files without an extension.
+var genericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"text/plain":               true,
+}
+
+// responseType returns the media type of resp without its parameters. A
+// missing or generic type is replaced by one sniffed from the first bytes
+// of the body, which stays readable from the start.
+func responseType(resp *http.Response) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && !genericTypes[mediaType] {
+		return mediaType
+	}
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	body := bufio.NewReaderSize(resp.Body, 512)
+	head, _ := body.Peek(512)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}
+	if len(head) == 0 {
+		return mediaType
+	}
+	sniffed, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return mediaType
+	}
+	return sniffed
+}
+
+// renameFlag collects -rename mappings from upstream asset paths to the
+// local paths they are stored under.
+type renameFlag map[string]string
+
+func (r renameFlag) String() string {
+	pairs := []string{}
+	for old, renamed := range r {
+		pairs = append(pairs, old+"=>"+renamed)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (r renameFlag) Set(value string) error {
+	old, renamed, ok := strings.Cut(value, "=>")
+	old, renamed = strings.TrimSpace(old), strings.TrimSpace(renamed)
+	if !ok || old == "" || renamed == "" {
+		return fmt.Errorf("expected 'old=>new', got %q", value)
+	}
+	if slashed := filepath.ToSlash(renamed); filepath.IsAbs(renamed) || filepath.VolumeName(renamed) != "" || path.IsAbs(slashed) || climbs(slashed) {
+		return fmt.Errorf("%q leaves the output directory", renamed)
+	}
+	r[old] = renamed
+	return nil
+}
+
+// rewriteRef replaces references to old with renamed wherever old appears as
+// a complete link target or attribute value.
+func rewriteRef(content, old, renamed string) string {
+	for _, wrap := range [][2]string{{"(", ")"}, {"(", " "}, {`"`, `"`}, {"'", "'"}, {"]: ", "\n"}} {
+		content = strings.ReplaceAll(content, wrap[0]+old+wrap[1], wrap[0]+renamed+wrap[1])
+	}
+	return content
+}
+
+var refRawRegex = regexp.MustCompile(`(?m)^( {0,3}\[[^\]]+\]:[ \t]*<?[^\s>?]+)\?raw=true`)
+
+// referenceImages returns the local targets of reference-style link
+// definitions that are used by images, as in ![logo][ref] with a
+// "[ref]: images/logo.png" line elsewhere in the README.
+func referenceImages(content string) []string {
+	source := []byte(content)
+	pc := parser.NewContext()
+	doc := mdRenderer.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+	definitions := map[string]bool{}
+	for _, ref := range pc.References() {
+		definitions[string(ref.Destination())] = true
+	}
+
+	targets := []string{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		image, ok := n.(*ast.Image)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		// reference images carry the destination of their definition,
+		// inline ones are handled elsewhere
+		target := string(image.Destination)
+		if !definitions[target] || strings.HasPrefix(target, "http") {
+			return ast.WalkContinue, nil
+		}
+		targets = append(targets, strings.Replace(target, "?raw=true", "", -1))
+		return ast.WalkContinue, nil
+	})
+	return targets
+}
+
+var scriptLiteralRegex = regexp.MustCompile("[\"'`]([^\"'`\\s]+)[\"'`]")
+
+// scriptAssets returns the string literals of a script that are nothing but
+// a local asset path, such as "img/logo.png".
+func scriptAssets(script string) []string {
+	paths := []string{}
+	for _, match := range scriptLiteralRegex.FindAllStringSubmatch(script, -1) {
+		if isCodeAsset(match[1]) {
+			paths = append(paths, match[1])
+		}
+	}
+	return paths
+}
+
+var cssURLRegex = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]+))\s*\)`)
+
+// cssURLs returns the local url(...) references found in a chunk of CSS,
+// accepting the double-quoted, single-quoted and bare forms.
+func cssURLs(css string) []string {
+	urls := []string{}
+	for _, match := range cssURLRegex.FindAllStringSubmatch(css, -1) {
+		ref := match[1] + match[2] + match[3]
+		if ref == "" || strings.HasPrefix(ref, "http") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "data:") {
+			continue
+		}
+		urls = append(urls, ref)
+	}
+	return urls
+}
+
+// changelogNames are the file names tried, in order, by -changelog.
+var changelogNames = []string{"CHANGELOG.md", "HISTORY.md", "CHANGES.md", "changelog.md", "History.md"}
+
+// fetchChangelog saves the first changelog found next to the README and
+// downloads its assets, returning their local paths. A repository without
+// a changelog is only reported.
+func fetchChangelog(ctx context.Context, src source) ([]string, error) {
+	names := changelogNames
+	// with -probe-candidates, the names are looked for several at a time
+	// first, and only the one found is read
+	if probeWidth > 1 {
+		found, err := probeCandidates(ctx, src, changelogNames)
+		if err != nil {
+			return nil, err
+		}
+		names = changelogNames[found:]
+		if len(names) > 1 {
+			names = names[:1]
+		}
+	}
+	for _, name := range names {
+		doc := src
+		doc.readme = path.Join(path.Dir(src.readme), name)
+		parsed, _, err := getDocument(ctx, &doc, name)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		logf("fetched %s\n", name)
+		return downloadAssets(ctx, parsed, doc, nil)
+	}
+	logf("no changelog found in %s\n", src.repo.String())
+	return nil, nil
+}
+
+// probeCandidates returns the index of the first of names, in order, that
+// exists next to the README of src, or len(names) when none does. Up to
+// -probe-candidates names are asked for at once, so a file far down the
+// list costs one round trip instead of one per name before it. Once a name
+// is known to exist, the requests for the names after it are cancelled.
+//
+// A host that redirects every missing file to one page, like a wiki's front
+// page, makes each name look found. When a name was redirected and the next
+// one leads to the same page, neither is taken, nor any later name leading
+// there, and the names served as themselves are still looked for.
+func probeCandidates(ctx context.Context, src source, names []string) (int, error) {
+	candidate := func(i int) string {
+		return src.rawURL(path.Join(path.Dir(src.readme), names[i]))
+	}
+	type result struct {
+		final string
+		err   error
+	}
+	// catchAll is the page every missing name was found to lead to
+	var catchAll string
+	for start := 0; start < len(names); start += probeWidth {
+		end := start + probeWidth
+		if end > len(names) {
+			end = len(names)
+		}
+		probeCtx, cancel := context.WithCancel(ctx)
+		results := make([]chan result, end-start)
+		for i := start; i < end; i++ {
+			results[i-start] = make(chan result, 1)
+			go func(i int) {
+				final, err := probeFile(probeCtx, candidate(i))
+				results[i-start] <- result{final, err}
+			}(i)
+		}
+		// a later name answering first still waits for the ones before it
+		for i := start; i < end; i++ {
+			r := <-results[i-start]
+			if errors.Is(r.err, errNotFound) || r.err == nil && r.final == catchAll {
+				continue
+			}
+			if r.err != nil || r.final == candidate(i) || i == len(names)-1 {
+				cancel()
+				return i, r.err
+			}
+			if next, err := probeFile(ctx, candidate(i+1)); err == nil && next == r.final {
+				logf("%s and %s both lead to %s, which is not taken for either\n", names[i], names[i+1], r.final)
+				catchAll = r.final
+				continue
+			}
+			cancel()
+			return i, nil
+		}
+		cancel()
+	}
+	return len(names), nil
+}
+
+// probeFile checks that rawURL serves a file without reading it, and
+// returns the URL that served it after any redirects. Hosts that refuse
+// HEAD requests are asked with GET instead.
+func probeFile(ctx context.Context, rawURL string) (string, error) {
+	resp, err := head(ctx, rawURL)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = get(ctx, rawURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: %s", errNotFound, rawURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to look for %s, status code: %d", rawURL, resp.StatusCode)
+	}
+	return resp.Request.URL.String(), nil
+}
+
+// readmeName is the file name the README is saved under.
+func readmeName() string {
+	if githubRendered {
+		return "README.html"
+	}
+	if readmeOut != "" {
+		return readmeOut
+	}
+	return "README.md"
+}
+
+// verifyLocal checks that every local file the README saved in dir as name
+// references exists, returning the references that dangle.
+func verifyLocal(dir, name string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	dangling := []string{}
+	for ref := range referencedPaths(string(content)) {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(ref))); err != nil {
+			dangling = append(dangling, ref)
+		}
+	}
+	sort.Strings(dangling)
+	return dangling, nil
+}
+
+// rebaseReadme rewrites the local references of the saved README so they
+// are relative to base, a directory given relative to dir, instead of to
+// the README itself.
+func rebaseReadme(dir, base string) error {
+	file := filepath.Join(dir, readmeName())
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	base = filepath.Join(root, base)
+
+	rebased := mapProse(string(content), func(line string) string {
+		return mapLocalRefs(line, func(ref string) string {
+			u, err := url.Parse(ref)
+			if err != nil || u.Path == "" {
+				return ref
+			}
+			rel, err := filepath.Rel(base, filepath.Join(root, filepath.FromSlash(u.Path)))
+			if err != nil {
+				return ref
+			}
+			u.Path = filepath.ToSlash(rel)
+			return u.String()
+		})
+	})
+	return os.WriteFile(file, []byte(rebased), 0644)
+}
+
+// compactAssets removes the saved assets that the final README does not
+// reference, which happens when asset detection over-matches, and returns
+// the ones that are kept.
+func compactAssets(dir string, saved []string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return nil, err
+	}
+	refs := referencedPaths(string(content))
+
+	kept := []string{}
+	for _, local := range saved {
+		if refs[path.Clean(filepath.ToSlash(local))] {
+			kept = append(kept, local)
+			continue
+		}
+		logf("removing %s, the README does not reference it\n", local)
+		err = os.Remove(filepath.Join(dir, local))
 		if err != nil {
-			fmt.Printf("failed to write content to file %s: %v\n", filePath, err)
+			return nil, err
+		}
+		os.Remove(filepath.Join(dir, local+sidecarSuffix))
+		// drop directories the removal left empty, up to the output directory
+		for parent := filepath.Dir(local); parent != "."; parent = filepath.Dir(parent) {
+			if os.Remove(filepath.Join(dir, parent)) != nil {
+				break
+			}
+		}
+	}
+	return kept, nil
+}
+
+// unreferencedAssets returns the assets saved in dir that are missing from
+// tree, the files of the repository at the fetched ref. They were kept from
+// an earlier run or served from elsewhere, and go stale once expanded.
+func unreferencedAssets(src source, dir string, saved []string, tree map[string]bool) []string {
+	unreferenced := []string{}
+	for _, local := range saved {
+		if _, err := os.Stat(filepath.Join(dir, local)); err != nil {
+			// removed since, by -compact
 			continue
 		}
+		if !tree[src.assetRepoPath(filepath.ToSlash(local))] {
+			unreferenced = append(unreferenced, filepath.ToSlash(local))
+		}
+	}
+	return unreferenced
+}
+
+// createPart creates the file a download of file is written to, next to
+// it, so a failed or cut short download never leaves a truncated file under
+// the final name.
+func createPart(file string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.part")
+}
+
+// keepPart moves the completed download part to file.
+func keepPart(part *os.File, file string) error {
+	err := part.Chmod(0644)
+	if err == nil {
+		err = part.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(part.Name(), file)
+}
+
+// discardPart removes part unless keepPart moved it into place.
+func discardPart(part *os.File) {
+	part.Close()
+	os.Remove(part.Name())
+}
+
+// rootDir holds, inside the output directory, the assets a README in a
+// subdirectory reaches above its own directory, laid out as in the
+// repository.
+const rootDir = "_root"
+
+// climbs reports whether the local reference file leaves the directory it
+// is relative to.
+func climbs(file string) bool {
+	file = path.Clean(file)
+	return file == ".." || strings.HasPrefix(file, "../")
+}
+
+// localPath returns where asset is stored, relative to the output directory.
+// Assets above the README's directory go under rootDir.
+func (s source) localPath(asset string) string {
+	local := assetFile(asset)
+	if renamed, ok := renames[asset]; ok {
+		local = renamed
+	} else if climbs(local) {
+		local = path.Join(rootDir, s.repoPath(local))
+	}
+	if refInPath {
+		local = path.Join(refDir(s.ref), local)
+	}
+	return local
+}
+
+// assetRepoPath is the reverse of localPath: the path relative to the
+// repository root of the asset saved at local.
+func (s source) assetRepoPath(local string) string {
+	if refInPath {
+		local = strings.TrimPrefix(local, refDir(s.ref)+"/")
+	}
+	for asset, renamed := range renames {
+		if renamed == local {
+			return s.repoPath(assetFile(asset))
+		}
+	}
+	if strings.HasPrefix(local, rootDir+"/") {
+		return strings.TrimPrefix(local, rootDir+"/")
+	}
+	return s.repoPath(local)
+}
+
+// refDir is the directory -ref-in-path keeps the assets of ref in. Refs are
+// path escaped, so slashes of branch names stay one directory and
+// feature/a and feature-a keep theirs apart.
+func refDir(ref string) string {
+	return "@" + url.PathEscape(ref)
+}
+
+// movedRef reports whether the README has to be pointed at the local copy
+// of asset, and the reference to asset the README holds at that point.
+func movedRef(asset string) (string, bool) {
+	if renamed, ok := renames[asset]; ok {
+		return renamed, refInPath
 	}
+	return asset, refInPath || climbs(assetFile(asset))
+}
+
+// resolveBase resolves ref against the href of a <base> element. A base
+// relative to the README keeps ref local; an absolute base makes it a URL.
+func resolveBase(base, ref string) string {
+	if base == "" {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil || r.IsAbs() || strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+	if b.IsAbs() {
+		return b.ResolveReference(r).String()
+	}
+	// resolve against a stand-in root so relative bases stay relative
+	root, _ := url.Parse("http://readme/")
+	resolved := root.ResolveReference(b).ResolveReference(r)
+	return strings.TrimPrefix(resolved.String(), "http://readme/")
+}
 
+// assetFile returns the file an asset reference points at, without any
+// query or fragment and with percent-escapes decoded.
+func assetFile(asset string) string {
+	u, err := url.Parse(asset)
+	if err != nil || u.Path == "" {
+		return asset
+	}
+	return u.Path
+}
+
+// checkLimits skips asset, to be saved at local, when it is nested deeper
+// than -max-dir-depth or its repository already reached -max-repo-bytes.
+func checkLimits(src source, asset, local string) error {
+	if depth := strings.Count(path.Clean(filepath.ToSlash(local)), "/"); maxDirDepth > 0 && depth > maxDirDepth {
+		return fmt.Errorf("%w %s: %d directories deep, over -max-dir-depth", errAssetSkipped, asset, depth)
+	}
+	if src.downloaded != nil && atomic.LoadInt64(src.downloaded) >= int64(maxRepoBytes) {
+		return fmt.Errorf("%w %s: the repository is at -max-repo-bytes %s", errAssetSkipped, asset, maxRepoBytes.String())
+	}
 	return nil
 }
+
+// downloadAsset fetches a single asset and writes it under dir, keeping its
+// path relative to the README. It returns the local path used.
+func downloadAsset(ctx context.Context, dir string, src source, asset string) (string, error) {
+	local := src.localPath(asset)
+	if err := checkLimits(src, asset, local); err != nil {
+		return "", err
+	}
+
+	// try every place the asset may be at in turn, the last one answering
+	// for the asset
+	var (
+		assetURL string
+		resp     *http.Response
+		err      error
+	)
+	candidates := assetURLs(src, asset)
+	// another repository of the batch may have saved the asset already, in
+	// which case its file is reused without downloading it again
+	if dedupeAcrossRepos {
+		if saved, ok := findSaved(ctx, candidates[0]); ok {
+			os.MkdirAll(filepath.Join(dir, filepath.Dir(local)), 0755)
+			err = reuseSaved(saved, filepath.Join(dir, local), candidates[0])
+			if err != nil {
+				return "", err
+			}
+			logf("reused %s for %s\n", saved.file, local)
+			src.references.add(asset, candidates[0], local)
+			return local, nil
+		}
+	}
+	for i, candidate := range candidates {
+		assetURL = candidate
+		resp, err = get(ctx, candidate)
+		if i == len(candidates)-1 || err == nil && resp.StatusCode == http.StatusOK {
+			break
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		logf("%s is not at %s (%v), trying %s\n", asset, candidate, err, candidates[i+1])
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", assetURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, assetURL)
+	}
+
+	contentType := responseType(resp)
+	if !allowedTypes.matches(contentType) {
+		return "", fmt.Errorf("%w %s: content type %s is not allowed", errAssetSkipped, asset, contentType)
+	}
+
+	// construct the file path to save the downloaded file
+	os.MkdirAll(filepath.Join(dir, filepath.Dir(local)), 0755)
+	filePath := filepath.Join(dir, filepath.Dir(local), filepath.Base(local))
+	etag := etagOf(resp)
+
+	// write the downloaded content next to the file, which only takes its
+	// name once all of it arrived
+	file, err := createPart(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file %s: %v", filePath, err)
+	}
+	defer discardPart(file)
+	complete := false
+	if chunkable(resp) {
+		resp.Body.Close()
+		err = downloadChunks(ctx, assetURL, file, resp.ContentLength, etag)
+		complete = err == nil
+		if complete && src.downloaded != nil {
+			atomic.AddInt64(src.downloaded, resp.ContentLength)
+		}
+		if err != nil && !errors.Is(err, errRangesIgnored) && !errors.Is(err, errChangedMidway) {
+			return "", fmt.Errorf("failed to download %s in chunks: %v", assetURL, err)
+		}
+		if !complete {
+			// fall back to a single stream, discarding the ranges that
+			// arrived, which may be of another version of the file
+			logf("%v, downloading %s in one piece\n", err, asset)
+			file.Truncate(0)
+			resp, err = get(ctx, assetURL)
+			if err != nil {
+				return "", fmt.Errorf("failed to download %s: %v", assetURL, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, assetURL)
+			}
+			etag = etagOf(resp)
+		}
+	}
+	if !complete {
+		written, err := io.Copy(file, resp.Body)
+		if src.downloaded != nil {
+			atomic.AddInt64(src.downloaded, written)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to write content to file %s: %v", filePath, err)
+		}
+		// without a Content-Length, as with chunked responses, a clean end
+		// of the stream is all there is to go by
+		if resp.ContentLength >= 0 && written != resp.ContentLength {
+			return "", fmt.Errorf("got %d of the %d bytes of %s", written, resp.ContentLength, assetURL)
+		}
+	}
+
+	// images far heavier than their dimensions call for are dropped
+	if maxBytesPerPixel > 0 {
+		if ratio, err := bytesPerPixel(file.Name()); err == nil && ratio > maxBytesPerPixel {
+			return "", fmt.Errorf("%w %s: %.1f bytes per pixel is over -max-bytes-per-pixel", errAssetSkipped, asset, ratio)
+		}
+	}
+
+	// another repository of the batch may already have saved the same
+	// bytes under another URL, in which case its file is reused rather
+	// than kept twice
+	var sum string
+	saved, reuse := savedAsset{filePath, contentType, etag}, false
+	if dedupeAcrossRepos {
+		sum, err = fileSum(file.Name())
+		if err != nil {
+			return "", fmt.Errorf("failed to hash %s: %v", filePath, err)
+		}
+		saved, reuse = downloaded.lookup(downloaded.bySum, sum)
+	}
+	if reuse {
+		err = linkOrCopy(saved.file, filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to reuse %s for %s: %v", saved.file, filePath, err)
+		}
+		logf("reused %s for %s\n", saved.file, local)
+	} else {
+		err = keepPart(file, filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to save %s: %v", filePath, err)
+		}
+		saved = savedAsset{filePath, contentType, etag}
+	}
+	if dedupeAcrossRepos {
+		downloaded.add(saved, sum, etagKey(etag, resp.ContentLength), candidates[0], resp.Request.URL.String())
+	}
+	if withSidecar {
+		err = writeSidecar(filePath, assetURL, contentType, etag)
+		if err != nil {
+			return "", fmt.Errorf("failed to write the sidecar of %s: %v", local, err)
+		}
+	}
+	src.references.add(asset, assetURL, local)
+
+	return local, nil
+}
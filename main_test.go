@@ -0,0 +1,1198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadAssetCutShort(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// promise more than is sent, as a dropped connection would
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("0123456789"))
+	}))
+	dir := t.TempDir()
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD"}
+	if _, err := downloadAsset(context.Background(), dir, src, "logo.png"); err == nil {
+		t.Fatal("expected a cut short download to fail")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("left %s behind", entry.Name())
+	}
+}
+
+func TestDownloadAsset(t *testing.T) {
+	serveFiles(t, map[string]string{"github.com/o/r/raw/HEAD/logo.png": "image"})
+	dir := t.TempDir()
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD"}
+	local, err := downloadAsset(context.Background(), dir, src, "logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len("image")) || info.Mode().Perm() != 0644 {
+		t.Errorf("saved %d bytes with mode %v, want 5 bytes with mode 0644", info.Size(), info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("left %d files in the directory, want only %s", len(entries), local)
+	}
+}
+
+func TestReferenceImages(t *testing.T) {
+	content := "![logo][ref] and ![Shortcut]\n\n" +
+		"```md\n![example][code]\n\n[code]: code.png\n```\n\n" +
+		"[ref]: images/logo.png?raw=true\n" +
+		"[shortcut]: <images/short cut.svg>\n" +
+		"[unused]: images/unused.png\n" +
+		"[remote]: https://example.com/remote.png\n"
+	got := referenceImages(content)
+	want := []string{"images/logo.png", "images/short cut.svg"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("referenceImages() = %q, want %q", got, want)
+	}
+}
+
+func TestFetchReferenceImage(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":       "# R\n\n![logo][ref]\n\n[ref]: images/logo.png?raw=true\n",
+		"github.com/o/r/raw/HEAD/images/logo.png": "image",
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join("r", "images", "logo.png")); err != nil {
+		t.Errorf("the image of the reference was not downloaded: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "[ref]: images/logo.png\n") {
+		t.Errorf("the definition was not pointed at the local file:\n%s", content)
+	}
+}
+
+func TestRefInPath(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/feature/a/README.md":    "![logo](img/logo.png)\n",
+		"github.com/o/r/raw/feature/a/img/logo.png": "slash",
+		"github.com/o/r/raw/feature-a/README.md":    "![logo](img/logo.png)\n",
+		"github.com/o/r/raw/feature-a/img/logo.png": "dash",
+	})
+	inTempDir(t)
+	refInPath = true
+	t.Cleanup(func() { refInPath = false })
+
+	for _, test := range []struct{ ref, link string }{
+		{"feature/a", "@feature%252Fa/img/logo.png"},
+		{"feature-a", "@feature-a/img/logo.png"},
+	} {
+		src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: test.ref, readme: "README.md"}
+		if err := fetchRepo(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(filepath.Join("r", "README.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "![logo](" + test.link + ")\n"; string(content) != want {
+			t.Errorf("saved the README of %s as %q, want %q", test.ref, content, want)
+		}
+	}
+	for dir, want := range map[string]string{"@feature%2Fa": "slash", "@feature-a": "dash"} {
+		logo, err := os.ReadFile(filepath.Join("r", dir, "img", "logo.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(logo) != want {
+			t.Errorf("%s holds the logo of the other ref", dir)
+		}
+	}
+}
+
+func TestCheckKeepUpstream(t *testing.T) {
+	keepUpstream = true
+	t.Cleanup(func() { keepUpstream, normalizeDiff = false, false })
+	if err := checkKeepUpstream(); err != nil {
+		t.Errorf("-keep-upstream-links alone was rejected: %v", err)
+	}
+	normalizeDiff = true
+	if err := checkKeepUpstream(); err == nil {
+		t.Error("-keep-upstream-links was accepted with -normalize-for-diff, which rewrites the README")
+	}
+}
+
+func TestProbeCandidates(t *testing.T) {
+	names := []string{"A.md", "B.md", "C.md", "D.md"}
+	cancelled := make(chan struct{})
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("probed %s with %s", r.URL.Path, r.Method)
+		}
+		switch path.Base(r.URL.Path) {
+		case "B.md", "C.md":
+			w.WriteHeader(http.StatusOK)
+		case "D.md":
+			// still pending when B.md is found
+			<-r.Context().Done()
+			close(cancelled)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	probeWidth = 4
+	t.Cleanup(func() { probeWidth = 1 })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	found, err := probeCandidates(context.Background(), src, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 1 {
+		t.Errorf("found %d, want B.md, the first name that exists", found)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("the probe of D.md was not cancelled")
+	}
+}
+
+func TestProbeFileWithoutHead(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("# Changelog\n"))
+	}))
+	if _, err := probeFile(context.Background(), "https://example.com/CHANGELOG.md"); err != nil {
+		t.Errorf("a host refusing HEAD is not probed with GET: %v", err)
+	}
+}
+
+func TestFetchChangelogRedirected(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every missing file leads to the wiki's front page
+		if r.URL.Path != "/o/r/wiki" {
+			http.Redirect(w, r, "/o/r/wiki", http.StatusFound)
+			return
+		}
+		w.Write([]byte("# Wiki\n"))
+	}))
+	inTempDir(t)
+	probeWidth = 2
+	t.Cleanup(func() { probeWidth = 1 })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := os.MkdirAll(src.dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := fetchChangelog(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(src.dir())
+	if len(saved) > 0 || len(entries) > 0 {
+		t.Errorf("saved %v and %d files from a host redirecting every name to one page", saved, len(entries))
+	}
+}
+
+func TestFetchChangelogPastCatchAll(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/o/r/wiki":
+			w.Write([]byte("# Wiki\n"))
+		case "/o/r/raw/HEAD/CHANGES.md":
+			w.Write([]byte("# Changes\n"))
+		default:
+			http.Redirect(w, r, "/o/r/wiki", http.StatusFound)
+		}
+	}))
+	inTempDir(t)
+	probeWidth = 2
+	t.Cleanup(func() { probeWidth = 1 })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := os.MkdirAll(src.dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fetchChangelog(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(src.dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "CHANGES.md" {
+		t.Fatalf("saved %v, want only CHANGES.md", entries)
+	}
+	content, err := os.ReadFile(filepath.Join(src.dir(), "CHANGES.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# Changes\n" {
+		t.Errorf("CHANGES.md holds %q", content)
+	}
+}
+
+func TestFetchChangelog(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+path.Base(r.URL.Path))
+		mu.Unlock()
+		if path.Base(r.URL.Path) != "HISTORY.md" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("# History\n"))
+	}))
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+
+	for _, tc := range []struct {
+		width int
+		want  string
+	}{
+		// one name after the other, without probing first
+		{1, "GET CHANGELOG.md, GET HISTORY.md"},
+		// only the name the probe found is read, sorted as the probes run
+		// at once
+		{3, "GET HISTORY.md, HEAD CHANGELOG.md, HEAD HISTORY.md"},
+	} {
+		inTempDir(t)
+		if err := os.MkdirAll(src.dir(), 0755); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		probeWidth, requests = tc.width, nil
+		mu.Unlock()
+		if _, err := fetchChangelog(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(filepath.Join(src.dir(), "HISTORY.md"))
+		if err != nil || string(content) != "# History\n" {
+			t.Errorf("with -probe-candidates %d, saved %q, %v", tc.width, content, err)
+		}
+		// the probe of CHANGES.md may or may not have been sent before it
+		// was cancelled
+		got := []string{}
+		mu.Lock()
+		for _, r := range requests {
+			if r != "HEAD CHANGES.md" {
+				got = append(got, r)
+			}
+		}
+		mu.Unlock()
+		sort.Strings(got)
+		if strings.Join(got, ", ") != tc.want {
+			t.Errorf("with -probe-candidates %d, sent %s, want %s", tc.width, strings.Join(got, ", "), tc.want)
+		}
+	}
+	probeWidth = 1
+}
+
+func TestAssetSelector(t *testing.T) {
+	readme := "<img src=\"logo.png\">\n\n<script src=\"widget.js\"></script>\n"
+	files := map[string]string{"logo.png": "image", "widget.js": "script"}
+	t.Cleanup(func() { assetSelector = selectorPresets["default"] })
+	for _, tc := range []struct {
+		selector string
+		script   bool
+	}{
+		{"default", true},
+		{"img[src], link[href]", false},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			src := serveRepo(t, readme, files)
+			var err error
+			assetSelector, err = resolveSelector(tc.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fetchRepo(context.Background(), src); err != nil {
+				t.Fatal(err)
+			}
+			if !exists("r/logo.png") {
+				t.Error("the image was not downloaded")
+			}
+			if exists("r/widget.js") != tc.script {
+				t.Errorf("downloaded the script: %v, want %v", exists("r/widget.js"), tc.script)
+			}
+		})
+	}
+	if _, err := resolveSelector("img[src"); err == nil {
+		t.Error("an invalid selector was accepted")
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/o/r/raw/HEAD/README.md":
+			w.Write([]byte("![a](broken.png) ![b](slow1.png) ![c](slow2.png)\n"))
+		case "/o/r/raw/HEAD/broken.png":
+			http.NotFound(w, r)
+		default:
+			// the slow downloads only end when the failure cancels them
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+				w.Write([]byte("image"))
+			}
+		}
+	}))
+	inTempDir(t)
+	concurrency, failFast = 4, true
+	t.Cleanup(func() { concurrency, failFast = 1, false })
+
+	start := time.Now()
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	err := fetchRepo(context.Background(), src)
+	if err == nil || !strings.Contains(err.Error(), "stopping at first failure") {
+		t.Fatalf("got %v, want the first failure to stop the fetch", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v, the remaining downloads were not cancelled", elapsed)
+	}
+	if exists("r/slow1.png") || exists("r/slow2.png") {
+		t.Error("kept downloads that should have been cancelled")
+	}
+}
+
+func TestFetchStyleBackground(t *testing.T) {
+	readme := "<div style=\"background-image: url('img/bg.png')\">Title</div>\n"
+	src := serveRepo(t, readme, map[string]string{"img/bg.png": "image"})
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/img/bg.png") {
+		t.Error("the background image of the inline style was not downloaded")
+	}
+}
+
+func TestRename(t *testing.T) {
+	src := serveRepo(t, "![logo](img/logo.png)\n", map[string]string{"img/logo.png": "image"})
+	if err := renames.Set("img/logo.png => assets/brand/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(renames, "img/logo.png") })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/assets/brand/logo.png") || exists("r/img/logo.png") {
+		t.Error("the asset was not saved under its new name")
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "![logo](assets/brand/logo.png)\n" {
+		t.Errorf("saved the README as %q, not pointing at the new name", content)
+	}
+
+	for _, bad := range []string{"logo.png", "logo.png=>../logo.png", "logo.png=>/tmp/logo.png"} {
+		if err := (renameFlag{}).Set(bad); err == nil {
+			t.Errorf("-rename %q is accepted", bad)
+		}
+	}
+}
+
+func TestAllowContentType(t *testing.T) {
+	src := serveRepo(t, "<img src=\"logo.png\">\n<link href=\"dist/release.zip\">\n", map[string]string{
+		"logo.png":         pngImage(t, 1, 1),
+		"dist/release.zip": "PK\x03\x04 an archive",
+	})
+	if err := allowedTypes.Set("image/*"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { allowedTypes = listFlag{} })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/logo.png") {
+		t.Error("the image was not downloaded")
+	}
+	if exists("r/dist/release.zip") {
+		t.Error("the application/zip asset was downloaded under an image/* allowlist")
+	}
+}
+
+func TestExpandShorthand(t *testing.T) {
+	for _, tc := range []struct{ link, want string }{
+		{"gh:owner/repo", "https://github.com/owner/repo"},
+		{"@owner/repo", "https://github.com/owner/repo"},
+		{"https://github.com/owner/repo", "https://github.com/owner/repo"},
+		{"gh:owner", "gh:owner"},
+		{"@owner/repo/extra", "@owner/repo/extra"},
+		{"@/repo", "@/repo"},
+	} {
+		if got := expandShorthand(tc.link); got != tc.want {
+			t.Errorf("expandShorthand(%q) = %q, want %q", tc.link, got, tc.want)
+		}
+	}
+}
+
+func TestLimitAssets(t *testing.T) {
+	var downloads int32
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/o/r/raw/HEAD/README.md" {
+			w.Write([]byte("![1](1.png) ![2](2.png) ![3](3.png) ![4](4.png) ![5](5.png)\n"))
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Write([]byte("image"))
+	}))
+	inTempDir(t)
+	limitAssets = 2
+	t.Cleanup(func() { limitAssets = 0 })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&downloads); n != 2 {
+		t.Errorf("downloaded %d assets, want 2", n)
+	}
+	if !exists("r/1.png") || !exists("r/2.png") || exists("r/3.png") {
+		t.Error("did not download the first two assets in order")
+	}
+}
+
+func TestParseRawSource(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/v1.0/docs/README.md":       "![diagram](img/diagram.png)\n",
+		"github.com/o/r/raw/v1.0/docs/img/diagram.png": "image",
+	})
+	inTempDir(t)
+	src, err := parseSource(context.Background(), "https://raw.githubusercontent.com/o/r/v1.0/docs/README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.repo.String() != "https://github.com/o/r" || src.ref != "v1.0" || src.readme != "docs/README.md" {
+		t.Fatalf("parsed %s at %s, %s", src.repo, src.ref, src.readme)
+	}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/img/diagram.png") {
+		t.Error("the image was not resolved relative to the README")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	// the commented out image is found, but gone from the saved README
+	readme := "![logo](logo.png)\n\n<!-- ![old](old.png) -->\n"
+	src := serveRepo(t, readme, map[string]string{"logo.png": "image", "old.png": "image"})
+	compact, transformList = true, "strip-comments"
+	pipeline = []string{"strip-comments"}
+	t.Cleanup(func() { compact, transformList, pipeline = false, "", nil })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/logo.png") {
+		t.Error("the referenced asset was pruned")
+	}
+	if exists("r/old.png") {
+		t.Error("the asset the saved README does not reference was kept")
+	}
+}
+
+func TestIncludeCodeRefs(t *testing.T) {
+	readme := "The logo lives at `docs/logo.png`.\n\n`![shot](shot.png)` shows how to embed it.\n"
+	files := map[string]string{"docs/logo.png": "image", "shot.png": "image"}
+	t.Cleanup(func() { includeCodeRefs = false })
+	for _, include := range []bool{false, true} {
+		src := serveRepo(t, readme, files)
+		includeCodeRefs = include
+		if err := fetchRepo(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		if exists("r/docs/logo.png") != include {
+			t.Errorf("with -include-code-refs %v, downloaded the quoted path: %v", include, !include)
+		}
+		if exists("r/shot.png") {
+			t.Errorf("with -include-code-refs %v, downloaded the image of the code example", include)
+		}
+	}
+}
+
+func TestSlashedRef(t *testing.T) {
+	provider = "github"
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "feature/foo", readme: "README.md"}
+	if err := checkRef(src.ref); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := src.rawURL("docs/my logo.png"), "https://github.com/o/r/raw/feature/foo/docs/my%20logo.png"; got != want {
+		t.Errorf("rawURL = %q, want %q", got, want)
+	}
+	if dir := refDir(src.ref); strings.Contains(dir, "/") || dir != "@feature%2Ffoo" {
+		t.Errorf("refDir = %q, want the one directory @feature%%2Ffoo", dir)
+	}
+	if dir := src.dir(); dir != "r" {
+		t.Errorf("dir = %q, want r", dir)
+	}
+
+	for _, bad := range []string{"", "-x", "feature/", "a..b", "a//b", "a b", "a:b", "x.lock", "a@{1}", "a\x7fb"} {
+		if checkRef(bad) == nil {
+			t.Errorf("checkRef(%q) accepted it", bad)
+		}
+	}
+}
+
+func TestReadmeMaxSize(t *testing.T) {
+	src := serveRepo(t, strings.Repeat("0123456789", 200), nil)
+	readmeMaxSize = 1 << 10
+	t.Cleanup(func() { readmeMaxSize = 0 })
+	err := fetchRepo(context.Background(), src)
+	if err == nil || !strings.Contains(err.Error(), "README.md is larger than the limit of 1KB") {
+		t.Errorf("got %v, want the README to be refused as too large", err)
+	}
+	if exists("r/README.md") {
+		t.Error("saved the README over the limit")
+	}
+}
+
+func TestFetchChangelogAssets(t *testing.T) {
+	src := serveRepo(t, "# R\n", map[string]string{
+		"CHANGELOG.md":    "## 1.1\n\n![new dialog](docs/dialog.png)\n",
+		"docs/dialog.png": "image",
+	})
+	changelog = true
+	t.Cleanup(func() { changelog = false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/CHANGELOG.md") {
+		t.Error("the changelog was not saved")
+	}
+	if !exists("r/docs/dialog.png") {
+		t.Error("the image of the changelog was not downloaded")
+	}
+}
+
+func TestScanScripts(t *testing.T) {
+	readme := "<script>\nconst sprite = \"img/sprite.png\";\ndocument.title = \"img/ is not a path\";\n</script>\n"
+	files := map[string]string{"img/sprite.png": "image"}
+	t.Cleanup(func() { scanScripts = false })
+	for _, scan := range []bool{false, true} {
+		src := serveRepo(t, readme, files)
+		scanScripts = scan
+		if err := fetchRepo(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		if exists("r/img/sprite.png") != scan {
+			t.Errorf("with -scan-scripts %v, downloaded the path of the script: %v", scan, !scan)
+		}
+	}
+}
+
+func TestVerifyLocal(t *testing.T) {
+	// the renamed logo fails to download, leaving its new name dangling
+	src := serveRepo(t, "![logo](logo.png) ![icon](icon.png)\n", map[string]string{"icon.png": "image"})
+	if err := renames.Set("logo.png=>brand/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	logOut, verifyMode = &out, "fail"
+	t.Cleanup(func() {
+		delete(renames, "logo.png")
+		logOut, verifyMode = os.Stderr, ""
+	})
+
+	err := fetchRepo(context.Background(), src)
+	if err == nil || err.Error() != "1 dangling local references in README.md" {
+		t.Errorf("got %v, want the dangling reference to fail the fetch", err)
+	}
+	if !strings.Contains(out.String(), "README.md references brand/logo.png, which does not exist locally") {
+		t.Errorf("reported\n%s", out.String())
+	}
+}
+
+func TestRewriteRelativeTo(t *testing.T) {
+	src := serveRepo(t, "![logo](img/logo.png)\n\n[docs](https://example.com/docs)\n", map[string]string{"img/logo.png": "image"})
+	rewriteBase = "site/pages"
+	t.Cleanup(func() { rewriteBase = "" })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "![logo](../../img/logo.png)\n\n[docs](https://example.com/docs)\n"; string(content) != want {
+		t.Errorf("saved %q, want %q", content, want)
+	}
+}
+
+func TestFetchTableImages(t *testing.T) {
+	readme := "| Light | Dark | Mobile |\n| --- | --- | --- |\n" +
+		"| ![light](shots/light.png) | ![dark](shots/dark.png) | ![mobile](shots/mobile.jpg) |\n"
+	files := map[string]string{"shots/light.png": "image", "shots/dark.png": "image", "shots/mobile.jpg": "image"}
+	src := serveRepo(t, readme, files)
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		if !exists("r/" + name) {
+			t.Errorf("%s of the table row was not downloaded", name)
+		}
+	}
+}
+
+func TestMaxBytesPerPixel(t *testing.T) {
+	src := serveRepo(t, "![icon](icon.png) ![bloated](bloated.png)\n", map[string]string{
+		"icon.png": pngImage(t, 64, 64),
+		// a 2x2 image carrying 10KB
+		"bloated.png": pngImage(t, 2, 2) + strings.Repeat("\x00", 10<<10),
+	})
+	maxBytesPerPixel = 100
+	t.Cleanup(func() { maxBytesPerPixel = 0 })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/icon.png") {
+		t.Error("the image of a reasonable size was skipped")
+	}
+	if exists("r/bloated.png") {
+		t.Error("the image far heavier than its dimensions was kept")
+	}
+}
+
+func TestPrintReadme(t *testing.T) {
+	readme := "# R\n\n![logo](docs/logo.png)\n"
+	src := serveRepo(t, readme, map[string]string{"docs/logo.png": "image"})
+	printReadme = true
+	t.Cleanup(func() { printReadme = false })
+	var err error
+	out := captureStdout(t, func() { err = fetchRepo(context.Background(), src) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != readme {
+		t.Errorf("wrote %q to stdout, want only the README %q", out, readme)
+	}
+	if exists("r") {
+		t.Error("saved files with -print-readme")
+	}
+}
+
+func TestAssetAboveReadme(t *testing.T) {
+	src := serveRepo(t, "", map[string]string{
+		"docs/guide/README.md": "![logo](../../assets/logo.png)\n![away](../../../away.png)\n",
+		"assets/logo.png":      "logo",
+	})
+	src.readme = "docs/guide/README.md"
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	logo, err := os.ReadFile(filepath.Join("r", "_root", "assets", "logo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(logo) != "logo" {
+		t.Errorf("saved the logo as %q", logo)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "![logo](_root/assets/logo.png)\n![away](../../../away.png)\n"; string(content) != want {
+		t.Errorf("saved the README as %q, want %q", content, want)
+	}
+}
+
+func TestBaseHref(t *testing.T) {
+	src := serveRepo(t, "<base href=\"docs/\">\n\n<img src=\"logo.png\">\n", map[string]string{"docs/logo.png": "logo"})
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/docs/logo.png") {
+		t.Error("the image was not resolved against the <base href>")
+	}
+
+	for _, test := range []struct{ base, ref, want string }{
+		{"docs/", "logo.png", "docs/logo.png"},
+		{"docs/", "../logo.png", "logo.png"},
+		{"docs/", "/logo.png", "/logo.png"},
+		{"https://example.com/site/", "logo.png", "https://example.com/site/logo.png"},
+		{"", "logo.png", "logo.png"},
+	} {
+		if got := resolveBase(test.base, test.ref); got != test.want {
+			t.Errorf("resolveBase(%q, %q) = %q, want %q", test.base, test.ref, got, test.want)
+		}
+	}
+}
+
+func TestRefFile(t *testing.T) {
+	serveFiles(t, map[string]string{"github.com/o/r/raw/0123abcd/README.md": "# At 0123abcd\n"})
+	dir := inTempDir(t)
+	file := filepath.Join(dir, "ref.txt")
+	if err := os.WriteFile(file, []byte("  0123abcd \nmain\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := readRefFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "0123abcd" {
+		t.Fatalf("read the ref %q, want 0123abcd", ref)
+	}
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: ref, readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# At 0123abcd\n" {
+		t.Errorf("saved %q, want the README at the ref of the file", content)
+	}
+}
+
+func TestAssetCollision(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/o/r/raw/HEAD/README.md":
+			w.Write([]byte("![a](img/a.png)\n![again](./img/a.png)\n![b](img/b.png)\n"))
+		case "/o/r/raw/HEAD/img/a.png":
+			w.Write([]byte(strings.Repeat("a", 1<<16)))
+		case "/o/r/raw/HEAD/img/b.png":
+			w.Write([]byte(strings.Repeat("b", 1<<16)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	inTempDir(t)
+	var out strings.Builder
+	concurrency, logOut = 4, &out
+	t.Cleanup(func() { concurrency, logOut = 1, os.Stderr })
+	// -rename sends img/b.png where img/a.png is saved
+	if err := renames.Set("img/b.png => img/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(renames, "img/b.png") })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.ReadFile(filepath.Join("r", "img", "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != strings.Repeat("a", 1<<16) {
+		t.Error("img/a.png was overwritten by another reference")
+	}
+	if !strings.Contains(out.String(), "skipped img/b.png, it would overwrite img/a.png from img/a.png") {
+		t.Errorf("the collision was not reported, logged %q", out.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests["/o/r/raw/HEAD/img/a.png"] != 1 {
+		t.Errorf("img/a.png was downloaded %d times for its two spellings, want once", requests["/o/r/raw/HEAD/img/a.png"])
+	}
+}
+
+func TestKeepUpstreamLinks(t *testing.T) {
+	readme := "# R\r\n\r\n![logo](img/logo.png?raw=true)\r\n<img src=\"../../other/pic.png\">\r\n[Usage](#usage)\r\n"
+	src := serveRepo(t, readme, map[string]string{"img/logo.png": "logo"})
+	keepUpstream = true
+	t.Cleanup(func() { keepUpstream = false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != readme {
+		t.Errorf("saved the README as %q, want the upstream bytes %q", content, readme)
+	}
+	if !exists("r/img/logo.png") {
+		t.Error("the image was not downloaded")
+	}
+}
+
+func TestResponseTypeSniffed(t *testing.T) {
+	image := pngImage(t, 1, 1)
+	for _, test := range []struct{ header, body, want string }{
+		{"text/plain; charset=utf-8", image, "image/png"},
+		{"application/octet-stream", image, "image/png"},
+		{"", image, "image/png"},
+		{"image/svg+xml", "<svg/>", "image/svg+xml"},
+		{"text/plain", "", "text/plain"},
+	} {
+		resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader(test.body))}
+		if test.header != "" {
+			resp.Header.Set("Content-Type", test.header)
+		}
+		if got := responseType(resp); got != test.want {
+			t.Errorf("with Content-Type %q, got type %s, want %s", test.header, got, test.want)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.body {
+			t.Errorf("sniffing left %q of the body, want %q", body, test.body)
+		}
+	}
+}
+
+func TestMaxDirDepth(t *testing.T) {
+	src := serveRepo(t, "![deep](a/b/c/d/e.png)\n![shallow](a/b/c/d.png)\n", map[string]string{
+		"a/b/c/d/e.png": "deep",
+		"a/b/c/d.png":   "shallow",
+	})
+	maxDirDepth = 3
+	t.Cleanup(func() { maxDirDepth = 0 })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if exists("r/a/b/c/d/e.png") {
+		t.Error("saved an asset nested deeper than -max-dir-depth")
+	}
+	if !exists("r/a/b/c/d.png") {
+		t.Error("an asset within -max-dir-depth was not saved")
+	}
+}
+
+func TestMaxRepoBytes(t *testing.T) {
+	src := serveRepo(t, "![first](first.png)\n![second](second.png)\n", map[string]string{
+		"first.png":  "8 bytes!",
+		"second.png": "8 bytes!",
+	})
+	var out strings.Builder
+	maxRepoBytes, logOut = 8, &out
+	t.Cleanup(func() { maxRepoBytes, logOut = 0, os.Stderr })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	// either asset may be the one to reach the limit first
+	if exists("r/first.png") == exists("r/second.png") {
+		t.Error("want exactly one of the assets saved within -max-repo-bytes")
+	}
+	if !strings.Contains(out.String(), ".png: the repository is at -max-repo-bytes 8") {
+		t.Errorf("the asset past -max-repo-bytes was not reported, logged %q", out.String())
+	}
+}
+
+func TestStripFrontMatter(t *testing.T) {
+	src := serveRepo(t, "---\ntitle: R\nlayout: default\n---\n\n# R\n", nil)
+	stripFrontMatter, extractFrontMatter = true, true
+	t.Cleanup(func() { stripFrontMatter, extractFrontMatter = false, false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# R\n" {
+		t.Errorf("saved the README as %q, want the front matter removed", content)
+	}
+	matter, err := os.ReadFile(filepath.Join("r", "frontmatter.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(matter) != "title: R\nlayout: default\n" {
+		t.Errorf("extracted the front matter as %q", matter)
+	}
+
+	rest, tomlMatter, format := splitFrontMatter("+++\ntitle = \"R\"\n+++\n# R\n")
+	if rest != "# R\n" || tomlMatter != "title = \"R\"\n" || format != "toml" {
+		t.Errorf("split TOML front matter into %q, %q and %q", rest, tomlMatter, format)
+	}
+}
+
+func TestCDN(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		requested []string
+	)
+	files := map[string]string{
+		"github.com/o/r/raw/0123abcd/README.md":         "![logo](img/logo.png)\n![new](img/new.png)\n",
+		"cdn.jsdelivr.net/gh/o/r@0123abcd/img/logo.png": "logo from the CDN",
+		"github.com/o/r/raw/0123abcd/img/logo.png":      "logo from GitHub",
+		"github.com/o/r/raw/0123abcd/img/new.png":       "new from GitHub",
+	}
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.Host+r.URL.Path)
+		mu.Unlock()
+		body, ok := files[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	inTempDir(t)
+	cdn = "jsdelivr"
+	t.Cleanup(func() { cdn = "" })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "0123abcd", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CDN serves what it has, GitHub what it does not have yet
+	for file, want := range map[string]string{"logo.png": "logo from the CDN", "new.png": "new from GitHub"} {
+		got, err := os.ReadFile(filepath.Join("r", "img", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("saved %s as %q, want %q", file, got, want)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, link := range requested {
+		if link == "github.com/o/r/raw/0123abcd/img/logo.png" {
+			t.Error("fell back to GitHub for an asset the CDN had")
+		}
+	}
+}
+
+func TestSymlinkedReadme(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":      "docs/README.md",
+		"github.com/o/r/raw/HEAD/docs/README.md": "# R\n\n![logo](logo.png)\n",
+		"github.com/o/r/raw/HEAD/docs/logo.png":  "logo",
+		"api.github.com/repos/o/r/git/trees/HEAD": `{"tree": [
+			{"path": "README.md", "mode": "120000", "type": "blob"},
+			{"path": "docs", "mode": "040000", "type": "tree"}
+		]}`,
+		"api.github.com/repos/o/r/git/trees/HEAD:docs": `{"tree": [
+			{"path": "README.md", "mode": "100644", "type": "blob"},
+			{"path": "logo.png", "mode": "100644", "type": "blob"}
+		]}`,
+		"github.com/o/plain/raw/HEAD/README.md":      "docs/README.md",
+		"github.com/o/plain/raw/HEAD/docs/README.md": "# not followed\n",
+		"api.github.com/repos/o/plain/git/trees/HEAD": `{"tree": [
+			{"path": "README.md", "mode": "100644", "type": "blob"}
+		]}`,
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# R\n\n![logo](logo.png)\n" {
+		t.Errorf("saved %q, want the content of the link's target", content)
+	}
+	if !exists("r/logo.png") {
+		t.Error("the image next to the target was not downloaded")
+	}
+
+	// a regular file that merely reads like a path is kept as it is
+	src = source{repo: mustRepo(t, "https://github.com/o/plain"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join("plain", "README.md")); err != nil || string(content) != "docs/README.md" {
+		t.Errorf("saved %q, %v for a regular README, want it unchanged", content, err)
+	}
+}
+
+func TestValidateExpand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	src := serveRepo(t, "# R\n", nil)
+	validateExpand = true
+	t.Cleanup(func() { validateExpand = false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatalf("the generated expand.sh did not pass bash -n: %v", err)
+	}
+
+	broken := filepath.Join(t.TempDir(), "expand.sh")
+	if err := os.WriteFile(broken, []byte(strings.Replace(fmt.Sprintf(expandScript, "https://github.com/o/r"), "\n", "\nif then\n", 1)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkScript(broken); err == nil || !strings.Contains(err.Error(), "has syntax errors") {
+		t.Errorf("got %v for a broken script, want its syntax errors", err)
+	}
+}
+
+func TestAssetRef(t *testing.T) {
+	// img/moved.png is gone from HEAD, img/new.png is not at v1.0 yet
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":     "![moved](img/moved.png)\n![new](img/new.png)\n",
+		"github.com/o/r/raw/HEAD/img/new.png":   "new at HEAD",
+		"github.com/o/r/raw/v1.0/img/moved.png": "moved at v1.0",
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	assetRef = "v1.0"
+	t.Cleanup(func() { assetRef = "" })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{"moved.png": "moved at v1.0", "new.png": "new at HEAD"} {
+		got, err := os.ReadFile(filepath.Join("r", "img", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("saved %s as %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestStripLiquid(t *testing.T) {
+	readme := "![logo]({{ site.baseurl }}/assets/logo.png)\n<img src=\"{{ '/assets/shot.png' | relative_url }}\">\n"
+	src := serveRepo(t, readme, map[string]string{
+		"assets/logo.png": "logo",
+		"assets/shot.png": "shot",
+	})
+	stripLiquidRefs = true
+	t.Cleanup(func() { stripLiquidRefs = false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/assets/logo.png") || !exists("r/assets/shot.png") {
+		t.Error("the assets behind Liquid paths were not downloaded")
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "![logo](assets/logo.png)\n<img src=\"assets/shot.png\">\n"; string(content) != want {
+		t.Errorf("saved the README as %q, want %q", content, want)
+	}
+
+	if got := stripLiquid("![logo]({{ site.baseurl }}/assets/logo.png)\n", "docs/README.md"); got != "![logo](../assets/logo.png)\n" {
+		t.Errorf("a README in docs/ got %q, want the path relative to it", got)
+	}
+}
+
+func TestPlainBlobLink(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/main/docs/GUIDE.md":        "# Guide\n\n![diagram](img/diagram.png)\n",
+		"github.com/o/r/raw/main/docs/img/diagram.png": "diagram",
+	})
+	inTempDir(t)
+	src, err := parseSource(context.Background(), "https://github.com/o/r/blob/main/docs/GUIDE.md?plain=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.repo.String() != "https://github.com/o/r" || src.ref != "main" || src.readme != "docs/GUIDE.md" {
+		t.Fatalf("read the link as %s at %s, file %s", src.repo, src.ref, src.readme)
+	}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("r/img/diagram.png") {
+		t.Error("the image was not resolved against the directory of the file")
+	}
+}
+
+func TestHTMLParseLimit(t *testing.T) {
+	readme := strings.Repeat("<div>", 20000) + "<img src=\"deep.png\">" + strings.Repeat("</div>", 20000) + "\n\n![logo](logo.png)\n"
+	src := serveRepo(t, readme, map[string]string{"deep.png": "deep", "logo.png": "logo"})
+	var out strings.Builder
+	previous := htmlParseLimit
+	logOut, htmlParseLimit = &out, 64<<10
+	t.Cleanup(func() { logOut, htmlParseLimit = os.Stderr, previous })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "README.md is over -html-parse-limit 64KB") {
+		t.Errorf("the fallback was not reported, logged %q", out.String())
+	}
+	if !exists("r/deep.png") || !exists("r/logo.png") {
+		t.Error("the assets were not found without parsing the HTML")
+	}
+}
+
+func TestReadmeOutWithTitle(t *testing.T) {
+	src := serveRepo(t, "# My Project\n\n![logo](logo.png)\n", map[string]string{"logo.png": "logo"})
+	readmeOut, titleFrontMatter = "index.md", true
+	t.Cleanup(func() { readmeOut, titleFrontMatter = "", false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if exists("r/README.md") {
+		t.Error("the README was saved as README.md too")
+	}
+	content, err := os.ReadFile(filepath.Join("r", "index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "---\ntitle: \"My Project\"\n---\n\n# My Project\n\n![logo](logo.png)\n"; string(content) != want {
+		t.Errorf("saved index.md as %q, want %q", content, want)
+	}
+	if !exists("r/logo.png") {
+		t.Error("the image was not downloaded")
+	}
+}
+
+func TestRepoRootPath(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/feature/docs/docs/README.md":  "![logo](../assets/logo.png)\n![shot](shot.png)\n",
+		"github.com/o/r/raw/feature/docs/assets/logo.png": "logo",
+		"github.com/o/r/raw/feature/docs/docs/shot.png":   "shot",
+	})
+	inTempDir(t)
+	repoRootPath = "docs"
+	t.Cleanup(func() { repoRootPath = "" })
+
+	src, err := parseSource(context.Background(), "https://raw.githubusercontent.com/o/r/feature/docs/docs/README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.ref != "feature/docs" || src.readme != "docs/README.md" {
+		t.Fatalf("read the link as %s at %s, want docs/README.md at feature/docs", src.readme, src.ref)
+	}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"r/_root/assets/logo.png": "logo", "r/shot.png": "shot"} {
+		content, err := os.ReadFile(filepath.FromSlash(name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(content) != want {
+			t.Errorf("%s holds %q, want %q", name, content, want)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// splitLines splits content into lines, each keeping its own line ending.
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// fenceTracker follows fenced code blocks (``` or ~~~) through a markdown
+// document fed to it line by line.
+type fenceTracker struct {
+	marker string
+}
+
+// next consumes line and reports whether it belongs to a code block,
+// counting the opening and closing fences as part of the block.
+func (f *fenceTracker) next(line string) bool {
+	trimmed := strings.TrimRight(line, "\r\n")
+	indent := len(trimmed) - len(strings.TrimLeft(trimmed, " "))
+	trimmed = strings.TrimLeft(trimmed, " ")
+	if indent > 3 {
+		return f.marker != ""
+	}
+
+	if f.marker == "" {
+		for _, c := range []string{"`", "~"} {
+			run := len(trimmed) - len(strings.TrimLeft(trimmed, c))
+			if run >= 3 {
+				f.marker = strings.Repeat(c, run)
+				return true
+			}
+		}
+		return false
+	}
+
+	if strings.HasPrefix(trimmed, f.marker) && strings.Trim(trimmed, f.marker[:1]+" \t") == "" {
+		f.marker = ""
+	}
+	return true
+}
+
+// normalizeLineEndings rewrites every line ending in content to LF or CRLF.
+// With preserveCode the lines of fenced code blocks are left untouched.
+func normalizeLineEndings(content, mode string, preserveCode bool) string {
+	eol := "\n"
+	if mode == "crlf" {
+		eol = "\r\n"
+	}
+
+	out := new(strings.Builder)
+	fences := fenceTracker{}
+	for _, line := range splitLines(content) {
+		if fences.next(line) && preserveCode {
+			out.WriteString(line)
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString(strings.TrimSuffix(line, "\r"))
+			continue
+		}
+		out.WriteString(strings.TrimRight(line, "\r\n") + eol)
+	}
+	return out.String()
+}
+
+// checkLineEndingMode validates the value of -normalize-line-endings.
+func checkLineEndingMode(mode string) error {
+	switch mode {
+	case "", "lf", "crlf":
+		return nil
+	}
+	return fmt.Errorf("unknown line ending %q, expected lf or crlf", mode)
+}
+
+var (
+	headingRegex   = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+	slugDropRegex  = regexp.MustCompile(`[^\p{L}\p{N}\- _]`)
+	mdTargetRegex  = regexp.MustCompile(`(\]\([ \t]*<?)([^)\s>]+)`)
+	attrValueRegex = regexp.MustCompile(`((?:src|href)=["'])([^"']+)`)
+	refTargetRegex = regexp.MustCompile(`(?m)^( {0,3}\[[^\]]+\]:[ \t]*<?)([^\s>]+)`)
+)
+
+// slugify turns heading text into the anchor GitHub generates for it.
+func slugify(text string) string {
+	text = strings.ToLower(strings.TrimSpace(text))
+	text = slugDropRegex.ReplaceAllString(text, "")
+	return strings.ReplaceAll(text, " ", "-")
+}
+
+// isLocalRef reports whether ref points at a file of the repository rather
+// than an external URL, an anchor or an absolute path.
+func isLocalRef(ref string) bool {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "/") {
+		return false
+	}
+	u, err := url.Parse(ref)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// mapLocalRefs rewrites the local link and image targets of a markdown
+// document (inline links, HTML src/href attributes and reference
+// definitions) through fn.
+func mapLocalRefs(content string, fn func(ref string) string) string {
+	replace := func(re *regexp.Regexp, content string) string {
+		return re.ReplaceAllStringFunc(content, func(match string) string {
+			parts := re.FindStringSubmatch(match)
+			if !isLocalRef(parts[2]) {
+				return match
+			}
+			return parts[1] + fn(parts[2])
+		})
+	}
+	content = replace(mdTargetRegex, content)
+	content = replace(attrValueRegex, content)
+	return replace(refTargetRegex, content)
+}
+
+// section is a part of a README that starts at a heading.
+type section struct {
+	title   string
+	content string
+}
+
+// splitSections cuts content at every heading of the given level or above.
+// Text before the first such heading becomes an untitled section. Only the
+// headings of the document itself count, not those in code blocks, quotes
+// or lists.
+func splitSections(content string, level int) []section {
+	source := []byte(content)
+	doc := mdRenderer.Parser().Parse(text.NewReader(source))
+
+	sections := []section{}
+	current, start := section{}, 0
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		heading, ok := n.(*ast.Heading)
+		if !ok || heading.Level > level || heading.Lines().Len() == 0 {
+			continue
+		}
+		// the section starts at the line of the heading, its markers
+		// included
+		lines := heading.Lines()
+		at := bytes.LastIndexByte(source[:lines.At(0).Start], '\n') + 1
+		if current.title != "" || strings.TrimSpace(content[start:at]) != "" {
+			current.content = content[start:at]
+			sections = append(sections, current)
+		}
+		title := []string{}
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			title = append(title, strings.TrimSpace(string(segment.Value(source))))
+		}
+		current, start = section{title: strings.Join(title, " ")}, at
+	}
+	if current.title != "" || strings.TrimSpace(content[start:]) != "" {
+		current.content = content[start:]
+		sections = append(sections, current)
+	}
+	return sections
+}
+
+// writeSections saves every section of content to its own file in
+// dir/sections, along with an index.md linking them in order. Local
+// references are adjusted for the extra directory level.
+func writeSections(dir, content string, level int) error {
+	sectionsDir := filepath.Join(dir, "sections")
+	err := os.MkdirAll(sectionsDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	index := new(strings.Builder)
+	index.WriteString("# Sections\n\n")
+	for i, s := range splitSections(content, level) {
+		title := s.title
+		if title == "" {
+			title = "Introduction"
+		}
+		name := fmt.Sprintf("%02d-%s.md", i, slugify(title))
+		if slugify(title) == "" {
+			name = fmt.Sprintf("%02d.md", i)
+		}
+
+		body := mapLocalRefs(s.content, func(ref string) string {
+			return "../" + ref
+		})
+		err = os.WriteFile(filepath.Join(sectionsDir, name), []byte(body), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(index, "- [%s](%s)\n", title, name)
+	}
+	return os.WriteFile(filepath.Join(sectionsDir, "index.md"), []byte(index.String()), 0644)
+}
+
+// referencedPaths collects the local paths content links to outside of code
+// blocks, cleaned so they compare equal to paths built elsewhere.
+func referencedPaths(content string) map[string]bool {
+	refs := map[string]bool{}
+	collect := func(ref string) string {
+		if u, err := url.Parse(ref); err == nil {
+			refs[path.Clean(u.Path)] = true
+		}
+		return ref
+	}
+	mapProse(content, func(line string) string {
+		for _, ref := range cssURLs(line) {
+			collect(ref)
+		}
+		return mapLocalRefs(line, collect)
+	})
+	return refs
+}
+
+var inlineCodeRegex = regexp.MustCompile("(`+)([^`]|[^`][\\s\\S]*?[^`])(`+)")
+
+// maskCode prepares markdown for asset detection. Fenced code blocks are
+// blanked out, keeping their line breaks, and inline code spans become
+// <code> elements with escaped content, so examples shown as code are not
+// mistaken for references.
+func maskCode(content string) string {
+	out := new(strings.Builder)
+	fences := fenceTracker{}
+	for _, line := range splitLines(content) {
+		if fences.next(line) {
+			out.WriteString(line[len(strings.TrimRight(line, "\r\n")):])
+			continue
+		}
+		out.WriteString(line)
+	}
+	return inlineCodeRegex.ReplaceAllStringFunc(out.String(), func(span string) string {
+		parts := inlineCodeRegex.FindStringSubmatch(span)
+		if len(parts[1]) != len(parts[3]) {
+			return span
+		}
+		return "<code>" + html.EscapeString(strings.TrimSpace(parts[2])) + "</code>"
+	})
+}
+
+// tagNameChars are the characters of HTML tag names, letters first.
+const tagNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-"
+
+// hasAssetHints reports whether masked, the output of maskCode, may
+// reference assets at all: every asset needs a markdown image, an HTML tag
+// or a link to an image file. READMEs of plain text and links have none,
+// and are saved without parsing them for assets. Plain string scans keep
+// this much cheaper than the parse it saves.
+func hasAssetHints(masked string) bool {
+	if strings.Contains(masked, "![") {
+		return true
+	}
+	for rest := masked; ; {
+		i := strings.Index(rest, "](")
+		if i < 0 {
+			break
+		}
+		rest = rest[i+2:]
+		target, _, ok := strings.Cut(rest, ")")
+		if !ok || strings.ContainsAny(target, "( \t\n") {
+			continue
+		}
+		switch path.Ext(strings.TrimSuffix(target, "?raw=true")) {
+		case ".png", ".jpg", ".gif", ".svg":
+			return true
+		}
+	}
+	for rest := masked; ; {
+		i := strings.IndexByte(rest, '<')
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+1:]
+		if rest == "" || !strings.ContainsAny(rest[:1], tagNameChars[:52]) {
+			continue
+		}
+		name := rest[:len(rest)-len(strings.TrimLeft(rest, tagNameChars))]
+		after := rest[len(name):]
+		if after == "" || !strings.ContainsAny(after[:1], " \t\r\n/>") {
+			continue
+		}
+		// inline code spans, which only hold assets with -include-code-refs
+		if name != "code" || after[0] != '>' || includeCodeRefs {
+			return true
+		}
+	}
+}
+
+var codeAssetRegex = regexp.MustCompile(`(?i)^[^\s<>"']+\.(png|jpe?g|gif|svg|webp|bmp|ico|pdf|mp4|webm|mov|mp3)$`)
+
+// isCodeAsset reports whether text, the content of an inline code span,
+// is nothing but a local asset path.
+func isCodeAsset(text string) bool {
+	text = strings.TrimSpace(text)
+	return codeAssetRegex.MatchString(text) && isLocalRef(text)
+}
+
+var codeStringRegex = regexp.MustCompile(`"([^"\n]*)"|'([^'\n]*)'`)
+
+// codeBlockAssets returns the local asset paths that fenced code blocks of
+// content in the -scan-code-assets languages give as values, like the logo
+// of a config example. Quoted strings count, and so do the plain values of
+// "key: value", "key = value" and "- value" lines.
+func codeBlockAssets(content string) []string {
+	if scanCodeLangs == "" {
+		return nil
+	}
+	langs := map[string]bool{}
+	for _, lang := range strings.Split(scanCodeLangs, ",") {
+		langs[strings.ToLower(strings.TrimSpace(lang))] = true
+	}
+
+	source := []byte(content)
+	assets := []string{}
+	ast.Walk(mdRenderer.Parser().Parse(text.NewReader(source)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		fence, ok := n.(*ast.FencedCodeBlock)
+		if !entering || !ok || !langs[strings.ToLower(string(fence.Language(source)))] {
+			return ast.WalkContinue, nil
+		}
+		for i := 0; i < fence.Lines().Len(); i++ {
+			segment := fence.Lines().At(i)
+			line := strings.TrimSpace(string(segment.Value(source)))
+			values := []string{}
+			for _, match := range codeStringRegex.FindAllStringSubmatch(line, -1) {
+				values = append(values, match[1]+match[2])
+			}
+			value := strings.TrimPrefix(line, "- ")
+			if _, after, ok := strings.Cut(value, ": "); ok {
+				value = after
+			} else if _, after, ok := strings.Cut(value, "= "); ok {
+				value = after
+			}
+			value, _, _ = strings.Cut(value, " #")
+			values = append(values, strings.TrimRight(strings.TrimSpace(value), ","))
+			for _, value := range values {
+				if isCodeAsset(value) {
+					assets = append(assets, strings.TrimSpace(value))
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return assets
+}
+
+// splitFrontMatter cuts a YAML (---) or TOML (+++) front matter block off the
+// start of content. It returns the rest of the content, the block without
+// its delimiters and its format, "yaml" or "toml"; matter is empty when
+// content has no front matter.
+func splitFrontMatter(content string) (rest, matter, format string) {
+	body := strings.TrimPrefix(content, "\ufeff")
+	lines := splitLines(body)
+	if len(lines) == 0 {
+		return content, "", ""
+	}
+	delimiter := strings.TrimRight(lines[0], " \t\r\n")
+	switch delimiter {
+	case "---":
+		format = "yaml"
+	case "+++":
+		format = "toml"
+	default:
+		return content, "", ""
+	}
+
+	offset := len(lines[0])
+	for _, line := range lines[1:] {
+		if strings.TrimRight(line, " \t\r\n") == delimiter {
+			matter = body[len(lines[0]):offset]
+			return strings.TrimLeft(body[offset+len(line):], "\r\n"), matter, format
+		}
+		offset += len(line)
+	}
+	return content, "", ""
+}
+
+var (
+	// {{ "/assets/x.png" | relative_url }}, also with absolute_url or
+	// prepend: site.baseurl
+	liquidFilterRegex = regexp.MustCompile(`\{\{-?\s*["']([^"']*)["']\s*\|\s*(?:relative_url|absolute_url|prepend:\s*site\.(?:baseurl|url))\s*-?\}\}`)
+	// {{ site.baseurl }}/assets/x.png
+	liquidBaseRegex = regexp.MustCompile(`\{\{-?\s*site\.(?:baseurl|url)\s*-?\}\}(/[^\s)"'>]*)`)
+)
+
+// stripLiquid turns the Jekyll site paths of a README at readme, like
+// {{ site.baseurl }}/assets/x.png, into plain paths relative to it, so the
+// assets they point at can be found and downloaded. The site is taken to be
+// served from the repository root. Code blocks are left alone.
+func stripLiquid(content, readme string) string {
+	relative := func(p string) string {
+		p = strings.TrimPrefix(p, "/")
+		for dir := path.Dir(readme); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			p = "../" + p
+		}
+		return p
+	}
+
+	out := new(strings.Builder)
+	fences := fenceTracker{}
+	for _, line := range splitLines(content) {
+		if !fences.next(line) && strings.Contains(line, "{{") {
+			line = liquidFilterRegex.ReplaceAllStringFunc(line, func(match string) string {
+				return relative(liquidFilterRegex.FindStringSubmatch(match)[1])
+			})
+			line = liquidBaseRegex.ReplaceAllStringFunc(line, func(match string) string {
+				return relative(liquidBaseRegex.FindStringSubmatch(match)[1])
+			})
+		}
+		out.WriteString(line)
+	}
+	return out.String()
+}
+
+var (
+	// an inline code span as maskCode leaves it, or a start tag
+	scanTagRegex  = regexp.MustCompile(`<code>([^<]*)</code>|<([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[^\s"'<>/=]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*/?>`)
+	scanAttrRegex = regexp.MustCompile(`([^\s"'<>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+)
+
+// scanDocument stands in for parsing masked, the output of maskCode, as
+// HTML when it is too large for that. The tags found by a single pass of
+// regular expressions become childless elements of the document, inline
+// code spans become <code> elements, and the remaining text is kept as
+// one text node for the markdown asset patterns to find.
+func scanDocument(masked string) *goquery.Document {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	root := &html.Node{Type: html.DocumentNode}
+	page := &html.Node{Type: html.ElementNode, Data: "html", DataAtom: atom.Html}
+	root.AppendChild(page)
+	page.AppendChild(body)
+
+	text := new(strings.Builder)
+	last := 0
+	for _, match := range scanTagRegex.FindAllStringSubmatchIndex(masked, -1) {
+		text.WriteString(masked[last:match[0]])
+		last = match[1]
+
+		if match[2] >= 0 {
+			code := &html.Node{Type: html.ElementNode, Data: "code", DataAtom: atom.Code}
+			code.AppendChild(&html.Node{Type: html.TextNode, Data: html.UnescapeString(masked[match[2]:match[3]])})
+			body.AppendChild(code)
+			continue
+		}
+		// the tag stays in the text too, as the HTML parse would not
+		// have seen it in markdown either way
+		text.WriteString(masked[match[0]:match[1]])
+		name := strings.ToLower(masked[match[4]:match[5]])
+		element := &html.Node{Type: html.ElementNode, Data: name, DataAtom: atom.Lookup([]byte(name))}
+		for _, attr := range scanAttrRegex.FindAllStringSubmatch(masked[match[6]:match[7]], -1) {
+			value := attr[2] + attr[3] + attr[4]
+			element.Attr = append(element.Attr, html.Attribute{Key: strings.ToLower(attr[1]), Val: html.UnescapeString(value)})
+		}
+		body.AppendChild(element)
+	}
+	text.WriteString(masked[last:])
+	body.AppendChild(&html.Node{Type: html.TextNode, Data: text.String()})
+	return goquery.NewDocumentFromNode(root)
+}
+
+var (
+	setextH1Regex = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	h1Regex       = regexp.MustCompile(`(?is)<h1\b[^>]*>(.*?)</h1>`)
+	titleKeyRegex = regexp.MustCompile(`(?m)^title[ \t]*[:=]`)
+	// tags and images inline in heading text
+	inlineTagRegex = regexp.MustCompile(`<[^>]*>|!\[[^\]]*\]\([^)]*\)`)
+)
+
+// firstTitle returns the text of the first level 1 heading of content,
+// written as "# Title", underlined with "=" or as an <h1> element, or ""
+// when it has none. Images and tags inside the heading are left out.
+func firstTitle(content string) string {
+	clean := func(text string) string {
+		return strings.Join(strings.Fields(html.UnescapeString(inlineTagRegex.ReplaceAllString(text, " "))), " ")
+	}
+	lines := splitLines(content)
+	fences := fenceTracker{}
+	offset := 0
+	for i, line := range lines {
+		at := offset
+		offset += len(line)
+		if fences.next(line) {
+			continue
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if match := headingRegex.FindStringSubmatch(line); match != nil && match[1] == "#" {
+			return clean(match[2])
+		}
+		if i+1 < len(lines) && strings.TrimSpace(line) != "" && setextH1Regex.MatchString(strings.TrimRight(lines[i+1], "\r\n")) {
+			return clean(line)
+		}
+		// an <h1> often spans several lines to center a logo over the name
+		if strings.Contains(strings.ToLower(line), "<h1") {
+			if match := h1Regex.FindStringSubmatch(content[at:]); match != nil {
+				return clean(match[1])
+			}
+		}
+	}
+	return ""
+}
+
+// addTitleFrontMatter gives content a front matter title, adding a YAML
+// block when it has none. Front matter that already sets a title is kept
+// as it is.
+func addTitleFrontMatter(content, title string) string {
+	_, matter, format := splitFrontMatter(content)
+	quoted := strconv.Quote(title)
+	if matter == "" {
+		return "---\ntitle: " + quoted + "\n---\n\n" + content
+	}
+	if titleKeyRegex.MatchString(matter) {
+		return content
+	}
+	line := "title: " + quoted + "\n"
+	if format == "toml" {
+		line = "title = " + quoted + "\n"
+	}
+	// right after the opening delimiter
+	at := strings.Index(content, "\n") + 1
+	return content[:at] + line + content[at:]
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const sectionsReadme = `Intro with ![logo](img/logo.png).
+
+# Install
+
+` + "```sh\n# not a heading\nmake\n```" + `
+
+> # Quoted, not a section
+
+## Usage ##
+
+### Options
+
+Still usage.
+
+Changelog
+=========
+
+Last.
+`
+
+func TestSplitSections(t *testing.T) {
+	sections := splitSections(sectionsReadme, 2)
+	titles := []string{}
+	for _, s := range sections {
+		titles = append(titles, s.title)
+	}
+	want := []string{"", "Install", "Usage", "Changelog"}
+	if strings.Join(titles, "|") != strings.Join(want, "|") {
+		t.Fatalf("split into %q, want %q", titles, want)
+	}
+	joined := ""
+	for _, s := range sections {
+		joined += s.content
+	}
+	if joined != sectionsReadme {
+		t.Errorf("the sections do not add up to the README:\n%s", joined)
+	}
+	if !strings.HasPrefix(sections[2].content, "## Usage ##\n") || !strings.Contains(sections[2].content, "### Options") {
+		t.Errorf("the Usage section is %q", sections[2].content)
+	}
+}
+
+func TestWriteSections(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeSections(dir, sectionsReadme, 1); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "sections", "index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIndex := "# Sections\n\n- [Introduction](00-introduction.md)\n- [Install](01-install.md)\n- [Changelog](02-changelog.md)\n"
+	if string(index) != wantIndex {
+		t.Errorf("index.md is\n%s\nwant\n%s", index, wantIndex)
+	}
+	intro, err := os.ReadFile(filepath.Join(dir, "sections", "00-introduction.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(intro), "![logo](../img/logo.png)") {
+		t.Errorf("the image of the introduction was not adjusted:\n%s", intro)
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	content := "# Title\r\n\r\n```bat\r\necho on\r\n```\r\nEnd"
+	for _, tc := range []struct {
+		mode         string
+		preserveCode bool
+		want         string
+	}{
+		{"lf", false, "# Title\n\n```bat\necho on\n```\nEnd"},
+		{"lf", true, "# Title\n\n```bat\r\necho on\r\n```\r\nEnd"},
+		{"crlf", false, content},
+	} {
+		if got := normalizeLineEndings(content, tc.mode, tc.preserveCode); got != tc.want {
+			t.Errorf("normalizeLineEndings(%q, %v) = %q, want %q", tc.mode, tc.preserveCode, got, tc.want)
+		}
+	}
+	if got, want := normalizeLineEndings("a\nb\n", "crlf", false), "a\r\nb\r\n"; got != want {
+		t.Errorf("normalizeLineEndings(crlf) = %q, want %q", got, want)
+	}
+}
+
+func TestCodeBlockAssets(t *testing.T) {
+	content := "# R\n\n```yaml\ntheme:\n  logo: assets/logo.png\n  icons:\n    - \"assets/icon.svg\"\n  url: https://example.com/remote.png\n```\n\n" +
+		"```python\nopen('assets/script.png')\n```\n"
+	if assets := codeBlockAssets(content); len(assets) > 0 {
+		t.Errorf("found %v without -scan-code-assets", assets)
+	}
+
+	scanCodeLangs = "yaml, json"
+	t.Cleanup(func() { scanCodeLangs = "" })
+	want := []string{"assets/logo.png", "assets/icon.svg"}
+	if assets := codeBlockAssets(content); !reflect.DeepEqual(assets, want) {
+		t.Errorf("got %v, want %v", assets, want)
+	}
+
+	src := serveRepo(t, content, map[string]string{
+		"assets/logo.png": "logo",
+		"assets/icon.svg": "<svg></svg>",
+	})
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"r/assets/logo.png", "r/assets/icon.svg"} {
+		if !exists(name) {
+			t.Errorf("%s was not downloaded", name)
+		}
+	}
+}
+
+const assetFreeReadme = "# Tool\n\nA tool that does one thing, see the [docs](https://example.com/docs) and [license](LICENSE).\n\n" +
+	"```sh\ngo install example.com/tool@latest\n```\n\nUse `tool <file>` to run it.\n"
+
+func TestAssetFreeReadme(t *testing.T) {
+	if hasAssetHints(maskCode(assetFreeReadme)) {
+		t.Fatal("the README is not taken for asset-free")
+	}
+	for _, content := range []string{"![logo](logo.png)", "see [the logo](logo.png)", "<img src=\"logo.png\">"} {
+		if !hasAssetHints(maskCode(content)) {
+			t.Errorf("%q is taken for asset-free", content)
+		}
+	}
+
+	src := serveRepo(t, assetFreeReadme, nil)
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != assetFreeReadme {
+		t.Errorf("saved the README as %q", content)
+	}
+	entries, err := os.ReadDir("r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			t.Errorf("made the directory %s for a README without assets", entry.Name())
+		}
+	}
+}
+
+func BenchmarkAssetFreeReadme(b *testing.B) {
+	content := strings.Repeat(assetFreeReadme, 50)
+	selector := selectorPresets["default"]
+	for _, bc := range []struct {
+		name string
+		find func(masked string) int
+	}{
+		{"fast path", func(masked string) int {
+			if !hasAssetHints(masked) {
+				return 0
+			}
+			return -1
+		}},
+		{"parsed", func(masked string) int {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(masked))
+			if err != nil {
+				b.Fatal(err)
+			}
+			return doc.Find(selector).Length()
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if found := bc.find(maskCode(content)); found != 0 {
+					b.Fatalf("found %d assets", found)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// enterMirror makes dir the working directory of the run, turning it into a
+// git repository first when it is not one yet.
+func enterMirror(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	if exec.Command("git", "-C", dir, "rev-parse", "--is-inside-work-tree").Run() != nil {
+		err = git("-C", dir, "init", "-q")
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chdir(dir)
+}
+
+// mirrorMu keeps the commits of repositories fetched at once from racing
+// for the index of the mirror.
+var mirrorMu sync.Mutex
+
+// commitMirror commits what the fetch of src changed in the mirror, naming
+// the source repository, ref and commit in the message. Nothing is
+// committed when the fetch changed nothing.
+func commitMirror(ctx context.Context, src source) error {
+	mirrorMu.Lock()
+	defer mirrorMu.Unlock()
+	err := git("add", "-A", "--", src.repoDir())
+	if err != nil {
+		return err
+	}
+	if exec.Command("git", "diff", "--cached", "--quiet").Run() == nil {
+		logf("%s is unchanged, nothing to commit\n", src.repoDir())
+		return nil
+	}
+
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Mirror %s/%s at %s", owner, repo, src.ref)
+	// only the GitHub API can tell the commit
+	if provider == "github" {
+		if sha, err := resolveCommit(ctx, src); err == nil {
+			message += fmt.Sprintf(" (%s)", sha)
+		}
+	}
+	return git("commit", "-q", "-m", message, "--", src.repoDir())
+}
+
+func git(args ...string) error {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
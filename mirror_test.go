@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestMirrorIntoGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/main/README.md":     "# R\n",
+		"api.github.com/repos/o/r/commits/main": `{"sha": "0123abcd"}`,
+	})
+	inTempDir(t)
+	for _, name := range []string{"AUTHOR", "COMMITTER"} {
+		t.Setenv("GIT_"+name+"_NAME", "Mirror")
+		t.Setenv("GIT_"+name+"_EMAIL", "mirror@example.com")
+	}
+	mirrorDir = "mirror"
+	t.Cleanup(func() { mirrorDir = "" })
+	if err := enterMirror(mirrorDir); err != nil {
+		t.Fatal(err)
+	}
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "main", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("git", "log", "--format=%s", "--name-only").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git log: %v: %s", err, out)
+	}
+	log := string(out)
+	if !strings.HasPrefix(log, "Mirror o/r at main (0123abcd)\n") || !strings.Contains(log, "r/README.md\n") {
+		t.Errorf("the mirror's history is\n%s", log)
+	}
+
+	// a fetch that changes nothing commits nothing
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	out, err = exec.Command("git", "rev-list", "--count", "HEAD").CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Errorf("the mirror has %s commits, want 1 (%v)", strings.TrimSpace(string(out)), err)
+	}
+}
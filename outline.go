@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outlineEntry is a heading of the README in outline.json, with the
+// headings of lower levels that follow it as children.
+type outlineEntry struct {
+	Level    int             `json:"level"`
+	Text     string          `json:"text"`
+	Slug     string          `json:"slug"`
+	Offset   int             `json:"offset"`
+	Children []*outlineEntry `json:"children"`
+}
+
+// buildOutline returns the heading hierarchy of content. Offsets are the
+// byte positions of the heading lines, and repeated slugs get the -1, -2,
+// ... suffixes GitHub gives their anchors.
+func buildOutline(content string) []*outlineEntry {
+	root := &outlineEntry{Children: []*outlineEntry{}}
+	stack := []*outlineEntry{root}
+	used := map[string]int{}
+	fences := fenceTracker{}
+	offset := 0
+	for _, line := range splitLines(content) {
+		start := offset
+		offset += len(line)
+		if fences.next(line) {
+			continue
+		}
+		match := headingRegex.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if match == nil {
+			continue
+		}
+
+		slug := slugify(match[2])
+		if n := used[slug]; n > 0 {
+			used[slug]++
+			slug = fmt.Sprintf("%s-%d", slug, n)
+		} else {
+			used[slug] = 1
+		}
+		entry := &outlineEntry{Level: len(match[1]), Text: match[2], Slug: slug, Offset: start, Children: []*outlineEntry{}}
+
+		for len(stack) > 1 && stack[len(stack)-1].Level >= entry.Level {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, entry)
+		stack = append(stack, entry)
+	}
+	return root.Children
+}
+
+// writeOutline saves the heading hierarchy of the README in dir to
+// outline.json.
+func writeOutline(dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(buildOutline(string(content)), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "outline.json"), append(data, '\n'), 0644)
+}
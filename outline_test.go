@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBuildOutline(t *testing.T) {
+	content := "# Title\n\nIntro\n\n## Install\n\n```sh\n# not a heading\n```\n\n## Usage\n\n### Flags & options\n\n## Usage\n"
+	leaf := func(level int, text, slug string, offset int) *outlineEntry {
+		return &outlineEntry{Level: level, Text: text, Slug: slug, Offset: offset, Children: []*outlineEntry{}}
+	}
+	usage := leaf(2, "Usage", "usage", 55)
+	usage.Children = append(usage.Children, leaf(3, "Flags & options", "flags--options", 65))
+	title := leaf(1, "Title", "title", 0)
+	title.Children = append(title.Children, leaf(2, "Install", "install", 16), usage, leaf(2, "Usage", "usage-1", 86))
+	want := []*outlineEntry{title}
+
+	got := buildOutline(content)
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("got the outline\n%s\nwant\n%s", gotJSON, wantJSON)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// outputBackend is where -output sends the files of a fetch. Fetches are
+// written to a local staging directory as usual and every repository is
+// handed to the backend once it is complete.
+type outputBackend interface {
+	// put stores the file at key, a slash separated path relative to the
+	// output root.
+	put(ctx context.Context, key string, body []byte) error
+	String() string
+}
+
+// output is the backend of -output, or nil when files stay on disk.
+var output outputBackend
+
+// parseOutput reads the value of -output.
+func parseOutput(spec string) (outputBackend, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("unknown -output %q, expected s3://bucket/prefix", spec)
+	}
+	return newS3Backend(u.Host, strings.Trim(u.Path, "/"))
+}
+
+// enterStaging moves the run into a new temporary directory for the fetch
+// to be written to before it is uploaded. The caller removes it.
+func enterStaging() (string, error) {
+	dir, err := os.MkdirTemp("", "readtheirs-output-")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.Chdir(dir)
+}
+
+// uploadOutput hands every file under dir, the output directory of one
+// repository, to the -output backend, keeping their paths.
+func uploadOutput(ctx context.Context, dir string) error {
+	return filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(filepath.Clean(file))
+		err = output.put(ctx, key, body)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s to %s: %v", key, output, err)
+		}
+		logf("uploaded %s to %s\n", key, output)
+		return nil
+	})
+}
+
+// s3Backend uploads to a bucket of S3 or of an S3-compatible service.
+// Credentials, region and endpoint come from the usual AWS_* variables,
+// falling back to the shared ~/.aws files.
+type s3Backend struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint *url.URL
+	// pathStyle puts the bucket in the path instead of the host name, as
+	// S3-compatible services mostly expect
+	pathStyle bool
+	creds     s3Credentials
+}
+
+type s3Credentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func newS3Backend(bucket, prefix string) (*s3Backend, error) {
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+
+	creds := s3Credentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKey == "" {
+		file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+		if file == "" {
+			file = filepath.Join(home, ".aws", "credentials")
+		}
+		section := readINISection(file, profile)
+		creds = s3Credentials{section["aws_access_key_id"], section["aws_secret_access_key"], section["aws_session_token"]}
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return nil, fmt.Errorf("no AWS credentials for -output, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		file := os.Getenv("AWS_CONFIG_FILE")
+		if file == "" {
+			file = filepath.Join(home, ".aws", "config")
+		}
+		name := "profile " + profile
+		if profile == "default" {
+			name = "default"
+		}
+		region = readINISection(file, name)["region"]
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	b := &s3Backend{bucket: bucket, prefix: prefix, region: region, creds: creds}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	} else {
+		b.pathStyle = true
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	b.endpoint = u
+	return b, nil
+}
+
+func (b *s3Backend) String() string {
+	return "s3://" + path.Join(b.bucket, b.prefix)
+}
+
+func (b *s3Backend) put(ctx context.Context, key string, body []byte) error {
+	u := *b.endpoint
+	u.Path = "/" + path.Join(b.prefix, key)
+	if b.pathStyle {
+		u.Path = "/" + b.bucket + u.Path
+	} else {
+		u.Host = b.bucket + "." + u.Host
+	}
+	// send the path encoded exactly as it is signed
+	u.RawPath = awsEscape(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, body, time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, covering
+// every header it carries.
+func (b *s3Backend) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	day := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if b.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := new(strings.Builder)
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := day + "/" + b.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + b.creds.secretKey)
+	for _, part := range []string{day, b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.creds.accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes p the way SigV4 canonical URIs are: everything
+// but unreserved characters and slashes.
+func awsEscape(p string) string {
+	out := new(strings.Builder)
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			out.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(out, "%%%02X", c)
+	}
+	return out.String()
+}
+
+// readINISection returns the keys of section [name] of the INI file at
+// file, as the shared AWS files are written. A missing file or section
+// gives no keys.
+func readINISection(file, name string) map[string]string {
+	keys := map[string]string{}
+	f, err := os.Open(file)
+	if err != nil {
+		return keys
+	}
+	defer f.Close()
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && current == name {
+			keys[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return keys
+}
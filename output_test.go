@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputS3(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		uploaded = map[string]string{}
+	)
+	files := map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":    "![logo](img/logo.png)\n",
+		"github.com/o/r/raw/HEAD/img/logo.png": "logo",
+	}
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.Host == "s3.example.com" {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+				http.Error(w, "unsigned", http.StatusForbidden)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			uploaded[r.URL.Path] = string(body)
+			mu.Unlock()
+			return
+		}
+		body, ok := files[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	inTempDir(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ENDPOINT_URL", "https://s3.example.com")
+	backend, err := parseOutput("s3://bucket/mirrors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output = backend
+	t.Cleanup(func() { output = nil })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for key, want := range map[string]string{
+		"/bucket/mirrors/r/README.md":    "![logo](img/logo.png)\n",
+		"/bucket/mirrors/r/img/logo.png": "logo",
+	} {
+		if got, ok := uploaded[key]; !ok || got != want {
+			t.Errorf("uploaded %q to %s, want %q", got, key, want)
+		}
+	}
+}
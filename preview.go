@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// previewWidth is the width of the thumbnail written by -preview.
+const previewWidth = 320
+
+// writePreview turns the first decodable image among the saved assets into
+// preview.png and writes an index.html showing it next to a link to the
+// README. Without any usable image the index is written without a preview.
+func writePreview(dir, title string, saved []string) error {
+	thumbnail := ""
+	for _, local := range saved {
+		img, err := decodeImage(filepath.Join(dir, local))
+		if err != nil {
+			continue
+		}
+		err = savePNG(filepath.Join(dir, "preview.png"), resize(img, previewWidth))
+		if err != nil {
+			return err
+		}
+		thumbnail = "preview.png"
+		break
+	}
+
+	page := new(strings.Builder)
+	fmt.Fprintf(page, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(title))
+	fmt.Fprintf(page, "<h1>%s</h1>\n", html.EscapeString(title))
+	if thumbnail != "" {
+		fmt.Fprintf(page, "<p><img src=\"%s\" alt=\"preview\"></p>\n", thumbnail)
+	}
+	fmt.Fprintf(page, "<p><a href=\"README.md\">README.md</a></p>\n</body>\n</html>\n")
+	return os.WriteFile(filepath.Join(dir, "index.html"), []byte(page.String()), 0644)
+}
+
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// bytesPerPixel returns the file size of the image at path divided by its
+// pixel count. Only the image header is decoded.
+func bytesPerPixel(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, err
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return 0, fmt.Errorf("empty image")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return float64(info.Size()) / float64(config.Width*config.Height), nil
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+// resize scales img down to the given width, keeping its aspect ratio, by
+// averaging the source pixels that fall into each destination pixel. Images
+// that are already small enough are returned as they are.
+func resize(img image.Image, width int) image.Image {
+	src := img.Bounds()
+	if src.Dx() <= width {
+		return img
+	}
+	height := src.Dy() * width / src.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := src.Min.Y + (y+1)*src.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := src.Min.X + (x+1)*src.Dx()/width
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1 || sy == y0; sy++ {
+				for sx := x0; sx < x1 || sx == x0; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreview(t *testing.T) {
+	src := serveRepo(t, "# R\n\n![shot](shot.png)\n", map[string]string{"shot.png": pngImage(t, 640, 480)})
+	preview = true
+	t.Cleanup(func() { preview = false })
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join("r", "preview.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 320 || config.Height != 240 {
+		t.Errorf("the preview is %dx%d, want 320x240", config.Width, config.Height)
+	}
+	index, err := os.ReadFile(filepath.Join("r", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), `<img src="preview.png"`) {
+		t.Errorf("index.html does not show the preview:\n%s", index)
+	}
+}
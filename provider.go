@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// checkProvider validates -provider and -raw-base. The generic provider only
+// reads raw files, so options and commands that need the GitHub API or
+// GitHub pages are refused with it.
+func checkProvider(command string) error {
+	switch provider {
+	case "github":
+		if rawBase != "" {
+			return fmt.Errorf("-raw-base needs -provider generic")
+		}
+		return nil
+	case "generic":
+	default:
+		return fmt.Errorf("unknown -provider %q, expected github or generic", provider)
+	}
+
+	if rawBase == "" {
+		return fmt.Errorf("-provider generic needs a -raw-base template")
+	}
+	if !strings.Contains(rawBase, "{path}") {
+		return fmt.Errorf("-raw-base %q has no {path} placeholder", rawBase)
+	}
+	switch command {
+	case "org", "user", "search", "serve":
+		return fmt.Errorf("%s is only supported for GitHub", command)
+	}
+	githubOnly := map[string]bool{
+		"from-file": true, "metadata": true, "fetch-og": true, "github-rendered": true,
+		"latest-release": true, "tag-match": true, "at": true, "since-commit": true, "cdn": true,
+		"list-unreferenced": true, "snapshot": true, "release-notes": true,
+	}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if githubOnly[f.Name] && err == nil {
+			err = fmt.Errorf("-%s is only supported for GitHub", f.Name)
+		}
+	})
+	if err == nil && linkMode == "github" {
+		err = fmt.Errorf("-link-mode github is only supported for GitHub")
+	}
+	return err
+}
+
+// genericSource reads the README of the repository at link from the raw
+// endpoint of -raw-base. Without -b, the default branch is asked from the
+// host with git ls-remote, and guessed when that fails.
+func genericSource(ctx context.Context, link string) (source, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return source{}, err
+	}
+	if u.Scheme == "" || strings.Trim(u.Path, "/") == "" {
+		return source{}, fmt.Errorf("%s is not a repository link", redactLink(link))
+	}
+	// git gets the link with its credentials, everything else asks hostAuth
+	takeUserinfo(u)
+	repo := *u
+	repo.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	src := source{repo: &repo, ref: branchName, readme: path.Join(repoRootPath, "README.md")}
+
+	if src.ref == "HEAD" {
+		src.ref, err = remoteDefaultBranch(ctx, link)
+		if err != nil {
+			src.ref, err = guessBranch(ctx, src, err)
+			if err != nil {
+				return source{}, err
+			}
+		}
+		logf("default branch of %s is %s\n", u.String(), src.ref)
+	}
+	return src, nil
+}
+
+// fallbackBranches are the branches guessBranch tries, in order: main
+// first, unless -prefer-master asks for the old order of legacy
+// repositories.
+func fallbackBranches() []string {
+	if preferMaster || !preferMain {
+		return []string{"master", "main"}
+	}
+	return []string{"main", "master"}
+}
+
+// guessBranch picks the first of fallbackBranches with a README at src,
+// for when the default branch could not be asked for, as without git. The
+// reason it could not is returned when no branch has one.
+func guessBranch(ctx context.Context, src source, reason error) (string, error) {
+	logf("%v, guessing the default branch\n", reason)
+	for _, branch := range fallbackBranches() {
+		src.ref = branch
+		_, err := probeFile(ctx, src.rawURL(src.readme))
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return branch, nil
+	}
+	return "", reason
+}
+
+// docsBranches are the branches -docs-branch looks at, in order, and
+// docsReadmes the files that count as their README.
+var (
+	docsBranches = []string{"gh-pages", "docs", "documentation"}
+	docsReadmes  = []string{"README.md", "index.md"}
+)
+
+// findDocsBranch returns the first of docsBranches holding one of
+// docsReadmes at src, along with that file. ok is false when none does,
+// and src is read as it is.
+func findDocsBranch(ctx context.Context, src source) (branch, readme string, ok bool, err error) {
+	for _, branch := range docsBranches {
+		src.ref = branch
+		for _, readme := range docsReadmes {
+			_, err := probeFile(ctx, src.rawURL(readme))
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return "", "", false, err
+			}
+			return branch, readme, true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// remoteDefaultBranch returns the branch HEAD of the git repository at link
+// points to.
+func remoteDefaultBranch(ctx context.Context, link string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", "ls-remote", "--symref", link, "HEAD").Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			stderr := strings.ReplaceAll(string(exit.Stderr), link, redactLink(link))
+			err = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr))
+		}
+		return "", fmt.Errorf("failed to find the default branch of %s: %v", redactLink(link), err)
+	}
+
+	// the symref comes as "ref: refs/heads/main\tHEAD"
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		target, name, ok := strings.Cut(strings.TrimPrefix(scanner.Text(), "ref: "), "\t")
+		if ok && name == "HEAD" && strings.HasPrefix(target, "refs/heads/") {
+			return strings.TrimPrefix(target, "refs/heads/"), nil
+		}
+	}
+	return "", fmt.Errorf("%s does not say what its default branch is", redactLink(link))
+}
+
+// fillRawTemplate fills a raw file URL template, as -raw-base and
+// -mirror-host take, in for the file at p of the repository at ref. {repo}
+// is the repository path, like owner/repo.
+func fillRawTemplate(template string, repo *url.URL, ref, p string) string {
+	return strings.NewReplacer(
+		"{repo}", strings.Trim(repo.Path, "/"),
+		"{ref}", escapePath(ref),
+		"{path}", escapePath(p),
+	).Replace(template)
+}
+
+// readFromHosts reads the document of src from its host and, when that
+// fails for any reason but the document not being there, from each
+// -mirror-host in turn. The first mirror to serve it is kept in src.host,
+// so the rest of the repository's files are read from it too.
+func readFromHosts(ctx context.Context, src *source) ([]byte, error) {
+	body, err := readDocument(ctx, *src)
+	if err == nil || src.host != "" || errors.Is(err, errNotFound) {
+		return body, err
+	}
+	for _, host := range mirrorHosts {
+		at := *src
+		at.host = host
+		mirrored, mirrorErr := readDocument(ctx, at)
+		if mirrorErr != nil {
+			logf("%s is not at %s either: %v\n", src.readme, at.rawURL(src.readme), mirrorErr)
+			continue
+		}
+		logf("%s failed (%v), read it from %s\n", src.readme, err, at.rawURL(src.readme))
+		src.host = host
+		return mirrored, nil
+	}
+	return nil, err
+}
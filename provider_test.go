@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGenericProvider(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "raw.example.com" || !strings.HasSuffix(r.URL.Path, "/o/r/trunk/README.md") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("# R on trunk\n"))
+	}))
+	dir := inTempDir(t)
+	for _, name := range []string{"AUTHOR", "COMMITTER"} {
+		t.Setenv("GIT_"+name+"_NAME", "Upstream")
+		t.Setenv("GIT_"+name+"_EMAIL", "upstream@example.com")
+	}
+	// the upstream repository, whose default branch ls-remote finds
+	repoDir := filepath.Join(dir, "o", "r")
+	for _, args := range [][]string{
+		{"init", "-q", repoDir},
+		{"-C", repoDir, "symbolic-ref", "HEAD", "refs/heads/trunk"},
+		{"-C", repoDir, "commit", "-q", "--allow-empty", "-m", "first"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	provider, rawBase, branchName = "generic", "https://raw.example.com/{repo}/{ref}/{path}", "HEAD"
+	t.Cleanup(func() { provider, rawBase = "github", "" })
+	if err := checkProvider(""); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := parseSource(context.Background(), "file://"+filepath.ToSlash(repoDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.ref != "trunk" {
+		t.Fatalf("found the default branch %q, want trunk", src.ref)
+	}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# R on trunk\n" {
+		t.Errorf("saved %q, want the README of the raw endpoint", content)
+	}
+}
+
+func TestMirrorHostFailover(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host + r.URL.Path {
+		case "mirror.example.com/o/r/HEAD/README.md":
+			w.Write([]byte("# R from the mirror\n\n![logo](logo.png)\n"))
+		case "mirror.example.com/o/r/HEAD/logo.png":
+			w.Write([]byte("logo"))
+		default:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	inTempDir(t)
+	var out strings.Builder
+	mirrorHosts, events, eventOut = listFlag{"https://mirror.example.com/{repo}/{ref}/{path}"}, true, &out
+	t.Cleanup(func() { mirrorHosts, events, eventOut = nil, false, os.Stdout })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# R from the mirror\n\n![logo](logo.png)\n" {
+		t.Errorf("saved %q, want the README of the mirror", content)
+	}
+	if !exists("r/logo.png") {
+		t.Error("the image was not read from the mirror")
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var done event
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &done); err != nil {
+		t.Fatal(err)
+	}
+	if done.Type != "repo_done" || done.Host != mirrorHosts[0] {
+		t.Errorf("the host that served the README was not recorded, the last event is %+v", done)
+	}
+}
+
+func TestGuessBranchOrder(t *testing.T) {
+	tests := []struct {
+		name                     string
+		preferMain, preferMaster bool
+		want                     []string
+	}{
+		{"prefer-main", true, false, []string{"main", "master"}},
+		{"prefer-master", true, true, []string{"master", "main"}},
+		{"no-prefer-main", false, false, []string{"master", "main"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				probed []string
+			)
+			serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				probed = append(probed, strings.Split(r.URL.Path, "/")[4])
+				http.NotFound(w, r)
+			}))
+			previousMain, previousMaster := preferMain, preferMaster
+			preferMain, preferMaster = tt.preferMain, tt.preferMaster
+			t.Cleanup(func() { preferMain, preferMaster = previousMain, previousMaster })
+
+			src := source{repo: mustRepo(t, "https://github.com/o/r"), readme: "README.md"}
+			reason := errors.New("git is not installed")
+			if _, err := guessBranch(context.Background(), src, reason); err != reason {
+				t.Fatalf("got %v, want the reason no branch was asked for", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(probed, tt.want) {
+				t.Errorf("probed %v, want %v", probed, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocsBranch(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":     "# Default\n",
+		"github.com/o/r/raw/gh-pages/README.md": "# Docs\n",
+	})
+	inTempDir(t)
+	var out strings.Builder
+	docsBranch, logOut = true, &out
+	t.Cleanup(func() { docsBranch, logOut = false, os.Stderr })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# Docs\n" {
+		t.Errorf("saved %q, want the README of gh-pages", content)
+	}
+	if !strings.Contains(out.String(), "reading https://github.com/o/r from its gh-pages branch, at README.md") {
+		t.Errorf("the branch used is not reported:\n%s", out.String())
+	}
+}
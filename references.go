@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// referenceEntry is where -dedup-manifest says an asset reference went.
+type referenceEntry struct {
+	URL  string `json:"url"`
+	Path string `json:"path"`
+}
+
+// referenceManifest maps every asset reference of the fetched documents,
+// as written in them, to the URL it was downloaded from and the local file
+// it was saved as. References that end up at one file share its entry.
+type referenceManifest struct {
+	mu   sync.Mutex
+	refs map[string]referenceEntry
+}
+
+func newReferenceManifest() *referenceManifest {
+	return &referenceManifest{refs: map[string]referenceEntry{}}
+}
+
+// add records ref. A nil manifest records nothing, so callers need not
+// check for -dedup-manifest.
+func (m *referenceManifest) add(ref, assetURL, local string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs[ref] = referenceEntry{URL: assetURL, Path: filepath.ToSlash(local)}
+}
+
+// alias records ref as saved wherever owner was, if owner was saved.
+func (m *referenceManifest) alias(ref, owner string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.refs[owner]; ok {
+		m.refs[ref] = entry
+	}
+}
+
+// write saves the manifest as references.json in dir.
+func (m *referenceManifest) write(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.MarshalIndent(m.refs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "references.json"), append(data, '\n'), 0644)
+}
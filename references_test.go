@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDedupManifest(t *testing.T) {
+	src := serveRepo(t, "# R\n\n![a](img/a.png)\n![again](./img/a.png)\n![b](img/b.png)\n", map[string]string{
+		"img/a.png": "a",
+		"img/b.png": "b",
+	})
+	dedupManifest = true
+	t.Cleanup(func() { dedupManifest = false })
+
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("r", "references.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs map[string]referenceEntry
+	if err := json.Unmarshal(data, &refs); err != nil {
+		t.Fatal(err)
+	}
+	a := referenceEntry{URL: "https://github.com/o/r/raw/HEAD/img/a.png", Path: "img/a.png"}
+	want := map[string]referenceEntry{
+		"img/a.png":   a,
+		"./img/a.png": a,
+		"img/b.png":   {URL: "https://github.com/o/r/raw/HEAD/img/b.png", Path: "img/b.png"},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("got %+v, want %+v", refs, want)
+	}
+}
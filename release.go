@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// releaseNotesFile is where -release-notes saves the notes of the latest
+// release.
+const releaseNotesFile = "RELEASE_NOTES.md"
+
+// fetchReleaseNotes saves the notes of the latest release of src next to
+// the README and downloads their images, returning their local paths.
+// Images uploaded to the release go under release-notes; relative
+// references are taken from the repository at the release's tag. A
+// repository without releases is only reported.
+func fetchReleaseNotes(ctx context.Context, src source) ([]string, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return nil, err
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+		Body    string `json:"body"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/releases/latest", owner, repo), &release)
+	if errors.Is(err, errNotFound) {
+		logf("%s/%s has no releases, no release notes to save\n", owner, repo)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the latest release of %s/%s: %v", owner, repo, err)
+	}
+	// bodies typed into the web form come with CRLF line endings
+	content := strings.ReplaceAll(release.Body, "\r\n", "\n")
+
+	// parsed before the images point at their local copies, which are
+	// not in the repository
+	parsed, err := goquery.NewDocumentFromReader(strings.NewReader(maskCode(content)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the release notes: %v", err)
+	}
+	refImages := referenceImages(content)
+
+	dir := src.dir()
+	saved := []string{}
+	local := map[string]string{}
+	for _, link := range absoluteImages(content) {
+		file, err := downloadImage(ctx, src, "release-notes", link)
+		if errors.Is(err, errAssetSkipped) {
+			warnf("", "%v", err)
+			continue
+		}
+		if err != nil {
+			if failFast {
+				return saved, err
+			}
+			fail(err)
+			continue
+		}
+		saved = append(saved, file)
+		local[html.UnescapeString(link)] = filepath.ToSlash(file)
+	}
+	if !keepUpstream {
+		content = localizeImages(content, local)
+	}
+
+	doc := src
+	doc.ref = release.TagName
+	doc.readme = releaseNotesFile
+	doc.codeAssets = codeBlockAssets(content)
+	doc.refImages = refImages
+	if !keepUpstream {
+		content = processReadme(content, doc)
+	}
+	err = os.WriteFile(filepath.Join(dir, releaseNotesFile), []byte(content), 0644)
+	if err != nil {
+		return saved, err
+	}
+	logf("saved the notes of release %s\n", release.TagName)
+
+	assets, err := downloadAssets(ctx, parsed, doc, nil)
+	return append(saved, assets...), err
+}
+
+// absoluteImages returns the http(s) URLs of the markdown images and <img>
+// tags of content, in order and without repeats.
+func absoluteImages(content string) []string {
+	found := []string{}
+	seen := map[string]bool{}
+	add := func(link string) {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[link] {
+			return
+		}
+		seen[link] = true
+		found = append(found, link)
+	}
+
+	source := []byte(content)
+	ast.Walk(mdRenderer.Parser().Parse(text.NewReader(source)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); ok && entering {
+			add(string(image.Destination))
+		}
+		return ast.WalkContinue, nil
+	})
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(maskCode(content))); err == nil {
+		doc.Find("img[src]").Each(func(_ int, s *goquery.Selection) {
+			add(s.AttrOr("src", ""))
+		})
+	}
+	return found
+}
+
+// localizeImages points the images of content whose links are keys of
+// local at their local copies: the targets of markdown images, of <img>
+// tags and of reference definitions. Links elsewhere, as in text or code,
+// are left alone.
+func localizeImages(content string, local map[string]string) string {
+	return mapProse(content, func(line string) string {
+		for _, re := range []*regexp.Regexp{mdImageRegex, imgSrcRegex, refTargetRegex} {
+			line = re.ReplaceAllStringFunc(line, func(match string) string {
+				parts := re.FindStringSubmatch(match)
+				if file, ok := local[html.UnescapeString(parts[2])]; ok {
+					return parts[1] + file
+				}
+				return match
+			})
+		}
+		return line
+	})
+}
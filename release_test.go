@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchReleaseNotes(t *testing.T) {
+	body := "![shot](https://example.com/shot.png)\r\n\r\n" +
+		"The full size is at https://example.com/shot.png.\r\n\r\n" +
+		"```\r\ncurl -O https://example.com/shot.png\r\n```\r\n\r\n" +
+		`<img src="https://example.com/badge.png?a=1&amp;b=2">` + "\r\n"
+	release, err := json.Marshal(map[string]string{"tag_name": "v1.0.0", "body": body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r/releases/latest": string(release),
+		"example.com/shot.png":                     "shot",
+		"example.com/badge.png":                    "badge",
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := os.MkdirAll(src.dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := fetchReleaseNotes(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("saved %v, want the two images", saved)
+	}
+	for _, file := range saved {
+		if _, err := os.Stat(filepath.Join(src.dir(), file)); err != nil {
+			t.Error(err)
+		}
+	}
+	notes, err := os.ReadFile(filepath.Join(src.dir(), releaseNotesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "![shot](" + filepath.ToSlash(saved[0]) + ")\n\n" +
+		"The full size is at https://example.com/shot.png.\n\n" +
+		"```\ncurl -O https://example.com/shot.png\n```\n\n" +
+		`<img src="` + filepath.ToSlash(saved[1]) + `">` + "\n"
+	if string(notes) != want {
+		t.Errorf("saved the notes as\n%s\nwant\n%s", notes, want)
+	}
+	if !strings.HasPrefix(filepath.ToSlash(saved[0]), "release-notes/shot-") {
+		t.Errorf("saved the image as %s", saved[0])
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// mdRenderer renders README markdown the way GitHub does in spirit: GFM
+// extensions on, and raw HTML passed through since READMEs rely on it.
+var mdRenderer = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+)
+
+// renderPage renders content into a standalone HTML page.
+func renderPage(title string, content []byte) ([]byte, error) {
+	body := new(bytes.Buffer)
+	err := mdRenderer.Convert(content, body)
+	if err != nil {
+		return nil, err
+	}
+
+	page := new(bytes.Buffer)
+	fmt.Fprintf(page, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(title))
+	page.Write(body.Bytes())
+	page.WriteString("</body>\n</html>\n")
+	return page.Bytes(), nil
+}
+
+// absoluteRefs points the local references of content, a document of src,
+// at the repository: links at their pages on GitHub and everything else at
+// the raw files, so the document works away from the output directory.
+func absoluteRefs(content string, src source) string {
+	if provider == "github" {
+		content = githubLinks(content, src)
+	}
+	return mapLocalRefs(content, func(ref string) string {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		link := src.rawURL(src.repoPath(u.Path))
+		if u.Fragment != "" {
+			link += "#" + u.Fragment
+		}
+		return link
+	})
+}
+
+// writePDF renders the saved README of dir to README.pdf with pdfEngine,
+// which is called as "<engine> <input.html> <output.pdf>". The HTML sits
+// next to the README so the engine finds the local images. Without the
+// engine installed, the PDF is skipped with a note.
+func writePDF(dir, title string) error {
+	engine, err := exec.LookPath(pdfEngine)
+	if err != nil {
+		logf("skipping the PDF, %s is not available\n", pdfEngine)
+		return nil
+	}
+
+	input := filepath.Join(dir, "README.html")
+	if !githubRendered {
+		content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+		if err != nil {
+			return err
+		}
+		page, err := renderPage(title, content)
+		if err != nil {
+			return fmt.Errorf("failed to render the README: %v", err)
+		}
+		input = filepath.Join(dir, ".README.pdf.html")
+		err = os.WriteFile(input, page, 0644)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(input)
+	}
+
+	out, err := exec.Command(engine, input, filepath.Join(dir, "README.pdf")).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", pdfEngine, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestWritePDF(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# R\n\nA README.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pdfEngine = "wkhtmltopdf" })
+
+	pdfEngine = "readtheirs-no-such-converter"
+	if err := writePDF(dir, "R"); err != nil {
+		t.Errorf("a missing converter failed the fetch: %v", err)
+	}
+	if exists(filepath.Join(dir, "README.pdf")) {
+		t.Error("wrote a PDF without a converter")
+	}
+
+	pdfEngine = "wkhtmltopdf"
+	if _, err := exec.LookPath(pdfEngine); err != nil {
+		t.Skipf("%s is not installed", pdfEngine)
+	}
+	if err := writePDF(dir, "R"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "README.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Fatal("README.pdf is not a PDF")
+	}
+	if pages := len(regexp.MustCompile(`/Type\s*/Page\b`).FindAll(data, -1)); pages != 1 {
+		t.Errorf("README.pdf has %d pages, want 1", pages)
+	}
+}
+
+func TestHTMLStdout(t *testing.T) {
+	src := serveRepo(t, "# R\n\n![logo](img/logo.png)\n", map[string]string{"img/logo.png": "logo"})
+	printReadme, htmlStdout = true, true
+	t.Cleanup(func() { printReadme, htmlStdout = false, false })
+	var err error
+	out := captureStdout(t, func() { err = fetchRepo(context.Background(), src) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "<!DOCTYPE html>") || doc.Find("h1").Text() != "R" {
+		t.Errorf("wrote %q, want an HTML page of the README", out)
+	}
+	if src, _ := doc.Find("img").Attr("src"); src != "https://github.com/o/r/raw/HEAD/img/logo.png" {
+		t.Errorf("the image points at %q, want its absolute URL", src)
+	}
+	if exists("r") {
+		t.Error("saved files with -html-stdout")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// getRenderedReadme fetches the README as GitHub renders it, points asset
+// tags that reference files of the same repository at their relative
+// paths, and saves the result as README.html.
+func getRenderedReadme(ctx context.Context, src *source) (*goquery.Document, error) {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// the API picks the README of a directory by itself
+	readmeURL := fmt.Sprintf("%s/repos/%s/%s/readme", strings.TrimSuffix(apiURL, "/"), owner, repo)
+	if readmeDir := path.Dir(src.readme); readmeDir != "." {
+		readmeURL += "/" + readmeDir
+	}
+	if src.ref != "HEAD" {
+		readmeURL += "?ref=" + url.QueryEscape(src.ref)
+	}
+	resp, err := getWith(ctx, readmeURL, http.Header{"Accept": {"application/vnd.github.html+json"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: the rendered README", errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve the rendered README, status code: %d", resp.StatusCode)
+	}
+
+	body, err := readLimited(resp.Body, resp.ContentLength, readmeMaxSize, "the rendered README")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the rendered README: %v", err)
+	}
+
+	repoFile := repoFileRegex(owner, repo)
+	doc.Find(assetSelector).Each(func(_ int, s *goquery.Selection) {
+		for _, attr := range []string{"src", "href"} {
+			value, exists := s.Attr(attr)
+			if !exists {
+				continue
+			}
+			if match := repoFile.FindStringSubmatch(value); match != nil {
+				s.SetAttr(attr, relativeTo(path.Dir(src.readme), strings.Replace(match[1], "?raw=true", "", -1)))
+			}
+		}
+	})
+
+	content, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(src.dir(), "README.html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create README.html file: %v", err)
+	}
+	defer f.Close()
+	_, err = io.WriteString(f, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write README.html file: %v", err)
+	}
+
+	return doc, nil
+}
+
+// relativeTo turns p, a path relative to the repository root, into a path
+// relative to dir.
+func relativeTo(dir, p string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(p))
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// repoFileRegex matches the URLs GitHub uses for files of owner/repo in
+// rendered HTML, capturing the path of the file within the repository.
+func repoFileRegex(owner, repo string) *regexp.Regexp {
+	slug := regexp.QuoteMeta(owner + "/" + repo)
+	return regexp.MustCompile(`^(?:https://github\.com/` + slug + `/(?:raw|blob)/[^/]+/|https://raw\.githubusercontent\.com/` + slug + `/[^/]+/|/` + slug + `/(?:raw|blob)/[^/]+/)(.+)$`)
+}
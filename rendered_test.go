@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchRendered(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r/readme": `<article><h1>R</h1>` +
+			`<p><img src="https://github.com/o/r/raw/main/docs/shot.png" alt="shot"></p></article>`,
+		"github.com/o/r/raw/HEAD/docs/shot.png": "image",
+	})
+	inTempDir(t)
+	githubRendered = true
+	t.Cleanup(func() { githubRendered = false })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `<img src="docs/shot.png" alt="shot"/>`) {
+		t.Errorf("the image was not pointed at its local file:\n%s", content)
+	}
+	if !exists("r/docs/shot.png") {
+		t.Error("the image of the rendered README was not downloaded")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// fetchReport collects what happened to the assets of one repository, for
+// the Markdown summary -report writes next to its README.
+type fetchReport struct {
+	mu       sync.Mutex
+	assets   []reportAsset
+	warnings []string
+}
+
+type reportAsset struct {
+	// path is where the asset was saved, or its reference in the README
+	// when it was not
+	path   string
+	size   int64
+	status string
+}
+
+var shaRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// saved records the asset written to local in dir. A nil report records
+// nothing, so callers need not check for -report.
+func (r *fetchReport) saved(dir, local, status string) {
+	if r == nil {
+		return
+	}
+	size := int64(-1)
+	if info, err := os.Stat(filepath.Join(dir, local)); err == nil {
+		size = info.Size()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assets = append(r.assets, reportAsset{filepath.ToSlash(local), size, status})
+}
+
+// missed records the asset that was not saved, with why as a warning.
+func (r *fetchReport) missed(asset, status string, why error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.assets = append(r.assets, reportAsset{asset, -1, status})
+	r.mu.Unlock()
+	r.warn("%v", why)
+}
+
+func (r *fetchReport) warn(format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+// writeReport saves the report of src as file in its output directory: the
+// source, the README, a table of the assets and the warnings of the fetch.
+func writeReport(ctx context.Context, src source, file string) error {
+	r := src.report
+	commit := "unknown"
+	if shaRegex.MatchString(src.ref) {
+		commit = src.ref
+	} else if provider == "github" {
+		sha, err := resolveCommit(ctx, src)
+		if err != nil {
+			r.warn("failed to resolve the commit of %s: %v", src.ref, err)
+		} else {
+			commit = sha
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "# Fetch report of %s\n\n", strings.Trim(src.repo.Path, "/"))
+	fmt.Fprintf(out, "## Source\n\n")
+	fmt.Fprintf(out, "- Repository: %s\n", src.repo)
+	fmt.Fprintf(out, "- Ref: `%s`\n", src.ref)
+	fmt.Fprintf(out, "- Commit: `%s`\n", commit)
+	if src.host != "" {
+		fmt.Fprintf(out, "- Mirror host: %s\n", src.host)
+	}
+	fmt.Fprintf(out, "- README: `%s`, saved as `%s`\n\n", src.readme, readmeName())
+
+	fmt.Fprintf(out, "## Assets\n\n")
+	if len(r.assets) == 0 {
+		fmt.Fprintf(out, "The README has no assets.\n\n")
+	} else {
+		fmt.Fprintf(out, "| Path | Size | Status |\n| --- | ---: | --- |\n")
+		for _, asset := range r.assets {
+			size := "-"
+			if asset.size >= 0 {
+				size = fmt.Sprintf("%d B", asset.size)
+			}
+			fmt.Fprintf(out, "| %s | %s | %s |\n", reportCell(asset.path), size, asset.status)
+		}
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "## Warnings\n\n")
+	if len(r.warnings) == 0 {
+		fmt.Fprintf(out, "None.\n")
+	}
+	for _, warning := range r.warnings {
+		fmt.Fprintf(out, "- %s\n", strings.ReplaceAll(warning, "\n", " "))
+	}
+	return os.WriteFile(filepath.Join(src.dir(), file), []byte(out.String()), 0644)
+}
+
+// reportCell writes a path as code in a table cell, where a | would end the
+// cell.
+func reportCell(p string) string {
+	return "`" + strings.ReplaceAll(p, "|", `\|`) + "`"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/" + sha + "/README.md":    "![logo](img/logo.png)\n![gone](img/gone.png)\n",
+		"github.com/o/r/raw/" + sha + "/img/logo.png": "logo",
+	})
+	inTempDir(t)
+	reportFile = "REPORT.md"
+	t.Cleanup(func() { reportFile, runFailed = "", false })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: sha, readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "REPORT.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(content)
+	for _, want := range []string{
+		"# Fetch report of o/r\n",
+		"## Source\n",
+		"- Commit: `" + sha + "`\n",
+		"- README: `README.md`, saved as `README.md`\n",
+		"## Assets\n",
+		"| Path | Size | Status |\n",
+		"| `img/logo.png` | 4 B | downloaded |\n",
+		"| `img/gone.png` | - | failed |\n",
+		"## Warnings\n",
+		"- unexpected status code 404 for https://github.com/o/r/raw/" + sha + "/img/gone.png\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report lacks %q:\n%s", want, report)
+		}
+	}
+}
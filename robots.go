@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// robotsAgent is the user agent robots.txt groups can name to address the
+// tool, besides *.
+const robotsAgent = "readtheirs"
+
+// robotsRule is an Allow or Disallow line of robots.txt, its path pattern
+// compiled with * and a trailing $ as wildcards.
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// robotsEntry is the robots.txt of a host, read once for all the workers
+// that ask about the host, however many ask at the same time.
+type robotsEntry struct {
+	once  sync.Once
+	rules []robotsRule
+	err   error
+}
+
+var (
+	// robotsCache holds an entry for every host whose robots.txt was asked
+	// about, by scheme and host, so each is only read once a run
+	robotsCache   = map[string]*robotsEntry{}
+	robotsCacheMu sync.Mutex
+)
+
+// robotsAllowed reports whether the robots.txt of the host of link lets
+// the tool fetch it. Hosts without a robots.txt allow everything.
+func robotsAllowed(ctx context.Context, link string) (bool, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false, err
+	}
+	site := u.Scheme + "://" + u.Host
+	robotsCacheMu.Lock()
+	entry, ok := robotsCache[site]
+	if !ok {
+		entry = &robotsEntry{}
+		robotsCache[site] = entry
+	}
+	robotsCacheMu.Unlock()
+	// the workers that come while it is read wait for it
+	entry.once.Do(func() {
+		entry.rules, entry.err = fetchRobots(ctx, site)
+	})
+	if entry.err != nil {
+		return false, entry.err
+	}
+	rules := entry.rules
+
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	// the longest matching rule wins, Allow on a tie
+	allowed, longest := true, -1
+	for _, rule := range rules {
+		if !rule.pattern.MatchString(target) {
+			continue
+		}
+		if rule.length > longest || rule.length == longest && rule.allow {
+			allowed, longest = rule.allow, rule.length
+		}
+	}
+	return allowed, nil
+}
+
+// fetchRobots reads the robots.txt of site. A missing file allows
+// everything; a server error is an error, rather than a guess either way.
+func fetchRobots(ctx context.Context, site string) ([]robotsRule, error) {
+	resp, err := get(ctx, site+"/robots.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the robots.txt of %s: %v", site, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read the robots.txt of %s, status code: %d", site, resp.StatusCode)
+	}
+	return parseRobots(bufio.NewScanner(resp.Body))
+}
+
+// parseRobots returns the rules of the groups of a robots.txt that apply
+// to robotsAgent: the groups naming it when there are any, the * groups
+// otherwise.
+func parseRobots(scanner *bufio.Scanner) ([]robotsRule, error) {
+	var (
+		named, anyone []robotsRule
+		agents        []string
+		inRules       bool
+		// whether any group names robotsAgent, even without rules
+		isNamed bool
+	)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field, value = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(value)
+		switch field {
+		case "user-agent":
+			// consecutive user-agent lines share the rules that follow
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agent := strings.ToLower(value)
+			agents = append(agents, agent)
+			isNamed = isNamed || strings.Contains(agent, robotsAgent)
+		case "allow", "disallow":
+			inRules = true
+			// an empty Disallow allows everything, which is the default
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{allow: field == "allow", length: len(value), pattern: robotsPattern(value)}
+			for _, agent := range agents {
+				switch {
+				case strings.Contains(agent, robotsAgent):
+					named = append(named, rule)
+				case agent == "*":
+					anyone = append(anyone, rule)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if isNamed {
+		return named, nil
+	}
+	return anyone, nil
+}
+
+// robotsPattern compiles a robots.txt path, which matches the paths it is
+// a prefix of, with * standing for any run of characters and a trailing $
+// anchoring it at the end.
+func robotsPattern(value string) *regexp.Regexp {
+	anchored := strings.HasSuffix(value, "$")
+	value = strings.TrimSuffix(value, "$")
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRespectRobots(t *testing.T) {
+	image := pngImage(t, 1, 1)
+	var (
+		mu     sync.Mutex
+		robots int
+		served []string
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			robots++
+			w.Write([]byte("User-agent: *\nDisallow: /private/\n"))
+			return
+		}
+		served = append(served, r.URL.Path)
+		w.Write([]byte(image))
+	}))
+	inTempDir(t)
+	respectRobots = true
+	t.Cleanup(func() {
+		respectRobots = false
+		robotsCacheMu.Lock()
+		robotsCache = map[string]*robotsEntry{}
+		robotsCacheMu.Unlock()
+	})
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	_, err := downloadImage(context.Background(), src, "images", "https://example.com/private/a.png")
+	if !errors.Is(err, errAssetSkipped) {
+		t.Errorf("got %v for a disallowed image, want it skipped", err)
+	}
+	if _, err := downloadImage(context.Background(), src, "images", "https://example.com/public/b.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(served) != 1 || served[0] != "/public/b.png" {
+		t.Errorf("served %v, want only the allowed image", served)
+	}
+	if robots != 1 {
+		t.Errorf("read robots.txt %d times, want once for the host", robots)
+	}
+}
+
+func TestRobotsReadOnceConcurrently(t *testing.T) {
+	var robots int32
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&robots, 1)
+		// slow enough for every worker to ask while it is read
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("User-agent: *\nDisallow: /private/\n"))
+	}))
+	t.Cleanup(func() {
+		robotsCacheMu.Lock()
+		robotsCache = map[string]*robotsEntry{}
+		robotsCacheMu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allowed, err := robotsAllowed(context.Background(), "https://example.com/private/a.png")
+			if err != nil || allowed {
+				t.Errorf("got %v, %v for a disallowed path, want false, nil", allowed, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&robots); n != 1 {
+		t.Errorf("read robots.txt %d times for concurrent workers, want once", n)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// searchResults is how many repositories the search subcommand lists.
+const searchResults = 10
+
+// runSearch looks repositories up with GitHub's search API, lists the most
+// starred ones and fetches the README of the top result with -top, or of
+// the one picked on stdin otherwise.
+func runSearch(ctx context.Context, query string) error {
+	if query == "" {
+		return fmt.Errorf("missing search query")
+	}
+
+	var result struct {
+		Items []struct {
+			FullName    string `json:"full_name"`
+			HTMLURL     string `json:"html_url"`
+			Description string `json:"description"`
+			Stars       int    `json:"stargazers_count"`
+		} `json:"items"`
+	}
+	params := url.Values{"q": {query}, "sort": {"stars"}, "per_page": {strconv.Itoa(searchResults)}}
+	err := apiGet(ctx, "/search/repositories?"+params.Encode(), &result)
+	if err != nil {
+		return fmt.Errorf("failed to search for %q: %v", query, err)
+	}
+	if len(result.Items) == 0 {
+		return fmt.Errorf("no repository matches %q", query)
+	}
+
+	for i, item := range result.Items {
+		fmt.Printf("%2d. %s (%d stars) %s\n", i+1, item.FullName, item.Stars, item.Description)
+	}
+
+	pick := 1
+	if !searchTop {
+		fmt.Printf("pick a repository [1-%d]: ", len(result.Items))
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		pick, err = strconv.Atoi(line)
+		if err != nil || pick < 1 || pick > len(result.Items) {
+			return fmt.Errorf("%q is not one of the listed repositories", line)
+		}
+	}
+
+	u, err := parseRepoLink(result.Items[pick-1].HTMLURL)
+	if err != nil {
+		return err
+	}
+	logf("fetching %s\n", result.Items[pick-1].FullName)
+	return fetchRepo(ctx, source{repo: u, ref: branchName, readme: "README.md"})
+}
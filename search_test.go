@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearchTop(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host + r.URL.Path {
+		case "api.github.com/search/repositories":
+			if q := r.URL.Query(); q.Get("q") != "markdown mirror" || q.Get("sort") != "stars" {
+				t.Errorf("searched with %v", q)
+			}
+			w.Write([]byte(`{"items": [
+				{"full_name": "o/top", "html_url": "https://github.com/o/top", "stargazers_count": 900},
+				{"full_name": "o/second", "html_url": "https://github.com/o/second", "stargazers_count": 10}
+			]}`))
+		case "github.com/o/top/raw/HEAD/README.md":
+			w.Write([]byte("# Top\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	inTempDir(t)
+	searchTop, branchName = true, "HEAD"
+	t.Cleanup(func() { searchTop = false })
+
+	var err error
+	out := captureStdout(t, func() { err = runSearch(context.Background(), "markdown mirror") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, " 1. o/top (900 stars)") || !strings.Contains(out, " 2. o/second (10 stars)") {
+		t.Errorf("listed\n%s", out)
+	}
+	content, err := os.ReadFile(filepath.Join("top", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# Top\n" {
+		t.Errorf("saved %q, not the README of the top result", content)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readmeCache keeps the READMEs served by the serve subcommand for
+// -serve-ttl, keyed by owner/repo@ref. Past serveCacheEntries READMEs, the
+// least recently served one is dropped.
+type readmeCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the keys, the most recently served first
+	order *list.List
+}
+
+// serveCacheEntries bounds how many READMEs serve keeps in memory.
+const serveCacheEntries = 256
+
+type cachedReadme struct {
+	key     string
+	src     source
+	content string
+	fetched time.Time
+}
+
+func newReadmeCache() *readmeCache {
+	return &readmeCache{entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *readmeCache) get(key string) (cachedReadme, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return cachedReadme{}, false
+	}
+	entry := elem.Value.(cachedReadme)
+	if time.Since(entry.fetched) > serveTTL {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return cachedReadme{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *readmeCache) put(key string, entry cachedReadme) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.key = key
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > serveCacheEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cachedReadme).key)
+	}
+}
+
+// runServe answers GET /{owner}/{repo}?ref=... with the processed README of
+// the repository, as markdown or, with format=html, as an HTML page whose
+// references point at the repository.
+func runServe(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":8080"
+	}
+	server := &http.Server{
+		Addr:    addr,
+		Handler: readmeHandler(newReadmeCache()),
+		// slow or idle clients must not hold connections forever; writing
+		// covers fetching the README from GitHub
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logf("serving READMEs on %s\n", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func readmeHandler(cache *readmeCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			http.Error(w, "expected /{owner}/{repo}", http.StatusNotFound)
+			return
+		}
+		ref := r.URL.Query().Get("ref")
+		if ref == "" {
+			ref = branchName
+		}
+		if err := checkRef(ref); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key := fmt.Sprintf("%s/%s@%s", parts[0], parts[1], ref)
+		entry, ok := cache.get(key)
+		if !ok {
+			repo := &url.URL{Scheme: "https", Host: "github.com", Path: "/" + parts[0] + "/" + parts[1]}
+			src := source{repo: repo, ref: ref, readme: "README.md"}
+			_, content, err := getDocument(r.Context(), &src, "")
+			if errors.Is(err, errNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				logf("%s: %v\n", key, err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			entry = cachedReadme{src: src, content: content, fetched: time.Now()}
+			cache.put(key, entry)
+		}
+
+		body := []byte(entry.content)
+		contentType := "text/markdown; charset=utf-8"
+		if r.URL.Query().Get("format") == "html" {
+			// the page is read from this server, where the relative
+			// references of the README lead nowhere
+			page, err := renderPage(key, []byte(absoluteRefs(entry.content, entry.src)))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			body, contentType = page, "text/html; charset=utf-8"
+		}
+
+		etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+		age := time.Since(entry.fetched)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int((serveTTL-age).Seconds())))
+		w.Header().Set("Last-Modified", entry.fetched.UTC().Format(http.TimeFormat))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(body)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeReadme(t *testing.T) {
+	fetches := 0
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host+r.URL.Path != "github.com/o/r/raw/main/README.md" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Write([]byte("# R\n\n![logo](img/logo.png?raw=true)\n"))
+	}))
+	ttl, branch := serveTTL, branchName
+	serveTTL, branchName = time.Minute, "HEAD"
+	t.Cleanup(func() { serveTTL, branchName = ttl, branch })
+	gateway := httptest.NewServer(readmeHandler(newReadmeCache()))
+	defer gateway.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(gateway.URL + "/o/r?ref=main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "# R\n\n![logo](img/logo.png)\n" {
+			t.Errorf("got %d %q", resp.StatusCode, body)
+		}
+		if !strings.HasPrefix(resp.Header.Get("Cache-Control"), "public, max-age=") || resp.Header.Get("ETag") == "" {
+			t.Errorf("missing cache headers: %v", resp.Header)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched the README %d times, want it cached after once", fetches)
+	}
+
+	resp, err := http.Get(gateway.URL + "/o/r?ref=main&format=html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `src="https://github.com/o/r/raw/main/img/logo.png"`; !strings.Contains(string(page), want) {
+		t.Errorf("the HTML page %q does not contain %s", page, want)
+	}
+}
+
+func TestReadmeCacheEviction(t *testing.T) {
+	ttl := serveTTL
+	serveTTL = time.Minute
+	t.Cleanup(func() { serveTTL = ttl })
+	cache := newReadmeCache()
+	for i := 0; i < serveCacheEntries; i++ {
+		cache.put(fmt.Sprintf("o/r%d@HEAD", i), cachedReadme{fetched: time.Now()})
+	}
+	// served again, so the next put drops r1 rather than r0
+	if _, ok := cache.get("o/r0@HEAD"); !ok {
+		t.Fatal("o/r0@HEAD is not cached")
+	}
+	cache.put("o/new@HEAD", cachedReadme{fetched: time.Now()})
+	if _, ok := cache.get("o/r1@HEAD"); ok {
+		t.Error("the least recently served README was kept")
+	}
+	for _, key := range []string{"o/r0@HEAD", "o/new@HEAD"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s was dropped", key)
+		}
+	}
+	if len(cache.entries) != serveCacheEntries || cache.order.Len() != serveCacheEntries {
+		t.Errorf("holding %d READMEs, want %d", len(cache.entries), serveCacheEntries)
+	}
+
+	cache.put("o/old@HEAD", cachedReadme{fetched: time.Now().Add(-2 * time.Minute)})
+	if _, ok := cache.get("o/old@HEAD"); ok {
+		t.Error("an expired README was served")
+	}
+	if _, ok := cache.entries["o/old@HEAD"]; ok {
+		t.Error("an expired README was kept")
+	}
+}
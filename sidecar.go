@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// sidecarSuffix is appended to an asset's file name for its -sidecar file.
+const sidecarSuffix = ".meta.json"
+
+// sidecar records where a downloaded asset came from, next to the asset.
+type sidecar struct {
+	Source      string    `json:"source"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	ETag        string    `json:"etag,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// writeSidecar saves the provenance of the asset at file as file.meta.json.
+func writeSidecar(file, source, contentType, etag string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sidecar{
+		Source:      source,
+		ContentType: contentType,
+		Size:        info.Size(),
+		ETag:        etag,
+		FetchedAt:   time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file+sidecarSuffix, append(data, '\n'), 0644)
+}
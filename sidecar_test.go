@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSidecar(t *testing.T) {
+	assets := map[string]struct{ body, contentType string }{
+		"/o/r/raw/HEAD/img/logo.png":   {"logo", "image/png"},
+		"/o/r/raw/HEAD/docs/guide.pdf": {"a guide", "application/pdf"},
+	}
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/o/r/raw/HEAD/README.md" {
+			w.Write([]byte("![logo](img/logo.png)\n<link href=\"docs/guide.pdf\">\n"))
+			return
+		}
+		asset, ok := assets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", asset.contentType)
+		w.Header().Set("ETag", `"`+filepath.Base(r.URL.Path)+`"`)
+		w.Write([]byte(asset.body))
+	}))
+	inTempDir(t)
+	withSidecar = true
+	t.Cleanup(func() { withSidecar = false })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	before := time.Now().UTC()
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	for upstream, asset := range assets {
+		local := filepath.Join("r", filepath.FromSlash(strings.TrimPrefix(upstream, "/o/r/raw/HEAD/")))
+		data, err := os.ReadFile(local + sidecarSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got sidecar
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := sidecar{
+			Source:      "https://github.com" + upstream,
+			ContentType: asset.contentType,
+			Size:        int64(len(asset.body)),
+			ETag:        `"` + filepath.Base(upstream) + `"`,
+			FetchedAt:   got.FetchedAt,
+		}
+		if got != want {
+			t.Errorf("the sidecar of %s is %+v, want %+v", local, got, want)
+		}
+		if got.FetchedAt.Before(before) {
+			t.Errorf("the sidecar of %s was fetched at %v, before the fetch started", local, got.FetchedAt)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// mdImageRegex matches the target of an inline markdown image
+	mdImageRegex = regexp.MustCompile(`(!\[[^\]]*\]\([ \t]*<?)([^)\s>]+)`)
+	// imgSrcRegex matches the src of an HTML <img> tag
+	imgSrcRegex = regexp.MustCompile(`(<img\b[^>]*?\bsrc=["'])([^"']+)`)
+)
+
+// singleFileName is where -single-file-md saves the README with its images
+// embedded, next to the README itself.
+func singleFileName() string {
+	name := readmeName()
+	return strings.TrimSuffix(name, path.Ext(name)) + ".single.md"
+}
+
+// writeSingleFile saves a copy of the README in dir whose local images are
+// embedded as data URIs, so the one file carries everything it shows.
+// Images larger than limit, when set, stay references to their files.
+func writeSingleFile(dir string, limit byteSize) error {
+	content, err := os.ReadFile(filepath.Join(dir, readmeName()))
+	if err != nil {
+		return err
+	}
+	embedded := map[string]string{}
+	embed := func(ref string) string {
+		if !isLocalRef(ref) {
+			return ref
+		}
+		if uri, ok := embedded[ref]; ok {
+			return uri
+		}
+		uri, err := dataURI(dir, ref, limit)
+		if err != nil {
+			logf("not embedding %s in %s: %v\n", ref, singleFileName(), err)
+			uri = ref
+		}
+		embedded[ref] = uri
+		return uri
+	}
+
+	images := map[string]bool{}
+	for _, target := range referenceImages(string(content)) {
+		images[target] = true
+	}
+	single := mapProse(string(content), func(line string) string {
+		for _, re := range []*regexp.Regexp{mdImageRegex, imgSrcRegex} {
+			line = re.ReplaceAllStringFunc(line, func(match string) string {
+				parts := re.FindStringSubmatch(match)
+				return parts[1] + embed(parts[2])
+			})
+		}
+		return refTargetRegex.ReplaceAllStringFunc(line, func(match string) string {
+			parts := refTargetRegex.FindStringSubmatch(match)
+			if !images[parts[2]] {
+				return match
+			}
+			return parts[1] + embed(parts[2])
+		})
+	})
+	return os.WriteFile(filepath.Join(dir, singleFileName()), []byte(single), 0644)
+}
+
+// dataURI reads the image ref points at in dir and returns it as a base64
+// data URI. Files that are not images, or larger than limit, are refused.
+func dataURI(dir, ref string, limit byteSize) (string, error) {
+	file := filepath.Join(dir, filepath.FromSlash(assetFile(ref)))
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if limit > 0 && info.Size() > int64(limit) {
+		return "", fmt.Errorf("it is larger than %s", limit.String())
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(file)))
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("it is %s, not an image", contentType)
+	}
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
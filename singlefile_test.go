@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSingleFile(t *testing.T) {
+	logo, photo := pngImage(t, 2, 2), pngImage(t, 64, 64)
+	src := serveRepo(t, "# R\n\n![logo](img/logo.png)\n<img src=\"img/photo.png\">\n![remote](https://example.com/x.png)\n", map[string]string{
+		"img/logo.png":  logo,
+		"img/photo.png": photo,
+	})
+	singleFile = true
+	singleFileMax = byteSize(len(logo))
+	t.Cleanup(func() { singleFile, singleFileMax = false, byteSize(1<<20) })
+
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.single.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := string(content)
+	if uri := "![logo](data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte(logo)) + ")"; !strings.Contains(single, uri) {
+		t.Errorf("the logo is not embedded:\n%s", single)
+	}
+	// larger than -single-file-max-image
+	if !strings.Contains(single, `<img src="img/photo.png">`) {
+		t.Errorf("the photo is not left a reference:\n%s", single)
+	}
+	if !strings.Contains(single, "![remote](https://example.com/x.png)") {
+		t.Errorf("the remote image was changed:\n%s", single)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// snapshotDir names the -snapshot directory of src after a hash of its
+// repository and the commit its ref resolves to, so every commit gets its
+// own directory and fetching one again lands in the same place. The commit
+// is returned too, for the fetch to read exactly what it is named after.
+func snapshotDir(ctx context.Context, src source) (string, string, error) {
+	commit := src.ref
+	if !shaRegex.MatchString(commit) {
+		var err error
+		commit, err = resolveCommit(ctx, src)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to resolve %s for -snapshot: %v", src.ref, err)
+		}
+	}
+	sum := sha256.Sum256([]byte(src.repo.Host + src.repo.Path + "@" + commit))
+	return hex.EncodeToString(sum[:8]), commit, nil
+}
+
+// pointLatest makes latest, next to the snapshots of src, point at its
+// snapshot. Where symlinks cannot be made, latest is a file holding the
+// snapshot's name instead.
+func pointLatest(src source) error {
+	latest := filepath.Join(src.repoDir(), "latest")
+	os.Remove(latest)
+	if os.Symlink(src.snapshot, latest) == nil {
+		return nil
+	}
+	return os.WriteFile(latest, []byte(src.snapshot+"\n"), 0644)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	first, second := "1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"
+	var (
+		mu   sync.Mutex
+		head = first
+	)
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Host + r.URL.Path {
+		case "api.github.com/repos/o/r/commits/HEAD":
+			w.Write([]byte(`{"sha": "` + head + `"}`))
+		case "github.com/o/r/raw/" + first + "/README.md":
+			w.Write([]byte("# First\n"))
+		case "github.com/o/r/raw/" + second + "/README.md":
+			w.Write([]byte("# Second\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	inTempDir(t)
+	snapshot = true
+	t.Cleanup(func() { snapshot = false })
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+
+	names := map[string]string{}
+	for _, commit := range []string{first, second} {
+		mu.Lock()
+		head = commit
+		mu.Unlock()
+		if err := fetchRepo(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		name, _, err := snapshotDir(context.Background(), source{repo: src.repo, ref: commit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[commit] = name
+	}
+
+	if names[first] == names[second] {
+		t.Fatalf("both commits were saved to %s", names[first])
+	}
+	for commit, want := range map[string]string{first: "# First\n", second: "# Second\n"} {
+		content, err := os.ReadFile(filepath.Join("r", names[commit], "README.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("the snapshot of %s holds %q, want %q", commit, content, want)
+		}
+	}
+	latest, err := os.ReadFile(filepath.Join("r", "latest", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != "# Second\n" {
+		t.Errorf("latest holds %q, want the second snapshot", latest)
+	}
+}
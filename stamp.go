@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stampFile records where the README of a fetch was read from, next to it.
+const stampFile = "stamp.json"
+
+// fetchStamp is the content of stamp.json.
+type fetchStamp struct {
+	Repo string `json:"repo"`
+	// Ref is the ref the README was read at, once -at and the like
+	// resolved it
+	Ref string `json:"ref"`
+	// ReleaseTag is the tag of the release -latest-release resolved
+	ReleaseTag string `json:"release_tag,omitempty"`
+	// Commit is the commit -at resolved, the last one before At
+	Commit    string     `json:"commit,omitempty"`
+	At        *time.Time `json:"at,omitempty"`
+	FetchedAt time.Time  `json:"fetched_at"`
+	// Files maps the README and the files saved with it, relative to the
+	// directory, to the sha256 of their content, for validate to check
+	Files map[string]string `json:"files"`
+}
+
+// writeStamp saves stamp as the stamp.json of dir, with the checksums of
+// files, paths relative to dir. Files that are not there, as the README
+// of a rendered fetch, are left out. A stamp.json that only differs in the
+// time of the fetch is kept, so fetching an unchanged README changes
+// nothing.
+func writeStamp(dir string, stamp fetchStamp, files []string) error {
+	stamp.Files = map[string]string{}
+	for _, file := range files {
+		sum, err := fileSum(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stamp.Files[filepath.ToSlash(file)] = sum
+	}
+	if current, err := os.ReadFile(filepath.Join(dir, stampFile)); err == nil {
+		var previous fetchStamp
+		if json.Unmarshal(current, &previous) == nil {
+			stamp.FetchedAt = previous.FetchedAt
+			data, err := json.MarshalIndent(stamp, "", "  ")
+			if err == nil && bytes.Equal(current, append(data, '\n')) {
+				return nil
+			}
+		}
+	}
+	stamp.FetchedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(stamp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, stampFile), append(data, '\n'), 0644)
+}
+
+// readStamp reads the stamp.json of dir.
+func readStamp(dir string) (fetchStamp, error) {
+	var stamp fetchStamp
+	data, err := os.ReadFile(filepath.Join(dir, stampFile))
+	if err != nil {
+		return stamp, err
+	}
+	return stamp, json.Unmarshal(data, &stamp)
+}
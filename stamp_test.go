@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchAt(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host + r.URL.Path {
+		case "api.github.com/repos/o/r/commits":
+			if until := r.URL.Query().Get("until"); until != "2021-06-01T00:00:00Z" {
+				t.Errorf("asked for the commits until %q", until)
+			}
+			w.Write([]byte(`[{"sha": "0123abcd"}]`))
+		case "github.com/o/r/raw/0123abcd/README.md":
+			w.Write([]byte("# At the time\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	inTempDir(t)
+	var err error
+	atTime, err = time.Parse(time.RFC3339, "2021-06-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { atTime = time.Time{} })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# At the time\n" {
+		t.Errorf("saved %q, not the README at the resolved commit", content)
+	}
+	stamp, err := readStamp("r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stamp.Ref != "0123abcd" || stamp.Commit != "0123abcd" || stamp.At == nil || !stamp.At.Equal(atTime) {
+		t.Errorf("stamped %+v, want the resolved commit 0123abcd at %s", stamp, atTime)
+	}
+}
+
+func TestFetchLatestRelease(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"api.github.com/repos/o/r/releases/latest": `{"tag_name": "v1.2.0"}`,
+		"github.com/o/r/raw/v1.2.0/README.md":      "# Released\n",
+	})
+	inTempDir(t)
+	useLatestRelease = true
+	t.Cleanup(func() { useLatestRelease = false })
+
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join("r", "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "# Released\n" {
+		t.Errorf("saved %q, not the README at the latest release", content)
+	}
+	stamp, err := readStamp("r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stamp.Ref != "v1.2.0" || stamp.ReleaseTag != "v1.2.0" {
+		t.Errorf("stamped %+v, want the release tag v1.2.0", stamp)
+	}
+}
+
+func TestWriteStampUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# R\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stamp := fetchStamp{Repo: "https://github.com/o/r", Ref: "main"}
+	if err := writeStamp(dir, stamp, []string{"README.md"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := readStamp(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := writeStamp(dir, stamp, []string{"README.md"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := readStamp(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.FetchedAt.Equal(first.FetchedAt) {
+		t.Error("rewrote stamp.json for a fetch that changed nothing")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# R, changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStamp(dir, stamp, []string{"README.md"}); err != nil {
+		t.Fatal(err)
+	}
+	third, err := readStamp(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !third.FetchedAt.After(first.FetchedAt) || third.Files["README.md"] == first.Files["README.md"] {
+		t.Error("kept the stamp.json of a README that changed")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+)
+
+// maxLinkHops bounds the chain of symlinks followed to reach a document.
+const maxLinkHops = 5
+
+// readFollowingLinks reads the document of src, following it where it is a
+// git symlink. The raw endpoint serves a symlink as the text of its target,
+// so src.readme is pointed at the file the link resolves to.
+func readFollowingLinks(ctx context.Context, src *source) ([]byte, error) {
+	body, err := readFromHosts(ctx, src)
+	for hops := 0; err == nil; hops++ {
+		target, ok := symlinkTarget(ctx, *src, body)
+		if !ok {
+			return body, nil
+		}
+		if hops == maxLinkHops {
+			return nil, fmt.Errorf("too many levels of symlinks at %s", src.readme)
+		}
+		logf("%s is a symlink to %s\n", src.readme, target)
+		src.readme = target
+		body, err = readDocument(ctx, *src)
+	}
+	return nil, err
+}
+
+// symlinkTarget returns the repository path the document of src links to
+// when the git tree lists it with the symlink mode, 120000. The raw body
+// of a symlink is its target, so the tree is only asked about bodies that
+// could be one, a single line of a path's length. Targets outside the
+// repository are not followed.
+func symlinkTarget(ctx context.Context, src source, body []byte) (string, bool) {
+	if provider != "github" || len(body) == 0 || len(body) > 1024 || bytes.ContainsAny(body, "\n\x00") {
+		return "", false
+	}
+	if !isSymlink(ctx, src) {
+		return "", false
+	}
+	link := string(body)
+	if path.IsAbs(link) {
+		return "", false
+	}
+	target := path.Join(path.Dir(src.readme), link)
+	if climbs(target) {
+		return "", false
+	}
+	return target, true
+}
+
+// isSymlink reports whether the document of src is a symlink, going by the
+// mode of its entry in the tree of its directory. Only that directory is
+// listed, not the whole repository. When the tree cannot be listed, the
+// document is taken for a regular file.
+func isSymlink(ctx context.Context, src source) bool {
+	owner, repo, err := repoSlug(src.repo)
+	if err != nil {
+		return false
+	}
+	treeish := src.ref
+	if dir := path.Dir(src.readme); dir != "." {
+		treeish += ":" + dir
+	}
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Mode string `json:"mode"`
+		} `json:"tree"`
+	}
+	err = apiGet(ctx, fmt.Sprintf("/repos/%s/%s/git/trees/%s", owner, repo, escapePath(treeish)), &tree)
+	if err != nil {
+		return false
+	}
+	name := path.Base(src.readme)
+	for _, entry := range tree.Tree {
+		if entry.Path == name {
+			return entry.Mode == "120000"
+		}
+	}
+	return false
+}
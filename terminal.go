@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe
+// or a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// renderTerminal lays markdown out for reading in a terminal: headings, lists,
+// quotes, code blocks and tables are indented and marked, and images become
+// placeholders with their alt text and URL. With styled set, ANSI escapes
+// add bold, italics, underlines and colors.
+func renderTerminal(content string, styled bool) string {
+	source := []byte(content)
+	doc := mdRenderer.Parser().Parse(text.NewReader(source))
+	r := terminalRenderer{source: source, styled: styled}
+	return r.blocks(doc)
+}
+
+type terminalRenderer struct {
+	source []byte
+	styled bool
+}
+
+func (r terminalRenderer) style(code, s string) string {
+	if !r.styled || s == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// blocks renders the block children of parent, separated by blank lines.
+func (r terminalRenderer) blocks(parent ast.Node) string {
+	out := []string{}
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		if block := r.block(n); block != "" {
+			out = append(out, block)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func (r terminalRenderer) block(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		if !r.styled {
+			return strings.Repeat("#", n.Level) + " " + r.inline(n) + "\n"
+		}
+		code := "1"
+		if n.Level == 1 {
+			code = "1;4"
+		}
+		return r.style(code, r.inline(n)) + "\n"
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inline(n) + "\n"
+	case *ast.FencedCodeBlock, *ast.CodeBlock, *ast.HTMLBlock:
+		lines := new(strings.Builder)
+		for i := 0; i < n.Lines().Len(); i++ {
+			line := n.Lines().At(i)
+			lines.WriteString("    " + r.style("36", strings.TrimRight(string(line.Value(r.source)), "\r\n")) + "\n")
+		}
+		if html, ok := n.(*ast.HTMLBlock); ok && html.HasClosure() {
+			closure := html.ClosureLine
+			lines.WriteString("    " + r.style("36", strings.TrimRight(string(closure.Value(r.source)), "\r\n")) + "\n")
+		}
+		return lines.String()
+	case *ast.List:
+		items := new(strings.Builder)
+		number := n.Start
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := "• "
+			if n.IsOrdered() {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+			body := r.blocks(item)
+			if n.IsTight {
+				body = strings.ReplaceAll(body, "\n\n", "\n")
+			}
+			items.WriteString(indent(body, marker, strings.Repeat(" ", len([]rune(marker)))))
+		}
+		return items.String()
+	case *ast.Blockquote:
+		return indent(r.blocks(n), r.style("2", "│ "), r.style("2", "│ "))
+	case *ast.ThematicBreak:
+		return r.style("2", strings.Repeat("─", 40)) + "\n"
+	case *extast.Table:
+		rows := new(strings.Builder)
+		for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+			cells := []string{}
+			for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+				cells = append(cells, r.inline(cell))
+			}
+			line := strings.Join(cells, r.style("2", " │ "))
+			if _, header := row.(*extast.TableHeader); header {
+				line = r.style("1", strings.Join(cells, " │ "))
+			}
+			rows.WriteString(line + "\n")
+		}
+		return rows.String()
+	}
+	return r.blocks(n)
+}
+
+// inline renders the inline children of n as one run of text.
+func (r terminalRenderer) inline(n ast.Node) string {
+	out := new(strings.Builder)
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.Text:
+			out.Write(c.Segment.Value(r.source))
+			if c.HardLineBreak() {
+				out.WriteString("\n")
+			} else if c.SoftLineBreak() {
+				out.WriteString(" ")
+			}
+		case *ast.String:
+			out.Write(c.Value)
+		case *ast.CodeSpan:
+			out.WriteString(r.style("36", r.inline(c)))
+		case *ast.Emphasis:
+			code := "3"
+			if c.Level == 2 {
+				code = "1"
+			}
+			out.WriteString(r.style(code, r.inline(c)))
+		case *extast.Strikethrough:
+			out.WriteString(r.style("9", r.inline(c)))
+		case *ast.Link:
+			label := r.inline(c)
+			out.WriteString(r.style("4", label))
+			if dest := string(c.Destination); dest != label {
+				out.WriteString(" (" + dest + ")")
+			}
+		case *ast.AutoLink:
+			out.WriteString(r.style("4", string(c.URL(r.source))))
+		case *ast.Image:
+			out.WriteString(r.style("2", fmt.Sprintf("[image: %s] (%s)", r.inline(c), c.Destination)))
+		case *extast.TaskCheckBox:
+			if c.IsChecked {
+				out.WriteString("[x] ")
+			} else {
+				out.WriteString("[ ] ")
+			}
+		case *ast.RawHTML:
+			// tags do not mean anything in a terminal
+		default:
+			out.WriteString(r.inline(c))
+		}
+	}
+	return strings.TrimRight(out.String(), " ")
+}
+
+// indent prefixes the first line of s with first and every other non-empty
+// line with rest.
+func indent(s, first, rest string) string {
+	lines := strings.SplitAfter(s, "\n")
+	out := new(strings.Builder)
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			out.WriteString(first + line)
+		case strings.TrimSpace(line) != "":
+			out.WriteString(rest + line)
+		default:
+			out.WriteString(line)
+		}
+	}
+	return out.String()
+}
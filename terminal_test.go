@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRenderTerminal(t *testing.T) {
+	content := "# Title\n\nSome **bold** text.\n\n- one\n- two\n\n![logo](docs/logo.png)\n"
+	for _, tt := range []struct {
+		styled bool
+		want   string
+	}{
+		{true, "\x1b[1;4mTitle\x1b[0m\n\nSome \x1b[1mbold\x1b[0m text.\n\n• one\n• two\n\n\x1b[2m[image: logo] (docs/logo.png)\x1b[0m\n"},
+		{false, "# Title\n\nSome bold text.\n\n• one\n• two\n\n[image: logo] (docs/logo.png)\n"},
+	} {
+		if got := renderTerminal(content, tt.styled); got != tt.want {
+			t.Errorf("styled %v: got %q, want %q", tt.styled, got, tt.want)
+		}
+	}
+}
+
+func TestIsTerminalPiped(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if isTerminal(w) {
+		t.Error("a pipe was taken for a terminal, so its output would be styled")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+)
+
+// requestTrace is where the time of one request went, for -trace. Phases
+// a request skipped, like DNS on a reused connection, stay at zero.
+type requestTrace struct {
+	mu        sync.Mutex
+	url       string
+	start     time.Time
+	dns       time.Duration
+	connect   time.Duration
+	tls       time.Duration
+	firstByte time.Duration
+	reused    bool
+}
+
+var (
+	tracesMu sync.Mutex
+	traces   []*requestTrace
+)
+
+// traceRequest returns req set up to record its timings with
+// net/http/httptrace into a new entry of the run's traces.
+func traceRequest(req *http.Request) *http.Request {
+	t := &requestTrace{url: req.URL.String(), start: time.Now()}
+	tracesMu.Lock()
+	traces = append(traces, t)
+	tracesMu.Unlock()
+
+	// dual-stack dialing may connect to several addresses at once
+	var dnsStart, connectStart, tlsStart time.Time
+	phase := func(at *time.Time, into *time.Duration, start bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if start {
+			*at = time.Now()
+		} else if !at.IsZero() {
+			*into = time.Since(*at)
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { phase(&dnsStart, &t.dns, true) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { phase(&dnsStart, &t.dns, false) },
+		ConnectStart:      func(string, string) { phase(&connectStart, &t.connect, true) },
+		ConnectDone:       func(string, string, error) { phase(&connectStart, &t.connect, false) },
+		TLSHandshakeStart: func() { phase(&tlsStart, &t.tls, true) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { phase(&tlsStart, &t.tls, false) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Since(t.start)
+			t.mu.Unlock()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// reportTraces writes the timings of every request of the run to stderr,
+// whatever -quiet-on-success says, as they were asked for.
+func reportTraces() {
+	if !traceTimings {
+		return
+	}
+	tracesMu.Lock()
+	defer tracesMu.Unlock()
+
+	var dns, connect, handshake, firstByte time.Duration
+	for _, t := range traces {
+		t.mu.Lock()
+		firstByte += t.firstByte
+		// the transport may still have dialed for the pool meanwhile
+		if t.reused {
+			fmt.Fprintf(os.Stderr, "trace: reused connection, first byte %s %s\n", roundTrace(t.firstByte), t.url)
+			t.mu.Unlock()
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "trace: dns %s, connect %s, tls %s, first byte %s %s\n",
+			roundTrace(t.dns), roundTrace(t.connect), roundTrace(t.tls), roundTrace(t.firstByte), t.url)
+		dns += t.dns
+		connect += t.connect
+		handshake += t.tls
+		t.mu.Unlock()
+	}
+	fmt.Fprintf(os.Stderr, "trace: %d requests, dns %s, connect %s, tls %s, first byte %s in total\n",
+		len(traces), roundTrace(dns), roundTrace(connect), roundTrace(handshake), roundTrace(firstByte))
+}
+
+func roundTrace(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
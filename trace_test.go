@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestTraceRequest(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	traceTimings, traces = true, nil
+	t.Cleanup(func() { traceTimings, traces = false, nil })
+
+	for i := 0; i < 2; i++ {
+		resp, err := get(context.Background(), "https://example.com/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a body read to the end lets the connection be reused
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	tracesMu.Lock()
+	defer tracesMu.Unlock()
+	if len(traces) != 2 {
+		t.Fatalf("traced %d requests, want 2", len(traces))
+	}
+	first, second := traces[0], traces[1]
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	if first.reused || first.connect <= 0 || first.firstByte <= 0 {
+		t.Errorf("the first request traced connect %v and first byte %v on a new connection", first.connect, first.firstByte)
+	}
+	if !second.reused || second.firstByte <= 0 {
+		t.Errorf("the second request traced first byte %v, reused %v, want a reused connection", second.firstByte, second.reused)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// transforms are the named markdown processors that -transform chains.
+var transforms = map[string]func(content string) string{
+	"emojify":            emojify,
+	"normalize-headings": normalizeHeadings,
+	"strip-comments":     stripComments,
+	"toc":                insertTOC,
+	"normalize-for-diff": normalizeForDiff,
+}
+
+// parseTransforms checks a comma separated -transform list and returns the
+// processor names in order.
+func parseTransforms(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	names := strings.Split(list, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if _, ok := transforms[names[i]]; !ok {
+			return nil, fmt.Errorf("unknown transform %q", names[i])
+		}
+	}
+	return names, nil
+}
+
+// applyTransforms runs content through the named processors in order.
+func applyTransforms(content string, names []string) string {
+	for _, name := range names {
+		content = transforms[name](content)
+	}
+	return content
+}
+
+var emojiCodes = map[string]string{
+	"+1":               "👍",
+	"-1":               "👎",
+	"bug":              "🐛",
+	"books":            "📚",
+	"bulb":             "💡",
+	"construction":     "🚧",
+	"heart":            "❤️",
+	"memo":             "📝",
+	"package":          "📦",
+	"rocket":           "🚀",
+	"sparkles":         "✨",
+	"star":             "⭐",
+	"tada":             "🎉",
+	"warning":          "⚠️",
+	"white_check_mark": "✅",
+	"wrench":           "🔧",
+	"x":                "❌",
+	"zap":              "⚡",
+}
+
+var emojiRegex = regexp.MustCompile(`:([a-z0-9_+\-]+):`)
+
+// emojify replaces known :shortcode: emoji in the text of the document,
+// leaving code, HTML and link targets alone.
+func emojify(content string) string {
+	source, doc := parseMarkdown(content)
+	// runs of text, joined across the nodes that unmatched emphasis
+	// delimiters like the underscores of :white_check_mark: split them into
+	runs := [][2]int{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.CodeSpan:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			if last := len(runs) - 1; last >= 0 && runs[last][1] == n.Segment.Start {
+				runs[last][1] = n.Segment.Stop
+			} else {
+				runs = append(runs, [2]int{n.Segment.Start, n.Segment.Stop})
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	edits := []edit{}
+	for _, run := range runs {
+		for _, match := range emojiRegex.FindAllSubmatchIndex(source[run[0]:run[1]], -1) {
+			if emoji, ok := emojiCodes[string(source[run[0]+match[2]:run[0]+match[3]])]; ok {
+				edits = append(edits, edit{run[0] + match[0], run[0] + match[1], emoji})
+			}
+		}
+	}
+	return applyEdits(source, edits)
+}
+
+// normalizeHeadings shifts ATX headings so that the shallowest one becomes a
+// level 1 heading, and drops optional closing hashes.
+func normalizeHeadings(content string) string {
+	source, doc := parseMarkdown(content)
+	headings := []*ast.Heading{}
+	shallowest := 7
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if _, _, atx := atxHeading(source, heading); atx {
+			headings = append(headings, heading)
+			if heading.Level < shallowest {
+				shallowest = heading.Level
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+
+	edits := []edit{}
+	for _, heading := range headings {
+		start, stop, _ := atxHeading(source, heading)
+		marked := strings.Repeat("#", heading.Level-shallowest+1) + " " + headingTitle(source, heading)
+		edits = append(edits, edit{start, stop, marked})
+	}
+	return applyEdits(source, edits)
+}
+
+var commentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+// stripComments removes HTML comments, whether they make up an HTML block,
+// share one with other markup or sit inline in the text.
+func stripComments(content string) string {
+	source, doc := parseMarkdown(content)
+	edits := []edit{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.HTMLBlock:
+			if n.Lines().Len() == 0 {
+				break
+			}
+			start, stop := n.Lines().At(0).Start, n.Lines().At(n.Lines().Len()-1).Stop
+			if n.HasClosure() {
+				stop = n.ClosureLine.Stop
+			}
+			for _, match := range commentRegex.FindAllIndex(source[start:stop], -1) {
+				edits = append(edits, edit{start + match[0], start + match[1], ""})
+			}
+		case *ast.RawHTML:
+			start, stop := n.Segments.At(0).Start, n.Segments.At(n.Segments.Len()-1).Stop
+			if bytes.HasPrefix(source[start:stop], []byte("<!--")) {
+				edits = append(edits, edit{start, stop, ""})
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return applyEdits(source, edits)
+}
+
+// insertTOC adds a table of contents linking every heading below the first
+// one, placed right after that first heading.
+func insertTOC(content string) string {
+	source, doc := parseMarkdown(content)
+	type entry struct {
+		level int
+		title string
+	}
+	entries := []entry{}
+	after := -1
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok || heading.Lines().Len() == 0 {
+			return ast.WalkContinue, nil
+		}
+		if after < 0 {
+			after = headingEnd(source, heading)
+		} else {
+			entries = append(entries, entry{heading.Level, headingTitle(source, heading)})
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	if len(entries) == 0 {
+		return content
+	}
+
+	top := 7
+	for _, e := range entries {
+		if e.level < top {
+			top = e.level
+		}
+	}
+	toc := new(strings.Builder)
+	toc.WriteString("\n")
+	for _, e := range entries {
+		fmt.Fprintf(toc, "%s- [%s](#%s)\n", strings.Repeat("  ", e.level-top), e.title, slugify(e.title))
+	}
+	toc.WriteString("\n")
+
+	before := content[:after]
+	if !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+	return before + toc.String() + content[after:]
+}
+
+// parseMarkdown parses content the way it is rendered, for the processors
+// to find what they change in the tree rather than by line.
+func parseMarkdown(content string) ([]byte, ast.Node) {
+	source := []byte(content)
+	return source, mdRenderer.Parser().Parse(text.NewReader(source))
+}
+
+// edit replaces source[start:stop] with text.
+type edit struct {
+	start, stop int
+	text        string
+}
+
+// applyEdits returns source with edits, which must not overlap, made.
+func applyEdits(source []byte, edits []edit) string {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	out := new(strings.Builder)
+	at := 0
+	for _, e := range edits {
+		out.Write(source[at:e.start])
+		out.WriteString(e.text)
+		at = e.stop
+	}
+	out.Write(source[at:])
+	return out.String()
+}
+
+// headingTitle returns the text of heading as written, its lines joined.
+func headingTitle(source []byte, heading *ast.Heading) string {
+	title := []string{}
+	for i := 0; i < heading.Lines().Len(); i++ {
+		segment := heading.Lines().At(i)
+		title = append(title, strings.TrimSpace(string(segment.Value(source))))
+	}
+	return strings.Join(title, " ")
+}
+
+// atxHeading returns where the markers of heading start and where its line
+// ends, before the line break. atx is false for setext headings and for
+// ATX headings without text.
+func atxHeading(source []byte, heading *ast.Heading) (start, stop int, atx bool) {
+	if heading.Lines().Len() == 0 {
+		return 0, 0, false
+	}
+	first := heading.Lines().At(0)
+	line := bytes.LastIndexByte(source[:first.Start], '\n') + 1
+	marker := bytes.IndexByte(source[line:first.Start], '#')
+	if marker < 0 {
+		return 0, 0, false
+	}
+	stop = lineEnd(source, first.Start)
+	return line + marker, len(bytes.TrimRight(source[:stop], "\r\n")), true
+}
+
+// headingEnd returns the offset just past heading, the underline of a
+// setext heading included.
+func headingEnd(source []byte, heading *ast.Heading) int {
+	end := lineEnd(source, heading.Lines().At(heading.Lines().Len()-1).Start)
+	if _, _, atx := atxHeading(source, heading); !atx {
+		end = lineEnd(source, end)
+	}
+	return end
+}
+
+// lineEnd returns the offset past the line break of the line at offset at,
+// or the end of source on its last line.
+func lineEnd(source []byte, at int) int {
+	if at >= len(source) {
+		return len(source)
+	}
+	if i := bytes.IndexByte(source[at:], '\n'); i >= 0 {
+		return at + i + 1
+	}
+	return len(source)
+}
+
+var angleTargetRegex = regexp.MustCompile(`\]\(<([^<>\s]+)>`)
+
+// normalizeForDiff settles the cosmetic choices of content so re-fetches of
+// an unchanged README diff cleanly: LF line endings, no trailing
+// whitespace (a hard line break keeps exactly two spaces), link targets
+// without needless angle brackets, single blank lines and one final
+// newline. Code blocks only get their line endings changed.
+func normalizeForDiff(content string) string {
+	lines := splitLines(normalizeLineEndings(content, "lf", false))
+	code := make([]bool, len(lines))
+	fences := fenceTracker{}
+	for i, line := range lines {
+		code[i] = fences.next(line)
+	}
+
+	out := new(strings.Builder)
+	blank := false
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		if code[i] {
+			out.WriteString(text + "\n")
+			blank = false
+			continue
+		}
+		trimmed := strings.TrimRight(text, " \t")
+		if trimmed == "" {
+			if !blank && out.Len() > 0 {
+				out.WriteString("\n")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		// trailing spaces only mean something as a break inside a paragraph
+		continued := i+1 < len(lines) && !code[i+1] && strings.TrimSpace(lines[i+1]) != ""
+		if strings.HasSuffix(text, "  ") && continued && !headingRegex.MatchString(trimmed) {
+			trimmed += "  "
+		}
+		out.WriteString(angleTargetRegex.ReplaceAllString(trimmed, "]($1") + "\n")
+	}
+	return strings.TrimRight(out.String(), "\n") + "\n"
+}
+
+// mapProse applies fn to every line of content outside of code blocks.
+func mapProse(content string, fn func(line string) string) string {
+	out := new(strings.Builder)
+	fences := fenceTracker{}
+	for _, line := range splitLines(content) {
+		if fences.next(line) {
+			out.WriteString(line)
+			continue
+		}
+		out.WriteString(fn(line))
+	}
+	return out.String()
+}
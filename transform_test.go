@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransformOrder(t *testing.T) {
+	content := "# R\n\n## :rocket: Launch\n"
+
+	// a table of contents made before emojify links the shortcode's anchor
+	pipeline, err := parseTransforms("toc, emojify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := applyTransforms(content, pipeline); !strings.Contains(got, "- [🚀 Launch](#rocket-launch)\n") {
+		t.Errorf("toc,emojify gave %q, want the anchor of the shortcode", got)
+	}
+	pipeline, err = parseTransforms("emojify,toc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := applyTransforms(content, pipeline); !strings.Contains(got, "- [🚀 Launch](#-launch)\n") {
+		t.Errorf("emojify,toc gave %q, want the anchor of the emoji heading", got)
+	}
+
+	if _, err := parseTransforms("toc,shout"); err == nil {
+		t.Error("an unknown transform was accepted")
+	}
+}
+
+func TestTransformsSkipCode(t *testing.T) {
+	content := "## R\n\n<!--\n## Hidden\n-->\n\nRun `:tada:` for :tada:.<!-- note -->\n\n" +
+		"    # not a heading <!-- kept -->\n\n### Usage ###\n"
+	pipeline, err := parseTransforms("normalize-headings,emojify,strip-comments,toc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# R\n\n- [Usage](#usage)\n\n\n\n\nRun `:tada:` for 🎉.\n\n" +
+		"    # not a heading <!-- kept -->\n\n## Usage\n"
+	if got := applyTransforms(content, pipeline); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNormalizeForDiff(t *testing.T) {
+	content := "# R  \r\n\r\n\r\nSee [docs](<docs/guide.md>).\r\nFirst line  \r\nsecond line\t\r\n\r\n```sh\r\necho hi   \r\n```\r\n\r\n\r\n"
+	want := "# R\n\nSee [docs](docs/guide.md).\nFirst line  \nsecond line\n\n```sh\necho hi   \n```\n"
+	if got := normalizeForDiff(content); got != want {
+		t.Errorf("normalizeForDiff() = %q, want %q", got, want)
+	}
+	if got := normalizeForDiff(want); got != want {
+		t.Errorf("normalizing again changed %q to %q", want, got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runValidate checks a previously fetched output directory for consistency
+// without touching the network and prints a pass/fail checklist like
+// doctor does. It reports whether every check passed.
+func runValidate(dir string) bool {
+	readme := readmeName()
+	if _, err := os.Stat(filepath.Join(dir, readme)); err != nil {
+		readme = "README.html"
+	}
+
+	var stamp fetchStamp
+
+	return runChecklist([]checklistItem{
+		{"README is present", func() error {
+			_, err := os.Stat(filepath.Join(dir, readme))
+			return err
+		}, false},
+		{"fetch completed", func() error {
+			// expand.sh is the last file a fetch writes
+			_, err := os.Stat(filepath.Join(dir, "expand.sh"))
+			return err
+		}, false},
+		{"stamp.json is present", func() error {
+			var err error
+			stamp, err = readStamp(dir)
+			if err != nil {
+				return err
+			}
+			if stamp.Repo == "" || stamp.Ref == "" {
+				return fmt.Errorf("it does not name the repository and ref")
+			}
+			return nil
+		}, false},
+		{"files match the checksums of stamp.json", func() error {
+			if len(stamp.Files) == 0 {
+				return errSkipped
+			}
+			return validateChecksums(dir, stamp.Files)
+		}, false},
+		{"local references of the README exist", func() error {
+			dangling, err := verifyLocal(dir, readme)
+			if err != nil {
+				return err
+			}
+			if len(dangling) > 0 {
+				return fmt.Errorf("missing %v", dangling)
+			}
+			return nil
+		}, false},
+		{"sitemap.json matches the files on disk", func() error {
+			return validateSitemap(dir)
+		}, false},
+		{"metadata.json is readable", func() error {
+			return validateJSON(filepath.Join(dir, "metadata.json"), &repoMetadata{})
+		}, false},
+	})
+}
+
+// validateChecksums compares the sha256 of every file of sums, by path
+// relative to dir, with its recorded one.
+func validateChecksums(dir string, sums map[string]string) error {
+	changed := []string{}
+	for file, want := range sums {
+		sum, err := fileSum(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil || sum != want {
+			changed = append(changed, file)
+		}
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		return fmt.Errorf("missing or changed %v", changed)
+	}
+	return nil
+}
+
+func validateSitemap(dir string) error {
+	entries := []sitemapEntry{}
+	err := validateJSON(filepath.Join(dir, "sitemap.json"), &entries)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			return fmt.Errorf("%s is listed but missing", entry.Path)
+		}
+	}
+	return nil
+}
+
+// validateJSON decodes the JSON file into v, returning errSkipped when the
+// file was not written by the fetch.
+func validateJSON(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return errSkipped
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
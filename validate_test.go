@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	serveFiles(t, map[string]string{
+		"github.com/o/r/raw/HEAD/README.md":    "# R\n\n![logo](img/logo.png)\n",
+		"github.com/o/r/raw/HEAD/img/logo.png": "image",
+	})
+	inTempDir(t)
+	src := source{repo: mustRepo(t, "https://github.com/o/r"), ref: "HEAD", readme: "README.md"}
+	if err := fetchRepo(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	stamp, err := readStamp("r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stamp.Files["img/logo.png"]; !ok {
+		t.Errorf("stamp.json has no checksum of img/logo.png: %v", stamp.Files)
+	}
+	if !runValidate("r") {
+		t.Fatal("a fresh fetch does not validate")
+	}
+
+	if err := os.WriteFile(filepath.Join("r", "img", "logo.png"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if runValidate("r") {
+		t.Error("a changed asset validates")
+	}
+
+	if err := os.Remove(filepath.Join("r", stampFile)); err != nil {
+		t.Fatal(err)
+	}
+	if runValidate("r") {
+		t.Error("a bundle without stamp.json validates")
+	}
+}